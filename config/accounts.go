@@ -0,0 +1,109 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvAccounts holds credentials as user:password pairs separated by commas,
+// used when no accounts file is given.
+const EnvAccounts = "DTF_ACCOUNTS"
+
+// LoadAccounts reads credentials from path. The file is either a JSON array
+// of {"username": ..., "password": ...} objects, which may use password_env
+// or password_file like the config file, or has one user:password pair per
+// line; blank lines and lines starting with # are skipped.
+func LoadAccounts(path string) ([]Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &accounts); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for i := range accounts {
+			if err := accounts[i].check(); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if err := accounts[i].resolvePassword(filepath.Dir(path)); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			a, err := parseAccount(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			accounts = append(accounts, a)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateAccounts(accounts); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return accounts, nil
+}
+
+// ParseAccounts parses a comma separated list of user:password pairs, the
+// format of $DTF_ACCOUNTS.
+func ParseAccounts(list string) ([]Account, error) {
+	var accounts []Account
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		a, err := parseAccount(pair)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	if err := validateAccounts(accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func parseAccount(pair string) (Account, error) {
+	user, password, ok := strings.Cut(pair, ":")
+	if !ok || user == "" {
+		return Account{}, fmt.Errorf("expected username:password, got %q", user)
+	}
+	return Account{UserName: user, Password: password}, nil
+}
+
+func validateAccounts(accounts []Account) error {
+	if len(accounts) == 0 {
+		return fmt.Errorf("no accounts")
+	}
+	seen := make(map[string]bool, len(accounts))
+	for i, a := range accounts {
+		if a.UserName == "" {
+			return fmt.Errorf("account %d has no username", i)
+		}
+		if seen[a.UserName] {
+			return fmt.Errorf("account %s is listed twice", a.UserName)
+		}
+		seen[a.UserName] = true
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAccounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []Account
+		wantErr string
+	}{
+		{"single", "alice:secret", []Account{{UserName: "alice", Password: "secret"}}, ""},
+		{"several with spaces", " alice:a , bob:b,", []Account{{UserName: "alice", Password: "a"}, {UserName: "bob", Password: "b"}}, ""},
+		{"colon in password", "alice:a:b", []Account{{UserName: "alice", Password: "a:b"}}, ""},
+		{"empty password", "alice:", []Account{{UserName: "alice"}}, ""},
+		{"empty", " , ", nil, "no accounts"},
+		{"no password", "alice", nil, "expected username:password"},
+		{"no username", ":secret", nil, "expected username:password"},
+		{"duplicate", "alice:a,alice:b", nil, "listed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAccounts(tt.list)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseAccounts error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAccounts: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAccounts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	t.Setenv("TEST_DTF_PASSWORD", "from-env")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []Account
+		wantErr string
+	}{
+		{
+			name:    "text",
+			file:    "accounts.txt",
+			content: "# designers\nalice:a\n\n  bob:b  \n",
+			want:    []Account{{UserName: "alice", Password: "a"}, {UserName: "bob", Password: "b"}},
+		},
+		{
+			name:    "json",
+			file:    "accounts.json",
+			content: `[{"username":"alice","password":"a"},{"username":"bob","password_env":"TEST_DTF_PASSWORD"},{"username":"carol","password_file":"carol.secret"}]`,
+			want: []Account{
+				{UserName: "alice", Password: "a"},
+				{UserName: "bob", Password: "from-env", PasswordEnv: "TEST_DTF_PASSWORD"},
+				{UserName: "carol", Password: "from-file", PasswordFile: "carol.secret"},
+			},
+		},
+		{name: "text line number", file: "accounts.txt", content: "alice:a\nbob\n", wantErr: "accounts.txt:2: expected username:password"},
+		{name: "empty", file: "accounts.txt", content: "# nobody yet\n", wantErr: "no accounts"},
+		{name: "bad json", file: "accounts.json", content: `[{"username":`, wantErr: "unexpected end of JSON input"},
+		{name: "json duplicate", file: "accounts.json", content: `[{"username":"alice"},{"username":"alice"}]`, wantErr: "listed twice"},
+		{name: "json two password sources", file: "accounts.json", content: `[{"username":"alice","password":"a","password_file":"a.secret"}]`, wantErr: "set only one of"},
+		{name: "json unset env", file: "accounts.json", content: `[{"username":"alice","password_env":"TEST_DTF_UNSET"}]`, wantErr: "$TEST_DTF_UNSET is not set"},
+		{name: "json missing file", file: "accounts.json", content: `[{"username":"alice","password_file":"missing.secret"}]`, wantErr: "missing.secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "carol.secret", "from-file\n")
+			path := writeFile(t, dir, tt.file, tt.content)
+
+			got, err := LoadAccounts(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadAccounts error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadAccounts: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAccounts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
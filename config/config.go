@@ -0,0 +1,324 @@
+// Package config loads the named target environments shared by
+// send_webhook and process_image from a JSON file.
+//
+// The repository only ships environments.example.json; copy it to
+// environments.json and keep passwords out of it by naming an environment
+// variable (password_env) or a file (password_file) for each account.
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// DefaultFile is looked up in the working directory and its parent so
+	// the tools work both from the repository root and from their own
+	// directory.
+	DefaultFile = "environments.json"
+	// ExampleFile is the committed template for DefaultFile.
+	ExampleFile = "environments.example.json"
+
+	// EnvName and EnvFile select the environment and config file when the
+	// corresponding flags are not given.
+	EnvName = "DTF_ENV"
+	EnvFile = "DTF_CONFIG"
+)
+
+// Account is a set of credentials for the DTF API. The password is given
+// inline, or read from the environment variable PasswordEnv or the file
+// PasswordFile when the environment is selected.
+type Account struct {
+	UserName     string `json:"username"`
+	Password     string `json:"password,omitempty"`
+	PasswordEnv  string `json:"password_env,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+// check reports accounts that name more than one password source.
+func (a Account) check() error {
+	sources := 0
+	for _, s := range []string{a.Password, a.PasswordEnv, a.PasswordFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("account %s: set only one of password, password_env and password_file", a.UserName)
+	}
+	return nil
+}
+
+// resolvePassword fills in Password from PasswordEnv or PasswordFile. A
+// relative PasswordFile is taken relative to dir.
+func (a *Account) resolvePassword(dir string) error {
+	switch {
+	case a.PasswordEnv != "":
+		v, ok := os.LookupEnv(a.PasswordEnv)
+		if !ok {
+			return fmt.Errorf("account %s: $%s is not set", a.UserName, a.PasswordEnv)
+		}
+		a.Password = v
+	case a.PasswordFile != "":
+		path := a.PasswordFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", a.UserName, err)
+		}
+		a.Password = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
+
+// TLS holds client TLS settings for an environment.
+type TLS struct {
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	CAFile             string `json:"ca_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+}
+
+// Environment is one named deployment the tools can target.
+type Environment struct {
+	Name        string    `json:"-"`
+	Description string    `json:"description,omitempty"`
+	BaseURL     string    `json:"base_url"`
+	WebhookPath string    `json:"webhook_path,omitempty"`
+	Accounts    []Account `json:"accounts,omitempty"`
+	TLS         TLS       `json:"tls,omitempty"`
+
+	// Defaults for send_webhook.
+	RatePerMinute float64 `json:"rate_per_minute,omitempty"`
+	Concurrency   int     `json:"concurrency,omitempty"`
+
+	// Defaults for process_image.
+	Workers      int      `json:"workers,omitempty"`
+	TotalOrders  int      `json:"total_orders,omitempty"`
+	PollInterval Duration `json:"poll_interval,omitempty"`
+	MaxBackoff   Duration `json:"max_backoff,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1s" or "5m".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// File is the decoded config file.
+type File struct {
+	Path         string                  `json:"-"`
+	Defaults     map[string]string       `json:"defaults"`
+	Environments map[string]*Environment `json:"environments"`
+}
+
+// WebhookURL returns the full orders/create webhook endpoint.
+func (e *Environment) WebhookURL() string {
+	return strings.TrimRight(e.BaseURL, "/") + e.WebhookPath
+}
+
+// APIURL returns the base URL without a trailing slash.
+func (e *Environment) APIURL() string {
+	return strings.TrimRight(e.BaseURL, "/")
+}
+
+// TLSConfig builds the client TLS configuration, or nil when the
+// environment uses the defaults.
+func (e *Environment) TLSConfig() (*tls.Config, error) {
+	t := e.TLS
+	if !t.InsecureSkipVerify && t.CAFile == "" && t.ServerName == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %w", e.Name, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("environment %s: no certificates in %s", e.Name, t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// Validate checks an environment for obvious mistakes.
+func (e *Environment) Validate() error {
+	u, err := url.Parse(e.BaseURL)
+	if err != nil {
+		return fmt.Errorf("environment %s: invalid base_url: %w", e.Name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("environment %s: base_url must be http or https, got %q", e.Name, e.BaseURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("environment %s: base_url has no host", e.Name)
+	}
+	if e.WebhookPath != "" && !strings.HasPrefix(e.WebhookPath, "/") {
+		return fmt.Errorf("environment %s: webhook_path must start with /", e.Name)
+	}
+	if e.RatePerMinute < 0 {
+		return fmt.Errorf("environment %s: rate_per_minute must not be negative", e.Name)
+	}
+	if e.Concurrency < 0 || e.Workers < 0 || e.TotalOrders < 0 {
+		return fmt.Errorf("environment %s: concurrency, workers and total_orders must not be negative", e.Name)
+	}
+	if e.PollInterval < 0 || e.MaxBackoff < 0 {
+		return fmt.Errorf("environment %s: poll_interval and max_backoff must not be negative", e.Name)
+	}
+	for i, a := range e.Accounts {
+		if a.UserName == "" {
+			return fmt.Errorf("environment %s: account %d has no username", e.Name, i)
+		}
+		if err := a.check(); err != nil {
+			return fmt.Errorf("environment %s: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f.Path = path
+
+	for name, env := range f.Environments {
+		if env == nil {
+			return nil, fmt.Errorf("%s: environment %s is empty", path, name)
+		}
+		env.Name = name
+		if err := env.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for tool, name := range f.Defaults {
+		if _, ok := f.Environments[name]; !ok {
+			return nil, fmt.Errorf("%s: default for %s refers to unknown environment %q", path, tool, name)
+		}
+	}
+	return &f, nil
+}
+
+// Find locates the config file: an explicit path, then $DTF_CONFIG, then
+// DefaultFile in the working directory or its parent. It returns "" when
+// no file exists.
+func Find(path string) string {
+	if path != "" {
+		return path
+	}
+	if p := os.Getenv(EnvFile); p != "" {
+		return p
+	}
+	for _, p := range []string{DefaultFile, filepath.Join("..", DefaultFile)} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// Select returns the environment called name, falling back to $DTF_ENV and
+// then to the default configured for tool. The passwords of its accounts
+// are read from their environment variables or files.
+func (f *File) Select(name, tool string) (*Environment, error) {
+	if name == "" {
+		name = os.Getenv(EnvName)
+	}
+	if name == "" {
+		name = f.Defaults[tool]
+	}
+	if name == "" {
+		return nil, errors.New("no environment selected and no default configured")
+	}
+
+	env, ok := f.Environments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment %q (available: %s)", name, strings.Join(f.Names(), ", "))
+	}
+	for i := range env.Accounts {
+		if err := env.Accounts[i].resolvePassword(filepath.Dir(f.Path)); err != nil {
+			return nil, fmt.Errorf("environment %s: %w", env.Name, err)
+		}
+	}
+	return env, nil
+}
+
+// Names returns the environment names in sorted order.
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Environments))
+	for name := range f.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List prints a table of all environments, marking the default for tool.
+func (f *File) List(w io.Writer, tool string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tBASE URL\tWEBHOOK PATH\tACCOUNTS\tDESCRIPTION")
+	for _, name := range f.Names() {
+		env := f.Environments[name]
+		marker := ""
+		if f.Defaults[tool] == name {
+			marker = " *"
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\t%d\t%s\n", name, marker, env.BaseURL, env.WebhookPath, len(env.Accounts), env.Description)
+	}
+	tw.Flush()
+}
+
+// IsFlagSet reports whether the named command line flag was given
+// explicitly, so environment defaults never override the user.
+func IsFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"defaults":{"send_webhook":"a"},"environments":{"a":{"base_url":"https://a.example","poll_interval":"50ms"}}}`, ""},
+		{"bad json", `{"environments":`, "unexpected end of JSON input"},
+		{"empty environment", `{"environments":{"a":null}}`, "environment a is empty"},
+		{"unknown default", `{"defaults":{"send_webhook":"b"},"environments":{"a":{"base_url":"https://a.example"}}}`, `unknown environment "b"`},
+		{"bad scheme", `{"environments":{"a":{"base_url":"ftp://a.example"}}}`, "must be http or https"},
+		{"no host", `{"environments":{"a":{"base_url":"https://"}}}`, "has no host"},
+		{"webhook path", `{"environments":{"a":{"base_url":"https://a.example","webhook_path":"hooks"}}}`, "must start with /"},
+		{"negative rate", `{"environments":{"a":{"base_url":"https://a.example","rate_per_minute":-1}}}`, "rate_per_minute"},
+		{"bad duration", `{"environments":{"a":{"base_url":"https://a.example","poll_interval":5}}}`, "duration must be a string"},
+		{"account without username", `{"environments":{"a":{"base_url":"https://a.example","accounts":[{"password":"x"}]}}}`, "has no username"},
+		{"two password sources", `{"environments":{"a":{"base_url":"https://a.example","accounts":[{"username":"u","password":"x","password_env":"X"}]}}}`, "set only one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), DefaultFile, tt.content)
+			f, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			env := f.Environments["a"]
+			if env.Name != "a" || time.Duration(env.PollInterval) != 50*time.Millisecond {
+				t.Errorf("environment = %+v", env)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "designer.secret", "from-file\n")
+	path := writeFile(t, dir, DefaultFile, `{
+		"defaults": {"send_webhook": "a", "process_image": "b"},
+		"environments": {
+			"a": {"base_url": "https://a.example/"},
+			"b": {"base_url": "https://b.example", "accounts": [
+				{"username": "admin", "password": "inline"},
+				{"username": "designer1", "password_env": "TEST_DTF_PASSWORD"},
+				{"username": "designer2", "password_file": "designer.secret"}
+			]},
+			"c": {"base_url": "https://c.example", "accounts": [
+				{"username": "admin", "password_env": "TEST_DTF_UNSET"}
+			]}
+		}
+	}`)
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_DTF_PASSWORD", "from-env")
+	os.Unsetenv("TEST_DTF_UNSET")
+
+	tests := []struct {
+		name, env, tool string
+		envVar          string
+		want            string
+		wantErr         string
+	}{
+		{name: "explicit", env: "a", tool: "process_image", want: "a"},
+		{name: "tool default", tool: "process_image", want: "b"},
+		{name: "DTF_ENV", tool: "send_webhook", envVar: "b", want: "b"},
+		{name: "unknown", env: "x", tool: "send_webhook", wantErr: `unknown environment "x"`},
+		{name: "no default", tool: "other", wantErr: "no environment selected"},
+		{name: "unset password_env", env: "c", tool: "send_webhook", wantErr: "$TEST_DTF_UNSET is not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvName, tt.envVar)
+			env, err := f.Select(tt.env, tt.tool)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Select error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Select: %v", err)
+			}
+			if env.Name != tt.want {
+				t.Errorf("Select = %s, want %s", env.Name, tt.want)
+			}
+		})
+	}
+
+	env, err := f.Select("b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"inline", "from-env", "from-file"}
+	for i, a := range env.Accounts {
+		if a.Password != want[i] {
+			t.Errorf("account %s password = %q, want %q", a.UserName, a.Password, want[i])
+		}
+	}
+	if got := env.APIURL(); got != "https://b.example" {
+		t.Errorf("APIURL = %q", got)
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	f, err := Load(filepath.Join("..", ExampleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range f.Names() {
+		for _, a := range f.Environments[name].Accounts {
+			if a.Password != "" {
+				t.Errorf("%s: environment %s has a plaintext password for %s", ExampleFile, name, a.UserName)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package dtfapi is a client for the DTF API used by the designer tools:
+// login, the order queue, product processing, designer approval and
+// presigned uploads.
+package dtfapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"test_webhook_service/httpclient"
+)
+
+// Client calls the DTF API at BaseURL. Token, when set, is sent as a
+// bearer token. A Client belongs to one session; sessions can share the
+// HTTP client.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	Token   string
+	// WithRoute, when set, is given the route template of requests whose
+	// path holds IDs, such as /orders/{order_id}/designer, and returns the
+	// context to send them with. Tracing uses it to name spans.
+	WithRoute func(ctx context.Context, route string) context.Context
+}
+
+// New returns a client for baseURL. A nil hc uses http.DefaultClient.
+func New(baseURL string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTP: hc}
+}
+
+// APIError is a response with a non-2xx status.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the API's message when the body was a JSON envelope.
+	Message string
+	// RetryAfter is set when the API asked the client to slow down.
+	RetryAfter time.Duration
+	Throttled  bool
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s returned status: %d", e.Method, e.Path, e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// IsUnauthorized reports whether err is a 401, meaning the token or
+// credentials are not accepted.
+func IsUnauthorized(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusUnauthorized
+}
+
+// IsForbidden reports whether err is a 403. Some deployments answer a
+// revoked token with 403; with a valid token it means the account's role
+// does not allow the call.
+func IsForbidden(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusForbidden
+}
+
+// IsThrottled reports whether err asked the client to back off and for
+// how long.
+func IsThrottled(err error) (time.Duration, bool) {
+	var ae *APIError
+	if errors.As(err, &ae) && ae.Throttled {
+		return ae.RetryAfter, true
+	}
+	return 0, false
+}
+
+// IsNotFound reports whether err is a 404 or 405, which is how an API
+// without an endpoint answers.
+func IsNotFound(err error) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && (ae.StatusCode == http.StatusNotFound || ae.StatusCode == http.StatusMethodNotAllowed)
+}
+
+// Login authenticates with a username and password.
+func (c *Client) Login(ctx context.Context, userName, password string) (*AuthResponse, error) {
+	var out Response[AuthResponse]
+	if err := c.do(ctx, "POST", "/auth/login", LoginRequest{UserName: userName, Password: password}, &out); err != nil {
+		return nil, err
+	}
+	if out.Data.AccessToken == "" {
+		return nil, errors.New("login response has no access token")
+	}
+	return &out.Data, nil
+}
+
+// Refresh exchanges a refresh token for a new access token.
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+	var out Response[AuthResponse]
+	if err := c.do(ctx, "POST", "/auth/refresh", RefreshRequest{RefreshToken: refreshToken}, &out); err != nil {
+		return nil, err
+	}
+	if out.Data.AccessToken == "" {
+		return nil, errors.New("refresh response has no access token")
+	}
+	return &out.Data, nil
+}
+
+// NextOrder claims the next order in the queue and returns its products.
+// The result is empty when the queue is.
+func (c *Client) NextOrder(ctx context.Context) ([]OrderProductsResponse, error) {
+	var out Response[[]OrderProductsResponse]
+	if err := c.do(ctx, "GET", "/orders/next", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Data, nil
+}
+
+// ProcessOrderProduct attaches the final image to one product of an order.
+func (c *Client) ProcessOrderProduct(ctx context.Context, orderID int64, fulfillmentID, finalImgURL string) error {
+	path := fmt.Sprintf("/orders/%d/products/%s", orderID, fulfillmentID)
+	ctx = c.withRoute(ctx, "/orders/{order_id}/products/{fulfillment_id}")
+	return c.do(ctx, "POST", path, ProcessOrderProductRequest{FinalImgUrl: finalImgURL}, nil)
+}
+
+// ApproveDesigner approves an order whose products have all been
+// processed.
+func (c *Client) ApproveDesigner(ctx context.Context, orderID int64) error {
+	ctx = c.withRoute(ctx, "/orders/{order_id}/designer")
+	return c.do(ctx, "POST", fmt.Sprintf("/orders/%d/designer", orderID), nil, nil)
+}
+
+// Presign requests an upload URL for key.
+func (c *Client) Presign(ctx context.Context, key string) (*PresignedResponse, error) {
+	var out Response[PresignedResponse]
+	if err := c.do(ctx, "POST", "/image/presigned", PresignedRequest{Key: key}, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// PresignMultipart starts a multipart upload of key in parts parts and
+// returns an upload URL for each.
+func (c *Client) PresignMultipart(ctx context.Context, key string, parts int) (*MultipartResponse, error) {
+	var out Response[MultipartResponse]
+	if err := c.do(ctx, "POST", "/image/presigned/multipart", MultipartRequest{Key: key, Parts: parts}, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// CompleteMultipart assembles the uploaded parts into the object.
+func (c *Client) CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	return c.do(ctx, "POST", "/image/presigned/multipart/complete", CompleteMultipartRequest{Key: key, UploadID: uploadID, Parts: parts}, nil)
+}
+
+// AbortMultipart discards the parts of an unfinished multipart upload.
+func (c *Client) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	return c.do(ctx, "POST", "/image/presigned/multipart/abort", AbortMultipartRequest{Key: key, UploadID: uploadID}, nil)
+}
+
+func (c *Client) withRoute(ctx context.Context, route string) context.Context {
+	if c.WithRoute == nil {
+		return ctx
+	}
+	return c.WithRoute(ctx, route)
+}
+
+// do sends in as JSON, decodes a 2xx answer into out when it is not nil and
+// always drains and closes the body.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		ae := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		ae.RetryAfter, ae.Throttled = httpclient.IsThrottled(resp)
+		var envelope Response[json.RawMessage]
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&envelope) == nil {
+			ae.Message = envelope.Message
+		}
+		return ae
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package dtfapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorded is a request as the test server saw it.
+type recorded struct {
+	Method string
+	Path   string
+	Auth   string
+	Body   map[string]any
+}
+
+// newTestServer answers every request with status and body and records
+// the requests it got.
+func newTestServer(t *testing.T, status int, header http.Header, body string) (*Client, *[]recorded) {
+	t.Helper()
+	var got []recorded
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := recorded{Method: r.Method, Path: r.URL.Path, Auth: r.Header.Get("Authorization")}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s %s sent a body as %q", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+			}
+			if err := json.Unmarshal(data, &rec.Body); err != nil {
+				t.Errorf("%s %s sent invalid JSON %q: %v", r.Method, r.URL.Path, data, err)
+			}
+		}
+		got = append(got, rec)
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", srv.Client()), &got
+}
+
+func TestClientRequests(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		call func(c *Client) error
+		want recorded
+	}{
+		{
+			name: "login",
+			call: func(c *Client) error { _, err := c.Login(ctx, "designer1", "secret"); return err },
+			want: recorded{Method: "POST", Path: "/auth/login", Body: map[string]any{"username": "designer1", "password": "secret"}},
+		},
+		{
+			name: "refresh",
+			call: func(c *Client) error { _, err := c.Refresh(ctx, "r1"); return err },
+			want: recorded{Method: "POST", Path: "/auth/refresh", Body: map[string]any{"refresh_token": "r1"}},
+		},
+		{
+			name: "next order",
+			call: func(c *Client) error { _, err := c.NextOrder(ctx); return err },
+			want: recorded{Method: "GET", Path: "/orders/next"},
+		},
+		{
+			name: "process order product",
+			call: func(c *Client) error { return c.ProcessOrderProduct(ctx, 42, "F42-1", "https://cdn.example/p.pdf") },
+			want: recorded{Method: "POST", Path: "/orders/42/products/F42-1", Body: map[string]any{"final_img_url": "https://cdn.example/p.pdf"}},
+		},
+		{
+			name: "approve designer",
+			call: func(c *Client) error { return c.ApproveDesigner(ctx, 42) },
+			want: recorded{Method: "POST", Path: "/orders/42/designer"},
+		},
+		{
+			name: "presign",
+			call: func(c *Client) error { _, err := c.Presign(ctx, "F42-1.pdf"); return err },
+			want: recorded{Method: "POST", Path: "/image/presigned", Body: map[string]any{"key": "F42-1.pdf"}},
+		},
+		{
+			name: "presign multipart",
+			call: func(c *Client) error { _, err := c.PresignMultipart(ctx, "F42-1.pdf", 3); return err },
+			want: recorded{Method: "POST", Path: "/image/presigned/multipart", Body: map[string]any{"key": "F42-1.pdf", "parts": 3.0}},
+		},
+		{
+			name: "complete multipart",
+			call: func(c *Client) error {
+				return c.CompleteMultipart(ctx, "F42-1.pdf", "u1", []CompletedPart{{PartNumber: 1, ETag: `"e1"`}})
+			},
+			want: recorded{Method: "POST", Path: "/image/presigned/multipart/complete", Body: map[string]any{
+				"key": "F42-1.pdf", "upload_id": "u1", "parts": []any{map[string]any{"part_number": 1.0, "etag": `"e1"`}},
+			}},
+		},
+		{
+			name: "abort multipart",
+			call: func(c *Client) error { return c.AbortMultipart(ctx, "F42-1.pdf", "u1") },
+			want: recorded{Method: "POST", Path: "/image/presigned/multipart/abort", Body: map[string]any{"key": "F42-1.pdf", "upload_id": "u1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"data":{"access_token":"a1"}}`
+			if tt.want.Path == "/orders/next" {
+				body = `{"data":[]}`
+			}
+			c, got := newTestServer(t, http.StatusOK, nil, body)
+			c.Token = "t1"
+			if err := tt.call(c); err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			if len(*got) != 1 {
+				t.Fatalf("server got %d requests, want 1", len(*got))
+			}
+			tt.want.Auth = "Bearer t1"
+			if !reflect.DeepEqual((*got)[0], tt.want) {
+				t.Errorf("request = %+v, want %+v", (*got)[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestClientNoToken(t *testing.T) {
+	c, got := newTestServer(t, http.StatusOK, nil, `{"data":{"access_token":"a1"}}`)
+	if _, err := c.Login(context.Background(), "u", "p"); err != nil {
+		t.Fatal(err)
+	}
+	if auth := (*got)[0].Auth; auth != "" {
+		t.Errorf("Authorization = %q without a token, want none", auth)
+	}
+}
+
+func TestClientResponses(t *testing.T) {
+	c, _ := newTestServer(t, http.StatusOK, nil, `{"data":{"access_token":"a1","refresh_token":"r1","expires_in":900,"role":"designer"},"message":"ok"}`)
+	auth, err := c.Login(context.Background(), "u", "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AuthResponse{AccessToken: "a1", RefreshToken: "r1", ExpiresIn: 900, Role: "designer"}
+	if *auth != want {
+		t.Errorf("Login = %+v, want %+v", *auth, want)
+	}
+
+	c, _ = newTestServer(t, http.StatusOK, nil, `{"data":[{"id":1,"order_id":7,"fulfillment_id":"F7-1","variant_title":"22x100","quantity":2}]}`)
+	products, err := c.NextOrder(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].OrderID != 7 || products[0].FulfillmentID != "F7-1" || products[0].Quantity != 2 {
+		t.Errorf("NextOrder = %+v", products)
+	}
+
+	c, _ = newTestServer(t, http.StatusOK, nil, `{"timestamp":"2025-01-01T00:00:00Z"}`)
+	if products, err := c.NextOrder(context.Background()); err != nil || len(products) != 0 {
+		t.Errorf("NextOrder on an empty queue = %v, %v, want no products", products, err)
+	}
+
+	c, _ = newTestServer(t, http.StatusOK, nil, `{"data":{}}`)
+	if _, err := c.Login(context.Background(), "u", "p"); err == nil || !strings.Contains(err.Error(), "no access token") {
+		t.Errorf("Login without a token = %v, want no access token error", err)
+	}
+	if _, err := c.Refresh(context.Background(), "r"); err == nil || !strings.Contains(err.Error(), "no access token") {
+		t.Errorf("Refresh without a token = %v, want no access token error", err)
+	}
+
+	c, _ = newTestServer(t, http.StatusOK, nil, `<html>`)
+	if _, err := c.Presign(context.Background(), "k"); err == nil || !strings.Contains(err.Error(), "failed to decode response") {
+		t.Errorf("Presign with an HTML answer = %v, want a decode error", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		header         http.Header
+		body           string
+		wantMessage    string
+		wantUnauth     bool
+		wantForbidden  bool
+		wantNotFound   bool
+		wantThrottled  bool
+		wantRetryAfter time.Duration
+	}{
+		{name: "401", status: 401, body: `{"message":"token expired"}`, wantMessage: "token expired", wantUnauth: true},
+		{name: "403", status: 403, body: `{"message":"role viewer may not do this"}`, wantMessage: "role viewer may not do this", wantForbidden: true},
+		{name: "404", status: 404, body: `404 page not found`, wantNotFound: true},
+		{name: "405", status: 405, wantNotFound: true},
+		{name: "429 with Retry-After", status: 429, header: http.Header{"Retry-After": {"3"}}, wantThrottled: true, wantRetryAfter: 3 * time.Second},
+		{name: "503 with Retry-After", status: 503, header: http.Header{"Retry-After": {"2"}}, wantThrottled: true, wantRetryAfter: 2 * time.Second},
+		{name: "503 without Retry-After", status: 503},
+		{name: "500", status: 500, body: `{"message":"boom","timestamp":"2025-01-01T00:00:00Z"}`, wantMessage: "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestServer(t, tt.status, tt.header, tt.body)
+			err := c.ApproveDesigner(context.Background(), 9)
+
+			ae, ok := err.(*APIError)
+			if !ok {
+				t.Fatalf("error = %T %v, want *APIError", err, err)
+			}
+			if ae.StatusCode != tt.status || ae.Method != "POST" || ae.Path != "/orders/9/designer" || ae.Message != tt.wantMessage {
+				t.Errorf("APIError = %+v", ae)
+			}
+			if got := IsUnauthorized(err); got != tt.wantUnauth {
+				t.Errorf("IsUnauthorized = %v, want %v", got, tt.wantUnauth)
+			}
+			if got := IsForbidden(err); got != tt.wantForbidden {
+				t.Errorf("IsForbidden = %v, want %v", got, tt.wantForbidden)
+			}
+			if got := IsNotFound(err); got != tt.wantNotFound {
+				t.Errorf("IsNotFound = %v, want %v", got, tt.wantNotFound)
+			}
+			d, throttled := IsThrottled(err)
+			if throttled != tt.wantThrottled || d != tt.wantRetryAfter {
+				t.Errorf("IsThrottled = %v, %v, want %v, %v", d, throttled, tt.wantRetryAfter, tt.wantThrottled)
+			}
+		})
+	}
+}
+
+func TestErrorHelpersWrapped(t *testing.T) {
+	err := &APIError{Method: "GET", Path: "/orders/next", StatusCode: 401, Message: "invalid token"}
+	wrapped := fmt.Errorf("login: %w", err)
+	if !IsUnauthorized(wrapped) {
+		t.Error("IsUnauthorized does not see through wrapping")
+	}
+	if want := "GET /orders/next returned status: 401: invalid token"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if IsUnauthorized(nil) || IsForbidden(io.EOF) || IsNotFound(nil) {
+		t.Error("helpers matched a non-API error")
+	}
+}
+
+type routeKey struct{}
+
+// routeTransport records the route each request's context carries.
+type routeTransport struct {
+	next   http.RoundTripper
+	routes []string
+}
+
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route, _ := req.Context().Value(routeKey{}).(string)
+	t.routes = append(t.routes, route)
+	return t.next.RoundTrip(req)
+}
+
+func TestClientWithRoute(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestServer(t, http.StatusOK, nil, `{"data":[]}`)
+	if _, err := c.NextOrder(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApproveDesigner(ctx, 42); err != nil {
+		t.Fatalf("ApproveDesigner without a WithRoute hook: %v", err)
+	}
+
+	rt := &routeTransport{next: c.HTTP.Transport}
+	c.HTTP = &http.Client{Transport: rt}
+	c.WithRoute = func(ctx context.Context, route string) context.Context {
+		return context.WithValue(ctx, routeKey{}, route)
+	}
+	if _, err := c.NextOrder(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ProcessOrderProduct(ctx, 42, "F1", "https://cdn.example/final.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApproveDesigner(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "/orders/{order_id}/products/{fulfillment_id}", "/orders/{order_id}/designer"}
+	if !reflect.DeepEqual(rt.routes, want) {
+		t.Errorf("routes = %q, want %q", rt.routes, want)
+	}
+}
@@ -0,0 +1,104 @@
+package dtfapi
+
+import "time"
+
+// Response is the envelope every DTF API endpoint answers with.
+type Response[T any] struct {
+	Data      T         `json:"data,omitempty" doc:"Response data"`
+	Message   string    `json:"message,omitempty" doc:"Human-readable message"`
+	Timestamp time.Time `json:"timestamp" doc:"Response timestamp"`
+}
+
+type LoginRequest struct {
+	UserName string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type PresignedRequest struct {
+	Key string `json:"key"`
+}
+
+type ProcessOrderProductRequest struct {
+	FinalImgUrl string `json:"final_img_url"`
+}
+
+type AuthResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	UserName     string `json:"user_name"`
+	UserId       int    `json:"user_id"`
+	Role         string `json:"role"`
+	Name         string `json:"name"`
+}
+
+type OrderProductsResponse struct {
+	ID             int64     `json:"id"`
+	OrderID        int64     `json:"order_id"`
+	FulfillmentID  string    `json:"fulfillment_id"`
+	ProductID      int64     `json:"product_id"`
+	Sku            string    `json:"sku"`
+	VariantTitle   string    `json:"variant_title,omitempty"`
+	CustomerImgUrl string    `json:"customer_img_url"`
+	FinalImgUrl    string    `json:"final_img_url"`
+	Processed      bool      `json:"processed"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at,omitempty"`
+	Quantity       int32     `json:"quantity"`
+}
+
+type PresignedResponse struct {
+	URL         string `json:"upload_url"`
+	DownloadURL string `json:"download_url"`
+	ExpiresAt   string `json:"expires_at"`
+	Key         string `json:"key"`
+}
+
+// Expires returns ExpiresAt, or the zero time when it is missing or not
+// RFC 3339.
+func (p *PresignedResponse) Expires() time.Time {
+	t, _ := time.Parse(time.RFC3339, p.ExpiresAt)
+	return t
+}
+
+type MultipartRequest struct {
+	Key   string `json:"key"`
+	Parts int    `json:"parts"`
+}
+
+// MultipartResponse starts a multipart upload: PartURLs[i] receives part
+// i+1.
+type MultipartResponse struct {
+	UploadID    string   `json:"upload_id"`
+	PartURLs    []string `json:"part_urls"`
+	DownloadURL string   `json:"download_url"`
+	ExpiresAt   string   `json:"expires_at"`
+	Key         string   `json:"key"`
+}
+
+// Expires returns ExpiresAt, or the zero time when it is missing or not
+// RFC 3339.
+func (m *MultipartResponse) Expires() time.Time {
+	t, _ := time.Parse(time.RFC3339, m.ExpiresAt)
+	return t
+}
+
+type CompletedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+type CompleteMultipartRequest struct {
+	Key      string          `json:"key"`
+	UploadID string          `json:"upload_id"`
+	Parts    []CompletedPart `json:"parts"`
+}
+
+type AbortMultipartRequest struct {
+	Key      string `json:"key"`
+	UploadID string `json:"upload_id"`
+}
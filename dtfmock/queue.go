@@ -0,0 +1,193 @@
+package dtfmock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"test_webhook_service/dtfapi"
+)
+
+type orderState int
+
+const (
+	orderQueued orderState = iota
+	orderClaimed
+	orderApproved
+)
+
+// order is one queued order and its products.
+type order struct {
+	ID        int64
+	State     orderState
+	ClaimedBy string
+	Products  []*dtfapi.OrderProductsResponse
+}
+
+// queue is the in-memory order queue. Orders are handed out in the order
+// they were added.
+type queue struct {
+	mu      sync.Mutex
+	orders  map[int64]*order
+	pending []int64
+	nextID  int64
+}
+
+func newQueue() *queue {
+	return &queue{orders: make(map[int64]*order), nextID: 1}
+}
+
+// product describes one product of an order being added.
+type product struct {
+	ProductID    int64
+	Sku          string
+	VariantTitle string
+	ImageURL     string
+	Quantity     int32
+}
+
+// Add queues an order. id 0 picks the next free one. Adding an order that
+// already exists is a no-op, like a webhook delivered twice, and reports
+// false.
+func (q *queue) Add(id int64, products []product) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if id == 0 {
+		for q.orders[q.nextID] != nil {
+			q.nextID++
+		}
+		id = q.nextID
+	}
+	if q.orders[id] != nil {
+		return id, false
+	}
+
+	now := time.Now()
+	o := &order{ID: id}
+	for i, p := range products {
+		o.Products = append(o.Products, &dtfapi.OrderProductsResponse{
+			ID:             id*1000 + int64(i),
+			OrderID:        id,
+			FulfillmentID:  fmt.Sprintf("F%d-%d", id, i+1),
+			ProductID:      p.ProductID,
+			Sku:            p.Sku,
+			VariantTitle:   p.VariantTitle,
+			CustomerImgUrl: p.ImageURL,
+			Quantity:       p.Quantity,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+	}
+	q.orders[id] = o
+	q.pending = append(q.pending, id)
+	return id, true
+}
+
+// Next claims the oldest queued order for user.
+func (q *queue) Next(user string) []dtfapi.OrderProductsResponse {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return []dtfapi.OrderProductsResponse{}
+	}
+	o := q.orders[q.pending[0]]
+	q.pending = q.pending[1:]
+	o.State = orderClaimed
+	o.ClaimedBy = user
+	return copyProducts(o)
+}
+
+// queueError is a rejected queue operation and the status it maps to.
+type queueError struct {
+	status int
+	msg    string
+}
+
+func (e *queueError) Error() string { return e.msg }
+
+// Process records the final image for a product of a claimed order.
+func (q *queue) Process(user string, orderID int64, fulfillmentID, finalURL string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	o, err := q.claimed(user, orderID)
+	if err != nil {
+		return err
+	}
+	for _, p := range o.Products {
+		if p.FulfillmentID == fulfillmentID {
+			p.FinalImgUrl = finalURL
+			p.Processed = true
+			p.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return &queueError{404, fmt.Sprintf("order %d has no product %s", orderID, fulfillmentID)}
+}
+
+// Approve marks a claimed order whose products are all processed as
+// approved by the designer.
+func (q *queue) Approve(user string, orderID int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	o, err := q.claimed(user, orderID)
+	if err != nil {
+		return err
+	}
+	for _, p := range o.Products {
+		if !p.Processed {
+			return &queueError{409, fmt.Sprintf("product %s is not processed", p.FulfillmentID)}
+		}
+	}
+	o.State = orderApproved
+	return nil
+}
+
+// claimed returns the order if user is working on it. Callers hold q.mu.
+func (q *queue) claimed(user string, orderID int64) (*order, error) {
+	o := q.orders[orderID]
+	switch {
+	case o == nil:
+		return nil, &queueError{404, fmt.Sprintf("order %d not found", orderID)}
+	case o.State == orderApproved:
+		return nil, &queueError{409, fmt.Sprintf("order %d is already approved", orderID)}
+	case o.State != orderClaimed || o.ClaimedBy != user:
+		return nil, &queueError{403, fmt.Sprintf("order %d is not assigned to %s", orderID, user)}
+	}
+	return o, nil
+}
+
+// QueueStats counts orders by state.
+type QueueStats struct {
+	Queued   int `json:"queued"`
+	Claimed  int `json:"claimed"`
+	Approved int `json:"approved"`
+}
+
+func (q *queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var s QueueStats
+	for _, o := range q.orders {
+		switch o.State {
+		case orderQueued:
+			s.Queued++
+		case orderClaimed:
+			s.Claimed++
+		case orderApproved:
+			s.Approved++
+		}
+	}
+	return s
+}
+
+func copyProducts(o *order) []dtfapi.OrderProductsResponse {
+	out := make([]dtfapi.OrderProductsResponse, len(o.Products))
+	for i, p := range o.Products {
+		out[i] = *p
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package dtfmock
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"test_webhook_service/samples"
+)
+
+// SamplePath is where the mock serves sample gangsheets. Seeded orders
+// point at it, so process_image can download customer images without the
+// network.
+const SamplePath = "/samples/"
+
+// DefaultSampleDPI is the resolution sample gangsheets are served at; it
+// matches what process_image's preflight requires by default.
+const DefaultSampleDPI = 300
+
+// maxSampleInches caps the area of a served sample, enough for a 22x1000
+// inch sheet.
+const maxSampleInches = 22 * 1000
+
+var sampleName = regexp.MustCompile(`^tmp-img-[A-Za-z0-9]+-\d+-(\d+)x(\d+)\.png$`)
+
+// sampleURL returns the mock's URL for a sample image named like the
+// hosted ones, or img unchanged when it is not a sample.
+func sampleURL(img string) string {
+	name := img[strings.LastIndex(img, "/")+1:]
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	if !sampleName.MatchString(name) {
+		return img
+	}
+	return SamplePath + name
+}
+
+// absoluteURL resolves a path on the mock against the request's host.
+func absoluteURL(r *http.Request, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, path)
+}
+
+// sample serves a generated gangsheet for names like
+// tmp-img-ABC-3-22x20.png, sized for the sheet at SampleDPI.
+func (s *Server) sample(w http.ResponseWriter, r *http.Request) {
+	m := sampleName.FindStringSubmatch(r.PathValue("name"))
+	if m == nil {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	wi, _ := strconv.Atoi(m[1])
+	hi, _ := strconv.Atoi(m[2])
+	if wi < 1 || hi < 1 || wi*hi > maxSampleInches {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	samples.WritePNG(w, wi*s.cfg.SampleDPI, hi*s.cfg.SampleDPI, s.cfg.SampleDPI)
+}
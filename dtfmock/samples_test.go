@@ -0,0 +1,98 @@
+package dtfmock
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	_, api := newTestAPI(t, Config{SampleDPI: 20})
+	base := strings.TrimSuffix(api.BaseURL, "/")
+	tests := []struct {
+		name       string
+		wantStatus int
+		wantW      int
+		wantH      int
+	}{
+		{"tmp-img-ABC-1-22x5.png", http.StatusOK, 440, 100},
+		{"tmp-img-ABC-28-22x1000.png", http.StatusOK, 440, 20000},
+		{"tmp-img-ABC-1-22x5.jpg", http.StatusNotFound, 0, 0},
+		{"tmp-img-ABC-1-0x5.png", http.StatusNotFound, 0, 0},
+		{"tmp-img-ABC-1-200x200.png", http.StatusNotFound, 0, 0},
+		{"customer.png", http.StatusNotFound, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := api.HTTP.Get(base + SamplePath + tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s = %s, want %d", tt.name, resp.Status, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			img, err := png.Decode(resp.Body)
+			if err != nil {
+				t.Fatalf("sample does not decode: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Errorf("sample is %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+			if _, ok := img.(*image.Paletted); !ok {
+				t.Errorf("sample decodes as %T, want a paletted image", img)
+			}
+			// Boxes are opaque, the gaps between them transparent.
+			if _, _, _, a := img.At(10, 10).RGBA(); a != 0xffff {
+				t.Errorf("box pixel alpha = %#x, want opaque", a)
+			}
+			if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+				t.Errorf("gap pixel alpha = %#x, want transparent", a)
+			}
+		})
+	}
+}
+
+func TestSeedServesSamples(t *testing.T) {
+	mock, api := newTestAPI(t, Config{SampleDPI: 10})
+	mock.Seed(1)
+	mock.AddOrder("https://cdn.example/samples/tmp-img-ABC-2-22x10.png?v=1", "https://cdn.example/customer-22x10.png")
+
+	var images []string
+	for range 2 {
+		products, err := api.NextOrder(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range products {
+			images = append(images, p.CustomerImgUrl)
+		}
+	}
+	if last := images[len(images)-1]; last != "https://cdn.example/customer-22x10.png" {
+		t.Errorf("customer image URL rewritten to %s", last)
+	}
+
+	for _, url := range images[:len(images)-1] {
+		if !strings.HasPrefix(url, api.BaseURL+SamplePath) {
+			t.Errorf("sample image URL %s is not served by the mock at %s", url, api.BaseURL)
+			continue
+		}
+		resp, err := api.HTTP.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := png.DecodeConfig(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("GET %s: %v", url, err)
+		} else if cfg.Width != 220 {
+			t.Errorf("GET %s: sample is %d px wide, want 220", url, cfg.Width)
+		}
+	}
+}
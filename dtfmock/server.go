@@ -0,0 +1,457 @@
+// Package dtfmock is an in-memory stand-in for the DTF API and its Shopify
+// webhook endpoint, so that send_webhook and process_image can be run end
+// to end without a deployment. Orders posted to the webhook are queued and
+// handed out by /orders/next; roles, token expiry and faults behave like
+// the real service closely enough to exercise the clients.
+package dtfmock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/samples"
+)
+
+// Roles known to the mock. Designers and admins work the order queue;
+// viewers can log in but are refused everywhere else.
+const (
+	RoleAdmin    = "admin"
+	RoleDesigner = "designer"
+	RoleViewer   = "viewer"
+)
+
+// User is an account the mock accepts.
+type User struct {
+	UserName string
+	Password string
+	Role     string
+}
+
+// DefaultUsers mirrors the accounts process_image uses by default, plus a
+// viewer to exercise role checks.
+var DefaultUsers = []User{
+	{UserName: "admin", Password: "admin", Role: RoleAdmin},
+	{UserName: "designer1", Password: "designer", Role: RoleDesigner},
+	{UserName: "designer2", Password: "designer", Role: RoleDesigner},
+	{UserName: "viewer", Password: "viewer", Role: RoleViewer},
+}
+
+// Faults makes the mock misbehave. Rates are fractions of requests between
+// 0 and 1 and apply to every endpoint except login.
+type Faults struct {
+	ErrorRate    float64       // answer 500
+	ThrottleRate float64       // answer 429 with RetryAfter
+	RetryAfter   time.Duration // Retry-After sent with 429s
+	Latency      time.Duration // added to every response
+	Jitter       time.Duration // random extra latency up to this
+}
+
+// Config configures a Server.
+type Config struct {
+	Users []User
+	// TokenTTL is the access token lifetime; 0 means tokens never expire.
+	TokenTTL time.Duration
+	// RefreshTTL is the refresh token lifetime; 0 uses DefaultRefreshTTL.
+	RefreshTTL time.Duration
+	// PresignTTL is how long upload URLs are valid; 0 uses 15 minutes.
+	PresignTTL time.Duration
+	// MaxUploadBytes caps the body of one upload or part; 0 uses
+	// DefaultMaxUploadBytes.
+	MaxUploadBytes int64
+	// StoreBytes caps the uploaded objects kept in memory; the oldest are
+	// evicted beyond it. 0 uses DefaultStoreBytes.
+	StoreBytes int64
+	// SampleDPI is the resolution of the sample gangsheets served under
+	// SamplePath; 0 uses DefaultSampleDPI.
+	SampleDPI int
+	// WebhookPath is where orders/create webhooks are accepted.
+	WebhookPath string
+	Faults      Faults
+	// Seed makes faults and jitter repeatable; 0 uses the time.
+	Seed int64
+}
+
+// DefaultRefreshTTL is how long refresh tokens are valid unless
+// Config.RefreshTTL says otherwise.
+const DefaultRefreshTTL = 24 * time.Hour
+
+// Server is the mock API. It is an http.Handler.
+type Server struct {
+	cfg   Config
+	mux   *http.ServeMux
+	queue *queue
+	store *store
+
+	mu       sync.Mutex
+	users    map[string]User
+	tokens   map[string]session
+	refresh  map[string]session
+	rng      *mrand.Rand
+	webhooks int64
+	requests int64
+	faults   int64
+}
+
+type session struct {
+	User    User
+	Expires time.Time
+}
+
+// New creates a mock with an empty queue.
+func New(cfg Config) *Server {
+	if len(cfg.Users) == 0 {
+		cfg.Users = DefaultUsers
+	}
+	if cfg.WebhookPath == "" {
+		cfg.WebhookPath = "/webhooks/test/orders/create"
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = DefaultRefreshTTL
+	}
+	if cfg.PresignTTL <= 0 {
+		cfg.PresignTTL = 15 * time.Minute
+	}
+	if cfg.SampleDPI <= 0 {
+		cfg.SampleDPI = DefaultSampleDPI
+	}
+	if cfg.MaxUploadBytes <= 0 {
+		cfg.MaxUploadBytes = DefaultMaxUploadBytes
+	}
+	if cfg.StoreBytes <= 0 {
+		cfg.StoreBytes = DefaultStoreBytes
+	}
+	if cfg.Faults.RetryAfter <= 0 {
+		cfg.Faults.RetryAfter = time.Second
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		mux:     http.NewServeMux(),
+		queue:   newQueue(),
+		users:   make(map[string]User),
+		tokens:  make(map[string]session),
+		refresh: make(map[string]session),
+		store:   newStore(cfg.StoreBytes),
+		rng:     mrand.New(mrand.NewSource(cfg.Seed)),
+	}
+	for _, u := range cfg.Users {
+		s.users[u.UserName] = u
+	}
+
+	s.mux.HandleFunc("POST /auth/login", s.login)
+	s.mux.HandleFunc("POST /auth/refresh", s.refreshToken)
+	s.mux.HandleFunc("GET /orders/next", s.authorized(s.nextOrder, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("POST /orders/{id}/products/{fulfillment_id}", s.authorized(s.processProduct, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("POST /orders/{id}/designer", s.authorized(s.approve, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("POST /image/presigned", s.authorized(s.presign, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("POST /image/presigned/multipart", s.authorized(s.presignMultipart, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("POST /image/presigned/multipart/complete", s.authorized(s.completeMultipart, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("POST /image/presigned/multipart/abort", s.authorized(s.abortMultipart, RoleAdmin, RoleDesigner))
+	s.mux.HandleFunc("PUT /uploads/{key...}", s.upload)
+	s.mux.HandleFunc("GET /uploads/{key...}", s.download)
+	s.mux.HandleFunc("GET "+SamplePath+"{name}", s.sample)
+	s.mux.HandleFunc("POST "+cfg.WebhookPath, s.webhook)
+	s.mux.HandleFunc("GET /mock/stats", s.stats)
+	return s
+}
+
+// AddOrder queues an order directly, with one product per image URL.
+// Products of sample images get the sample's variant title and are served
+// by the mock.
+func (s *Server) AddOrder(images ...string) int64 {
+	products := make([]product, len(images))
+	for i, img := range images {
+		products[i] = product{ImageURL: sampleURL(img), VariantTitle: sampleVariant(img), Quantity: 1}
+	}
+	id, _ := s.queue.Add(0, products)
+	return id
+}
+
+// Seed queues n orders of one to three sample gangsheets each.
+func (s *Server) Seed(n int) {
+	s.mu.Lock()
+	rng := mrand.New(mrand.NewSource(s.rng.Int63()))
+	s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		images := make([]string, 1+rng.Intn(3))
+		for j := range images {
+			images[j] = SamplePath + samples.Sheets[rng.Intn(len(samples.Sheets))].ImageName()
+		}
+		s.AddOrder(images...)
+	}
+}
+
+var sampleSize = regexp.MustCompile(`-(\d+x\d+)\.png$`)
+
+// sampleVariant returns the WxH variant of a sample image URL, or "".
+func sampleVariant(img string) string {
+	if m := sampleSize.FindStringSubmatch(img); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Stats is what GET /mock/stats returns.
+type Stats struct {
+	QueueStats
+	Webhooks int64 `json:"webhooks"`
+	Requests int64 `json:"requests"`
+	Faults   int64 `json:"faults"`
+	Uploads  int   `json:"uploads"`
+}
+
+// Stats returns the current counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		QueueStats: s.queue.Stats(),
+		Webhooks:   atomic.LoadInt64(&s.webhooks),
+		Requests:   atomic.LoadInt64(&s.requests),
+		Faults:     atomic.LoadInt64(&s.faults),
+		Uploads:    s.store.Len(),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&s.requests, 1)
+	if d := s.latency(); d > 0 {
+		time.Sleep(d)
+	}
+	if r.URL.Path != "/auth/login" && s.injectFault(w) {
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) latency() time.Duration {
+	f := s.cfg.Faults
+	if f.Jitter <= 0 {
+		return f.Latency
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f.Latency + time.Duration(s.rng.Int63n(int64(f.Jitter)))
+}
+
+// injectFault answers with a configured fault and reports whether it did.
+func (s *Server) injectFault(w http.ResponseWriter) bool {
+	f := s.cfg.Faults
+	if f.ErrorRate <= 0 && f.ThrottleRate <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	roll := s.rng.Float64()
+	s.mu.Unlock()
+
+	switch {
+	case roll < f.ThrottleRate:
+		atomic.AddInt64(&s.faults, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.RetryAfter.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "rate limited")
+		return true
+	case roll < f.ThrottleRate+f.ErrorRate:
+		atomic.AddInt64(&s.faults, 1)
+		writeError(w, http.StatusInternalServerError, "injected fault")
+		return true
+	}
+	return false
+}
+
+// authorized wraps h so it only runs for a valid token whose role is one
+// of roles.
+func (s *Server) authorized(h func(http.ResponseWriter, *http.Request, User), roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		s.mu.Lock()
+		sess, ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if !sess.Expires.IsZero() && time.Now().After(sess.Expires) {
+			writeError(w, http.StatusUnauthorized, "token expired")
+			return
+		}
+
+		for _, role := range roles {
+			if sess.User.Role == role {
+				h(w, r, sess.User)
+				return
+			}
+		}
+		writeError(w, http.StatusForbidden, fmt.Sprintf("role %s may not do this", sess.User.Role))
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req dtfapi.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid login request")
+		return
+	}
+	u, ok := s.users[req.UserName]
+	if !ok || u.Password != req.Password {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	writeData(w, s.issue(u))
+}
+
+func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req dtfapi.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid refresh request")
+		return
+	}
+	s.mu.Lock()
+	sess, ok := s.refresh[req.RefreshToken]
+	delete(s.refresh, req.RefreshToken)
+	s.mu.Unlock()
+	if !ok || time.Now().After(sess.Expires) {
+		writeError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+	writeData(w, s.issue(sess.User))
+}
+
+// issue creates a new access and refresh token pair for u, dropping the
+// tokens that have expired since.
+func (s *Server) issue(u User) dtfapi.AuthResponse {
+	access, refresh := randomToken(), randomToken()
+	now := time.Now()
+	sess := session{User: u}
+	if s.cfg.TokenTTL > 0 {
+		sess.Expires = now.Add(s.cfg.TokenTTL)
+	}
+
+	s.mu.Lock()
+	s.expireSessions(now)
+	s.tokens[access] = sess
+	s.refresh[refresh] = session{User: u, Expires: now.Add(s.cfg.RefreshTTL)}
+	s.mu.Unlock()
+
+	return dtfapi.AuthResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(s.cfg.TokenTTL.Seconds()),
+		UserName:     u.UserName,
+		Role:         u.Role,
+		Name:         u.UserName,
+	}
+}
+
+// expireSessions drops access and refresh tokens that expired before now.
+// The caller holds s.mu.
+func (s *Server) expireSessions(now time.Time) {
+	for token, sess := range s.tokens {
+		if !sess.Expires.IsZero() && now.After(sess.Expires) {
+			delete(s.tokens, token)
+		}
+	}
+	for token, sess := range s.refresh {
+		if now.After(sess.Expires) {
+			delete(s.refresh, token)
+		}
+	}
+}
+
+func (s *Server) nextOrder(w http.ResponseWriter, r *http.Request, u User) {
+	products := s.queue.Next(u.UserName)
+	for i := range products {
+		products[i].CustomerImgUrl = absoluteURL(r, products[i].CustomerImgUrl)
+	}
+	writeData(w, products)
+}
+
+func (s *Server) processProduct(w http.ResponseWriter, r *http.Request, u User) {
+	orderID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var req dtfapi.ProcessOrderProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FinalImgUrl == "" {
+		writeError(w, http.StatusBadRequest, "final_img_url is required")
+		return
+	}
+	if err := s.queue.Process(u.UserName, orderID, r.PathValue("fulfillment_id"), req.FinalImgUrl); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+	writeData(w, struct{}{})
+}
+
+func (s *Server) approve(w http.ResponseWriter, r *http.Request, u User) {
+	orderID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	if err := s.queue.Approve(u.UserName, orderID); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+	writeData(w, struct{}{})
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Stats())
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || h[:len(prefix)] != prefix {
+		return "", false
+	}
+	return h[len(prefix):], true
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeData[T any](w http.ResponseWriter, data T) {
+	writeJSON(w, http.StatusOK, dtfapi.Response[T]{Data: data, Timestamp: time.Now()})
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, dtfapi.Response[any]{Message: msg, Timestamp: time.Now()})
+}
+
+func writeQueueError(w http.ResponseWriter, err error) {
+	if qe, ok := err.(*queueError); ok {
+		writeError(w, qe.status, qe.msg)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,52 @@
+package dtfmock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"test_webhook_service/dtfapi"
+)
+
+func TestExpiredSessionsEvicted(t *testing.T) {
+	mock, api := newTestAPI(t, Config{TokenTTL: time.Millisecond, RefreshTTL: 2 * time.Millisecond})
+	ctx := context.Background()
+	auth, err := api.Login(ctx, "designer2", "designer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := api.Refresh(ctx, auth.RefreshToken); !dtfapi.IsUnauthorized(err) {
+		t.Errorf("refresh with an expired token = %v, want 401", err)
+	}
+	if _, err := api.Login(ctx, "designer1", "designer"); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.mu.Lock()
+	tokens, refresh := len(mock.tokens), len(mock.refresh)
+	mock.mu.Unlock()
+	if tokens != 1 || refresh != 1 {
+		t.Errorf("after expiry the mock holds %d access and %d refresh tokens, want only the newest pair", tokens, refresh)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	_, api := newTestAPI(t, Config{TokenTTL: time.Hour})
+	ctx := context.Background()
+	auth, err := api.Login(ctx, "designer2", "designer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := api.Refresh(ctx, auth.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if renewed.UserName != "designer2" || renewed.AccessToken == auth.AccessToken {
+		t.Errorf("refresh issued %+v, want a new token for designer2", renewed)
+	}
+	if _, err := api.Refresh(ctx, auth.RefreshToken); !dtfapi.IsUnauthorized(err) {
+		t.Errorf("second use of a refresh token = %v, want 401", err)
+	}
+}
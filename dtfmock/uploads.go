@@ -0,0 +1,300 @@
+package dtfmock
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"test_webhook_service/dtfapi"
+)
+
+const (
+	// DefaultMaxUploadBytes is the default cap on one upload or part.
+	DefaultMaxUploadBytes = 256 << 20
+	// DefaultStoreBytes is the default memory budget for stored objects.
+	DefaultStoreBytes = 1 << 30
+)
+
+// store is the mock object storage behind the presigned URLs. Like S3 it
+// checks Content-MD5 and x-amz-checksum-sha256 when sent, answers with the
+// MD5 as ETag and rejects URLs past their expiry. It keeps objects in
+// memory up to limit bytes, evicting the oldest first.
+type store struct {
+	mu      sync.Mutex
+	objects map[string]object
+	order   []object // keys and Seq by age, without the data
+	size    int64
+	limit   int64
+	seq     int64
+	uploads map[string]*multipartUpload
+	nextID  int
+}
+
+type object struct {
+	Key  string
+	Data []byte
+	Seq  int64 // tells a replaced object's entry in order from the current one
+}
+
+type multipartUpload struct {
+	Key     string
+	Parts   map[int][]byte
+	Expires time.Time
+}
+
+func newStore(limit int64) *store {
+	return &store{objects: make(map[string]object), uploads: make(map[string]*multipartUpload), limit: limit}
+}
+
+// put stores data under key and evicts the oldest objects while the store
+// is over its limit. Callers hold st.mu.
+func (st *store) put(key string, data []byte) {
+	if old, ok := st.objects[key]; ok {
+		st.size -= int64(len(old.Data))
+	}
+	st.seq++
+	st.objects[key] = object{Key: key, Data: data, Seq: st.seq}
+	st.order = append(st.order, object{Key: key, Seq: st.seq})
+	st.size += int64(len(data))
+
+	for st.size > st.limit && len(st.order) > 1 {
+		oldest := st.order[0]
+		st.order[0] = object{}
+		st.order = st.order[1:]
+		if cur, ok := st.objects[oldest.Key]; ok && cur.Seq == oldest.Seq {
+			delete(st.objects, oldest.Key)
+			st.size -= int64(len(cur.Data))
+		}
+	}
+
+	// Drop the entries of replaced objects once they dominate.
+	if len(st.order) > 2*len(st.objects)+64 {
+		live := st.order[:0]
+		for _, o := range st.order {
+			if cur, ok := st.objects[o.Key]; ok && cur.Seq == o.Seq {
+				live = append(live, o)
+			}
+		}
+		clear(st.order[len(live):])
+		st.order = live
+	}
+}
+
+// expireUploads drops multipart uploads whose URLs have expired, as S3
+// lifecycle rules would. Callers hold st.mu.
+func (st *store) expireUploads(now time.Time) {
+	for id, mp := range st.uploads {
+		if now.After(mp.Expires) {
+			delete(st.uploads, id)
+		}
+	}
+}
+
+// Len returns the number of stored objects.
+func (st *store) Len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.objects)
+}
+
+// downloadURL returns the URL of key on the mock as r reached it.
+func downloadURL(r *http.Request, key string) string {
+	return absoluteURL(r, "/uploads/"+key)
+}
+
+// uploadURL returns an upload URL for key that is valid until expires.
+func uploadURL(r *http.Request, key string, expires time.Time, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	return downloadURL(r, key) + "?" + query.Encode()
+}
+
+// presign hands out an upload URL on the mock itself.
+func (s *Server) presign(w http.ResponseWriter, r *http.Request, u User) {
+	var req dtfapi.PresignedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	expires := time.Now().Add(s.cfg.PresignTTL)
+	writeData(w, dtfapi.PresignedResponse{
+		URL:         uploadURL(r, req.Key, expires, nil),
+		DownloadURL: downloadURL(r, req.Key),
+		ExpiresAt:   expires.UTC().Format(time.RFC3339),
+		Key:         req.Key,
+	})
+}
+
+func (s *Server) presignMultipart(w http.ResponseWriter, r *http.Request, u User) {
+	var req dtfapi.MultipartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" || req.Parts < 1 || req.Parts > 10000 {
+		writeError(w, http.StatusBadRequest, "key and 1 to 10000 parts are required")
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(s.cfg.PresignTTL)
+
+	s.store.mu.Lock()
+	s.store.expireUploads(now)
+	s.store.nextID++
+	id := fmt.Sprintf("mp-%d", s.store.nextID)
+	s.store.uploads[id] = &multipartUpload{Key: req.Key, Parts: make(map[int][]byte), Expires: expires}
+	s.store.mu.Unlock()
+
+	resp := dtfapi.MultipartResponse{
+		UploadID:    id,
+		DownloadURL: downloadURL(r, req.Key),
+		ExpiresAt:   expires.UTC().Format(time.RFC3339),
+		Key:         req.Key,
+	}
+	for n := 1; n <= req.Parts; n++ {
+		q := url.Values{"uploadId": {id}, "partNumber": {strconv.Itoa(n)}}
+		resp.PartURLs = append(resp.PartURLs, uploadURL(r, req.Key, expires, q))
+	}
+	writeData(w, resp)
+}
+
+func (s *Server) completeMultipart(w http.ResponseWriter, r *http.Request, u User) {
+	var req dtfapi.CompleteMultipartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid complete request")
+		return
+	}
+
+	st := s.store
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	mp := st.uploads[req.UploadID]
+	if mp == nil || mp.Key != req.Key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var assembled bytes.Buffer
+	for i, p := range req.Parts {
+		data, ok := mp.Parts[p.PartNumber]
+		switch {
+		case p.PartNumber != i+1:
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder")
+			return
+		case !ok || p.ETag != md5Hex(data):
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("InvalidPart: part %d", p.PartNumber))
+			return
+		}
+		assembled.Write(data)
+	}
+	st.put(req.Key, assembled.Bytes())
+	delete(st.uploads, req.UploadID)
+	writeData(w, struct{}{})
+}
+
+func (s *Server) abortMultipart(w http.ResponseWriter, r *http.Request, u User) {
+	var req dtfapi.AbortMultipartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid abort request")
+		return
+	}
+	s.store.mu.Lock()
+	delete(s.store.uploads, req.UploadID)
+	s.store.mu.Unlock()
+	writeData(w, struct{}{})
+}
+
+// upload stores an object or a part of a multipart upload.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if exp, err := strconv.ParseInt(q.Get("expires"), 10, 64); err == nil && time.Now().Unix() > exp {
+		http.Error(w, "AccessDenied: Request has expired", http.StatusForbidden)
+		return
+	}
+
+	if r.ContentLength > s.cfg.MaxUploadBytes {
+		http.Error(w, "EntityTooLarge", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxUploadBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "EntityTooLarge", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "IncompleteBody", http.StatusBadRequest)
+		return
+	}
+	if msg := verifyChecksums(r.Header, data); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	key := r.PathValue("key")
+	st := s.store
+	st.mu.Lock()
+	if id := q.Get("uploadId"); id != "" {
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		mp := st.uploads[id]
+		if mp == nil || mp.Key != key || err != nil || n < 1 {
+			st.mu.Unlock()
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		mp.Parts[n] = data
+	} else {
+		st.put(key, data)
+	}
+	st.mu.Unlock()
+
+	w.Header().Set("ETag", `"`+md5Hex(data)+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyChecksums returns the S3 error code for data that does not match
+// the digests in h, or "".
+func verifyChecksums(h http.Header, data []byte) string {
+	if v := h.Get("Content-MD5"); v != "" {
+		sum := md5.Sum(data)
+		if v != base64.StdEncoding.EncodeToString(sum[:]) {
+			return "BadDigest: Content-MD5 does not match"
+		}
+	}
+	if v := h.Get("x-amz-checksum-sha256"); v != "" {
+		sum := sha256.Sum256(data)
+		if v != base64.StdEncoding.EncodeToString(sum[:]) {
+			return "BadDigest: x-amz-checksum-sha256 does not match"
+		}
+	}
+	return ""
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	s.store.mu.Lock()
+	obj, ok := s.store.objects[r.PathValue("key")]
+	s.store.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data := obj.Data
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("ETag", `"`+md5Hex(data)+`"`)
+	w.Write(data)
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
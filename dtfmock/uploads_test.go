@@ -0,0 +1,179 @@
+package dtfmock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"test_webhook_service/dtfapi"
+)
+
+// newTestAPI starts the mock with cfg and returns a client logged in as a
+// designer.
+func newTestAPI(t *testing.T, cfg Config) (*Server, *dtfapi.Client) {
+	t.Helper()
+	cfg.Seed = 1
+	mock := New(cfg)
+	srv := httptest.NewServer(mock)
+	t.Cleanup(srv.Close)
+
+	api := dtfapi.New(srv.URL, srv.Client())
+	auth, err := api.Login(context.Background(), "designer1", "designer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Token = auth.AccessToken
+	return mock, api
+}
+
+// put uploads body to url and returns the status and response body. A
+// negative length sends the body chunked.
+func put(t *testing.T, api *dtfapi.Client, url string, body []byte, length int64) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url, io.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = length
+	resp, err := api.HTTP.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(msg))
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	_, api := newTestAPI(t, Config{MaxUploadBytes: 1024})
+	tests := []struct {
+		name       string
+		size       int
+		chunked    bool
+		wantStatus int
+		wantBody   string
+	}{
+		{"at the limit", 1024, false, http.StatusOK, ""},
+		{"over the limit", 1025, false, http.StatusBadRequest, "EntityTooLarge"},
+		{"chunked at the limit", 1024, true, http.StatusOK, ""},
+		{"chunked over the limit", 4096, true, http.StatusBadRequest, "EntityTooLarge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := api.Presign(context.Background(), "limit.pdf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			length := int64(tt.size)
+			if tt.chunked {
+				length = -1
+			}
+			status, body := put(t, api, p.URL, make([]byte, tt.size), length)
+			if status != tt.wantStatus || body != tt.wantBody {
+				t.Errorf("PUT %d bytes = %d %q, want %d %q", tt.size, status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUploadExpired(t *testing.T) {
+	_, api := newTestAPI(t, Config{PresignTTL: time.Millisecond})
+	p, err := api.Presign(context.Background(), "late.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond) // expiry has second resolution
+	status, body := put(t, api, p.URL, []byte("late"), 4)
+	if status != http.StatusForbidden || !strings.Contains(body, "Request has expired") {
+		t.Errorf("PUT after expiry = %d %q, want 403 Request has expired", status, body)
+	}
+}
+
+func TestStoreEviction(t *testing.T) {
+	st := newStore(10)
+	st.put("a", []byte("1234"))
+	st.put("b", []byte("1234"))
+	st.put("a", []byte("12")) // replacing a makes it the newest
+	if st.size != 6 || st.Len() != 2 {
+		t.Fatalf("size = %d with %d objects, want 6 with 2", st.size, st.Len())
+	}
+
+	st.put("c", []byte("12345"))
+	if _, ok := st.objects["b"]; ok {
+		t.Error("b was kept, want the oldest object evicted")
+	}
+	if _, ok := st.objects["a"]; !ok {
+		t.Error("a was evicted, want it kept after it was replaced")
+	}
+	if st.size != 7 {
+		t.Errorf("size = %d, want 7", st.size)
+	}
+
+	// An object larger than the whole store is kept on its own.
+	st.put("huge", make([]byte, 20))
+	if st.Len() != 1 || st.size != 20 {
+		t.Errorf("store has %d objects, %d bytes, want only the new one", st.Len(), st.size)
+	}
+}
+
+func TestStoreOrderCompacted(t *testing.T) {
+	st := newStore(1 << 20)
+	for i := 0; i < 1000; i++ {
+		st.put("same", []byte(fmt.Sprint(i)))
+	}
+	if len(st.order) > 2*len(st.objects)+64 {
+		t.Errorf("order has %d entries for %d objects", len(st.order), len(st.objects))
+	}
+	if got := string(st.objects["same"].Data); got != "999" {
+		t.Errorf("same = %q, want the last upload", got)
+	}
+}
+
+func TestStoreEvictsThroughUploads(t *testing.T) {
+	mock, api := newTestAPI(t, Config{StoreBytes: 3000})
+	for i := 0; i < 5; i++ {
+		p, err := api.Presign(context.Background(), fmt.Sprintf("file-%d.pdf", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, body := put(t, api, p.URL, make([]byte, 1000), 1000); status != http.StatusOK {
+			t.Fatalf("PUT = %d %q", status, body)
+		}
+	}
+	if n := mock.Stats().Uploads; n != 3 {
+		t.Errorf("mock keeps %d uploads, want 3 within 3000 bytes", n)
+	}
+
+	resp, err := api.HTTP.Get(api.BaseURL + "/uploads/file-0.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET evicted object = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestMultipartUploadsExpire(t *testing.T) {
+	mock, api := newTestAPI(t, Config{PresignTTL: time.Millisecond})
+	if _, err := api.PresignMultipart(context.Background(), "a.pdf", 2); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	mp, err := api.PresignMultipart(context.Background(), "b.pdf", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.store.mu.Lock()
+	defer mock.store.mu.Unlock()
+	if len(mock.store.uploads) != 1 || mock.store.uploads[mp.UploadID] == nil {
+		t.Errorf("uploads = %v, want only %s after the first expired", mock.store.uploads, mp.UploadID)
+	}
+}
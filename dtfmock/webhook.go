@@ -0,0 +1,114 @@
+package dtfmock
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// webhookOrder is the part of a Shopify orders/create payload the mock
+// needs to queue an order.
+type webhookOrder struct {
+	ID        int64 `json:"id"`
+	LineItems []struct {
+		ProductID    *int64  `json:"product_id"`
+		SKU          *string `json:"sku"`
+		VariantTitle *string `json:"variant_title"`
+		Quantity     int32   `json:"quantity"`
+		Properties   []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"properties"`
+	} `json:"line_items"`
+}
+
+// imageProperties are the line item properties that carry the customer
+// image, in order of preference.
+var imageProperties = []string{"_Print Ready File", "File Upload"}
+
+// webhook accepts orders/create deliveries and queues one order per
+// payload. Redelivered orders are acknowledged without being queued again;
+// orders without line items are refused.
+func (s *Server) webhook(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&s.webhooks, 1)
+
+	body, err := decodeBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var payload webhookOrder
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid order payload: %v", err))
+		return
+	}
+
+	if len(payload.LineItems) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "order has no line items")
+		return
+	}
+
+	products := make([]product, 0, len(payload.LineItems))
+	for _, item := range payload.LineItems {
+		p := product{Quantity: item.Quantity}
+		if item.ProductID != nil {
+			p.ProductID = *item.ProductID
+		}
+		if item.SKU != nil {
+			p.Sku = *item.SKU
+		}
+		if item.VariantTitle != nil {
+			p.VariantTitle = *item.VariantTitle
+		}
+		p.ImageURL = sampleURL(lineItemImage(item.Properties))
+		products = append(products, p)
+	}
+
+	id, added := s.queue.Add(payload.ID, products)
+	writeData(w, struct {
+		OrderID int64 `json:"order_id"`
+		Queued  bool  `json:"queued"`
+	}{id, added})
+}
+
+func lineItemImage(properties []struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}) string {
+	for _, name := range imageProperties {
+		for _, p := range properties {
+			if p.Name == name && p.Value != "" {
+				return p.Value
+			}
+		}
+	}
+	return ""
+}
+
+// decodeBody undoes the Content-Encoding send_webhook may apply; deflate
+// is the zlib format, as HTTP defines it.
+func decodeBody(r *http.Request) (io.Reader, error) {
+	switch enc := strings.ToLower(r.Header.Get("Content-Encoding")); enc {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %v", err)
+		}
+		return zr, nil
+	case "deflate":
+		zr, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deflate body: %v", err)
+		}
+		return zr, nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", enc)
+	}
+}
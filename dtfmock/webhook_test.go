@@ -0,0 +1,58 @@
+package dtfmock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantQueued int
+	}{
+		{"order", `{"id":1,"line_items":[{"variant_title":"22x100","quantity":1,"properties":[{"name":"_Print Ready File","value":"https://cdn.example/a-22x100.png"}]}]}`, http.StatusOK, 1},
+		{"redelivery", `{"id":1,"line_items":[{"variant_title":"22x100","quantity":1}]}`, http.StatusOK, 1},
+		{"no line items", `{"id":2,"line_items":[]}`, http.StatusUnprocessableEntity, 1},
+		{"line items missing", `{"id":3}`, http.StatusUnprocessableEntity, 1},
+		{"invalid json", `{"id":`, http.StatusBadRequest, 1},
+	}
+
+	mock := New(Config{Seed: 1})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/test/orders/create", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			mock.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if queued := mock.Stats().Queued; queued != tt.wantQueued {
+				t.Errorf("queued = %d, want %d", queued, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestWebhookServesSamples(t *testing.T) {
+	mock, api := newTestAPI(t, Config{})
+	body := `{"id":7,"line_items":[{"variant_title":"22x5","quantity":1,"properties":[{"name":"_Print Ready File","value":"https://dtf.example/samples/tmp-img-ABC-1-22x5.png"}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/test/orders/create", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mock.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("webhook answered %d: %s", rec.Code, rec.Body)
+	}
+
+	products, err := api.NextOrder(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := api.BaseURL + SamplePath + "tmp-img-ABC-1-22x5.png"; len(products) != 1 || products[0].CustomerImgUrl != want {
+		t.Errorf("NextOrder() = %+v, want one product with image %s", products, want)
+	}
+}
@@ -2,4 +2,9 @@ module test_webhook_service
 
 go 1.24.5
 
-require github.com/google/uuid v1.6.0 // indirect
+require (
+	github.com/google/uuid v1.6.0
+	golang.org/x/term v0.34.0
+)
+
+require golang.org/x/sys v0.35.0 // indirect
@@ -0,0 +1,390 @@
+// Package har captures slow and failed HTTP exchanges and writes them to a
+// HAR 1.2 file for inspection in a browser or HAR viewer.
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBodyBytes caps how much of each body is kept in the file.
+const maxBodyBytes = 1 << 20
+
+// Sampler decides which exchanges to keep. Every failed exchange (transport
+// error or status >= 400) and every exchange slower than Threshold is kept
+// until Budget entries have been collected.
+type Sampler struct {
+	Threshold time.Duration
+	Budget    int
+	Creator   string
+
+	mu      sync.Mutex
+	entries []Entry
+	dropped int
+}
+
+// NewSampler creates a sampler for the named tool.
+func NewSampler(creator string, threshold time.Duration, budget int) *Sampler {
+	return &Sampler{Threshold: threshold, Budget: budget, Creator: creator}
+}
+
+// Transport wraps next so that every round trip is offered to the sampler.
+func (s *Sampler) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next, sampler: s}
+}
+
+// Len returns the number of captured entries and how many qualifying
+// exchanges were dropped because the budget was exhausted.
+func (s *Sampler) Len() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), s.dropped
+}
+
+// WriteFile writes the captured entries as a HAR document.
+func (s *Sampler) WriteFile(path string) error {
+	doc := document{Log: harLog{
+		Version: "1.2",
+		Creator: creator{Name: s.Creator, Version: "1"},
+		Entries: []Entry{},
+	}}
+	s.mu.Lock()
+	for _, e := range s.entries {
+		// Skip slots still held by in-flight captures.
+		if !e.StartedDateTime.IsZero() {
+			doc.Log.Entries = append(doc.Log.Entries, e)
+		}
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// reserve claims a budget slot.
+func (s *Sampler) reserve() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) >= s.Budget {
+		s.dropped++
+		return false
+	}
+	// Hold the slot with a placeholder so concurrent captures respect the
+	// budget; add replaces it.
+	s.entries = append(s.entries, Entry{})
+	return true
+}
+
+func (s *Sampler) add(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.entries {
+		if s.entries[i].StartedDateTime.IsZero() {
+			s.entries[i] = e
+			return
+		}
+	}
+}
+
+type transport struct {
+	next    http.RoundTripper
+	sampler *Sampler
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	failed := err != nil || resp.StatusCode >= 400
+	if !failed && elapsed < t.sampler.Threshold {
+		return resp, err
+	}
+	if !t.sampler.reserve() {
+		return resp, err
+	}
+
+	entry := Entry{
+		StartedDateTime: start,
+		Time:            ms(elapsed),
+		Request:         newRequest(req),
+		Cache:           struct{}{},
+		Timings:         Timings{Send: 0, Wait: ms(elapsed), Receive: 0},
+	}
+
+	if err != nil {
+		entry.Response = Response{HTTPVersion: "", Headers: []NameValue{}, Cookies: []NameValue{}, Content: Content{MimeType: "x-unknown"}, HeadersSize: -1, BodySize: -1}
+		entry.Comment = "error: " + err.Error()
+		t.sampler.add(entry)
+		return resp, err
+	}
+
+	if failed {
+		entry.Comment = fmt.Sprintf("status %d", resp.StatusCode)
+	} else {
+		entry.Comment = fmt.Sprintf("slow: %v", elapsed.Round(time.Millisecond))
+	}
+	// The body is captured while the caller reads it, so capturing does
+	// not add to the latency the caller measures; the entry is complete
+	// once the body is closed or read to the end.
+	returned := time.Now()
+	resp.Body = &captureBody{ReadCloser: resp.Body, done: func(body []byte) {
+		entry.Timings.Receive = ms(time.Since(returned))
+		entry.Time += entry.Timings.Receive
+		entry.Response = newResponse(resp, body)
+		t.sampler.add(entry)
+	}}
+	return resp, nil
+}
+
+// captureBody keeps up to maxBodyBytes of a response body as it is read
+// and hands them to done at EOF or Close.
+type captureBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func([]byte)
+	once sync.Once
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxBodyBytes - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *captureBody) finish() {
+	b.once.Do(func() { b.done(b.buf.Bytes()) })
+}
+
+func newRequest(req *http.Request) Request {
+	r := Request{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Headers:     headers(req.Header),
+		QueryString: []NameValue{},
+		Cookies:     []NameValue{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			r.QueryString = append(r.QueryString, NameValue{Name: name, Value: v})
+		}
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(io.LimitReader(body, maxBodyBytes))
+			body.Close()
+			text, encoding := bodyText(redactBody(data))
+			r.PostData = &PostData{MimeType: req.Header.Get("Content-Type"), Text: text, Encoding: encoding}
+		}
+	}
+	return r
+}
+
+func newResponse(resp *http.Response, body []byte) Response {
+	text, encoding := bodyText(redactBody(body))
+	return Response{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Headers:     headers(resp.Header),
+		Cookies:     []NameValue{},
+		Content: Content{
+			Size:     int64(len(body)),
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    resp.ContentLength,
+	}
+}
+
+func bodyText(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+// redactedHeaders carry credentials and are never written to the file.
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+func headers(h http.Header) []NameValue {
+	out := []NameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			if redactedHeaders[http.CanonicalHeaderKey(name)] {
+				v = "[redacted]"
+			}
+			out = append(out, NameValue{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+// redactedFields are JSON body fields holding credentials, such as the
+// login request and the tokens in its response.
+var redactedFields = map[string]bool{
+	"password":      true,
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// redactBody replaces the redactedFields of a JSON body. Other bodies are
+// returned unchanged.
+func redactBody(data []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || !redactValue(v) {
+		return data
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// redactValue redacts v in place and reports whether anything changed.
+func redactValue(v any) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if redactedFields[strings.ToLower(k)] {
+				v[k] = "[redacted]"
+				changed = true
+			} else if redactValue(field) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if redactValue(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// The types below follow the HAR 1.2 specification.
+
+type document struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string  `json:"version"`
+	Creator creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is one captured exchange.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	Comment         string    `json:"comment,omitempty"`
+}
+
+// NameValue is a header or query string pair.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Request is the request half of an entry.
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	Cookies     []NameValue `json:"cookies"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// PostData is a captured request body.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Response is the response half of an entry.
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []NameValue `json:"headers"`
+	Cookies     []NameValue `json:"cookies"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// Content is a captured response body.
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings splits the entry time into phases, in milliseconds.
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
@@ -0,0 +1,170 @@
+package har
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"login request", `{"username":"admin","password":"hunter2"}`, `{"password":"[redacted]","username":"admin"}`},
+		{"nested tokens", `{"data":{"access_token":"a","refresh_token":"r","expires_in":3600}}`, `{"data":{"access_token":"[redacted]","expires_in":3600,"refresh_token":"[redacted]"}}`},
+		{"array", `[{"Password":"x"}]`, `[{"Password":"[redacted]"}]`},
+		{"nothing to redact", `{"b":1,  "a":2}`, `{"b":1,  "a":2}`},
+		{"not json", `password=hunter2`, `password=hunter2`},
+		{"empty", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactBody([]byte(tt.body))); got != tt.want {
+				t.Errorf("redactBody(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSampler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/login":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session=secret")
+			io.WriteString(w, `{"data":{"access_token":"tok","refresh_token":"ref"}}`)
+		case "/fail":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	sampler := NewSampler("test", time.Hour, 10)
+	client := &http.Client{Transport: sampler.Transport(nil)}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantBody string
+		captured int
+	}{
+		{"fast success is skipped", "GET", "/", "", "ok", 0},
+		{"failure is captured", "GET", "/fail", "", "boom\n", 1},
+		{"login is captured redacted", "POST", "/auth/login?fail=1", `{"username":"u","password":"p"}`, `{"data":{"access_token":"tok","refresh_token":"ref"}}`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "login is captured redacted" {
+				sampler.Threshold = 0
+			}
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != tt.wantBody {
+				t.Errorf("caller read %q, want %q", body, tt.wantBody)
+			}
+			if n, _ := sampler.Len(); n != tt.captured {
+				t.Errorf("captured %d entries, want %d", n, tt.captured)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "out.har")
+	if err := sampler.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{`"p"`, `"tok"`, `"ref"`, "session=secret"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("HAR file contains %s", secret)
+		}
+	}
+
+	var doc struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					Cookies  []NameValue `json:"cookies"`
+					PostData *PostData   `json:"postData"`
+				} `json:"request"`
+				Response struct {
+					Cookies []NameValue `json:"cookies"`
+					Content Content     `json:"content"`
+				} `json:"response"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Log.Entries) != 2 {
+		t.Fatalf("HAR has %d entries, want 2", len(doc.Log.Entries))
+	}
+	for i, e := range doc.Log.Entries {
+		if e.Request.Cookies == nil || e.Response.Cookies == nil {
+			t.Errorf("entry %d: cookies must be arrays, not null", i)
+		}
+	}
+	login := doc.Log.Entries[1]
+	if got := login.Request.PostData.Text; got != `{"password":"[redacted]","username":"u"}` {
+		t.Errorf("login postData = %s", got)
+	}
+	if !strings.Contains(login.Response.Content.Text, `"access_token":"[redacted]"`) {
+		t.Errorf("login response = %s", login.Response.Content.Text)
+	}
+}
+
+func TestSamplerPendingBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "slow")
+	}))
+	defer srv.Close()
+
+	sampler := NewSampler("test", 0, 10)
+	client := &http.Client{Transport: sampler.Transport(nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Until the body is read the entry only holds its budget slot.
+	path := filepath.Join(t.TempDir(), "out.har")
+	if err := sampler.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Contains(data, []byte(`"entries": []`)) {
+		t.Errorf("in-flight entry written: %s", data)
+	}
+
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := sampler.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Contains(data, []byte(`"text": "slow"`)) {
+		t.Errorf("entry missing after the body was read: %s", data)
+	}
+}
@@ -0,0 +1,218 @@
+// Package httpclient builds the HTTP transports used by send_webhook and
+// process_image from a common set of command line options.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Options are the transport settings shared by both tools.
+type Options struct {
+	// Resolve pins host:port to an address, like curl --resolve
+	// host:port:addr, to hit one backend node behind a load balancer.
+	Resolve ResolveList
+
+	HTTP1Only bool // disable HTTP/2
+	H2C       bool // HTTP/2 over cleartext for http:// URLs
+
+	CAFile             string // extra PEM bundle of trusted roots
+	CertFile           string // client certificate (PEM)
+	KeyFile            string // client key (PEM)
+	InsecureSkipVerify bool
+
+	Proxy string // proxy URL, empty uses the environment (HTTP_PROXY, ...)
+
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+// RegisterFlags adds the options to fs. The current field values are used
+// as flag defaults so each tool can keep its own.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&o.Resolve, "resolve", "Pin host:port to an address, as host:port:addr (repeatable)")
+	fs.BoolVar(&o.HTTP1Only, "http1", o.HTTP1Only, "Use HTTP/1.1 only")
+	fs.BoolVar(&o.H2C, "h2c", o.H2C, "Use HTTP/2 without TLS (prior knowledge) for http:// URLs")
+	fs.StringVar(&o.CAFile, "ca-file", o.CAFile, "PEM bundle of additional trusted CA certificates")
+	fs.StringVar(&o.CertFile, "cert", o.CertFile, "Client certificate file (PEM)")
+	fs.StringVar(&o.KeyFile, "key", o.KeyFile, "Client private key file (PEM)")
+	fs.BoolVar(&o.InsecureSkipVerify, "insecure", o.InsecureSkipVerify, "Skip TLS certificate verification")
+	fs.StringVar(&o.Proxy, "proxy", o.Proxy, "Proxy URL (default from HTTP_PROXY/HTTPS_PROXY)")
+	fs.IntVar(&o.MaxConnsPerHost, "max-conns-per-host", o.MaxConnsPerHost, "Maximum connections per host (0 = unlimited)")
+	fs.IntVar(&o.MaxIdleConnsPerHost, "max-idle-per-host", o.MaxIdleConnsPerHost, "Maximum idle connections kept per host")
+}
+
+// Validate checks for contradictory options.
+func (o *Options) Validate() error {
+	if o.HTTP1Only && o.H2C {
+		return errors.New("-http1 and -h2c are mutually exclusive")
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("-cert and -key must be given together")
+	}
+	if o.Proxy != "" {
+		if _, err := url.Parse(o.Proxy); err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+	}
+	return nil
+}
+
+// NewTransport builds a transport from the options. base, usually the
+// selected environment's TLS settings, may be nil.
+func NewTransport(o Options, base *tls.Config) (*http.Transport, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := o.tlsConfig(base)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	idleTimeout := o.IdleConnTimeout
+	if idleTimeout == 0 {
+		idleTimeout = 90 * time.Second
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           o.Resolve.dialContext(dialer),
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       o.MaxConnsPerHost,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     !o.HTTP1Only,
+	}
+	if o.MaxIdleConnsPerHost > transport.MaxIdleConns {
+		transport.MaxIdleConns = o.MaxIdleConnsPerHost
+	}
+
+	if o.Proxy != "" {
+		proxyURL, _ := url.Parse(o.Proxy)
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	var protocols http.Protocols
+	switch {
+	case o.HTTP1Only:
+		protocols.SetHTTP1(true)
+	case o.H2C:
+		// Without HTTP/1 the transport speaks h2c with prior knowledge
+		// to http:// URLs instead of falling back to HTTP/1.1.
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	transport.Protocols = &protocols
+
+	return transport, nil
+}
+
+func (o Options) tlsConfig(base *tls.Config) (*tls.Config, error) {
+	if base == nil && o.CAFile == "" && o.CertFile == "" && !o.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if o.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := cfg.RootCAs
+		if pool == nil {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	return cfg, nil
+}
+
+// ResolveList is a repeatable host:port:addr flag.
+type ResolveList map[string]string
+
+func (r *ResolveList) String() string {
+	if r == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*r))
+	for hostPort, addr := range *r {
+		parts = append(parts, hostPort+"->"+addr)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses one host:port:addr entry. IPv6 addresses may be given in
+// brackets.
+func (r *ResolveList) Set(v string) error {
+	host, rest, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("invalid -resolve %q, want host:port:addr", v)
+	}
+	port, addr, ok := strings.Cut(rest, ":")
+	if !ok || host == "" || port == "" || addr == "" {
+		return fmt.Errorf("invalid -resolve %q, want host:port:addr", v)
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if net.ParseIP(addr) == nil {
+		return fmt.Errorf("invalid -resolve %q: %q is not an IP address", v, addr)
+	}
+
+	if *r == nil {
+		*r = make(ResolveList)
+	}
+	(*r)[net.JoinHostPort(host, port)] = net.JoinHostPort(addr, port)
+	return nil
+}
+
+func (r ResolveList) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if pinned, ok := r[addr]; ok {
+			addr = pinned
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,67 @@
+package httpclient
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryAfter is assumed when a 429 carries no usable Retry-After.
+const DefaultRetryAfter = time.Second
+
+// MaxRetryAfter caps the delay IsThrottled returns, so one bad header
+// cannot park a worker for hours. The tools set it from their backoff
+// limit.
+var MaxRetryAfter = 5 * time.Minute
+
+// RetryAfter parses the Retry-After header, given either as seconds or as
+// an HTTP date. It reports false when the header is missing or invalid.
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		if secs > math.MaxInt64/int64(time.Second) {
+			return time.Duration(math.MaxInt64), true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// IsThrottled reports whether resp asks the client to back off: a 429, or
+// a 503 carrying Retry-After. The returned delay falls back to
+// DefaultRetryAfter and is capped at MaxRetryAfter.
+func IsThrottled(resp *http.Response) (time.Duration, bool) {
+	d, ok := RetryAfter(resp.Header, time.Now())
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode == http.StatusServiceUnavailable && ok:
+	default:
+		return 0, false
+	}
+	if !ok {
+		d = DefaultRetryAfter
+	}
+	if MaxRetryAfter > 0 && d > MaxRetryAfter {
+		log.Printf("Retry-After %q exceeds %v, waiting %v instead", resp.Header.Get("Retry-After"), MaxRetryAfter, MaxRetryAfter)
+		d = MaxRetryAfter
+	}
+	return d, true
+}
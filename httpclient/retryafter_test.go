@@ -0,0 +1,74 @@
+package httpclient
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 11, 29, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"missing", "", 0, false},
+		{"seconds", "120", 2 * time.Minute, true},
+		{"zero", "0", 0, true},
+		{"padded", " 3 ", 3 * time.Second, true},
+		{"negative", "-5", 0, false},
+		{"fraction", "1.5", 0, false},
+		{"garbage", "soon", 0, false},
+		{"huge", "99999999999999999", time.Duration(math.MaxInt64), true},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{"date in the past", now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.header != "" {
+				h.Set("Retry-After", tt.header)
+			}
+			got, ok := RetryAfter(h, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("RetryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	defer func(max time.Duration) { MaxRetryAfter = max }(MaxRetryAfter)
+	MaxRetryAfter = time.Minute
+
+	tests := []struct {
+		name   string
+		status int
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"ok", http.StatusOK, "", 0, false},
+		{"429 without header", http.StatusTooManyRequests, "", DefaultRetryAfter, true},
+		{"429 with header", http.StatusTooManyRequests, "7", 7 * time.Second, true},
+		{"503 without header", http.StatusServiceUnavailable, "", 0, false},
+		{"503 with header", http.StatusServiceUnavailable, "2", 2 * time.Second, true},
+		{"capped", http.StatusTooManyRequests, "86400", time.Minute, true},
+		{"overflow capped", http.StatusTooManyRequests, "99999999999999999", time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := IsThrottled(resp)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("IsThrottled(%d, %q) = %v, %v, want %v, %v", tt.status, tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"test_webhook_service/config"
+	"test_webhook_service/dtfmock"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8000", "Address to listen on")
+	webhookPath := flag.String("webhook-path", "/webhooks/test/orders/create", "Path that accepts orders/create webhooks")
+	seedOrders := flag.Int("seed-orders", 0, "Orders of sample gangsheets to queue at startup")
+	tokenTTL := flag.Duration("token-ttl", 0, "Access token lifetime (0 = tokens never expire)")
+	refreshTTL := flag.Duration("refresh-ttl", dtfmock.DefaultRefreshTTL, "Refresh token lifetime")
+	presignTTL := flag.Duration("presign-ttl", 15*time.Minute, "How long presigned upload URLs stay valid")
+	maxUploadBytes := flag.Int64("max-upload-bytes", dtfmock.DefaultMaxUploadBytes, "Largest upload or upload part accepted")
+	sampleDPI := flag.Int("sample-dpi", dtfmock.DefaultSampleDPI, "Resolution of the sample gangsheets served under "+dtfmock.SamplePath)
+	storeBytes := flag.Int64("store-bytes", dtfmock.DefaultStoreBytes, "Memory kept for uploaded objects; the oldest are evicted beyond it")
+	accountsFile := flag.String("accounts", "", "Extra designer accounts: a JSON array or username:password lines (admin, designer1, designer2 and viewer always exist)")
+	errorRate := flag.Float64("error-rate", 0, "Fraction of requests answered with 500")
+	throttleRate := flag.Float64("throttle-rate", 0, "Fraction of requests answered with 429")
+	retryAfter := flag.Duration("retry-after", time.Second, "Retry-After sent with injected 429s")
+	latency := flag.Duration("latency", 0, "Latency added to every response")
+	jitter := flag.Duration("jitter", 0, "Random extra latency up to this")
+	seed := flag.Int64("seed", 0, "Seed for faults, jitter and seeded orders (default time based)")
+	flag.Parse()
+
+	users := append([]dtfmock.User(nil), dtfmock.DefaultUsers...)
+	if *accountsFile != "" {
+		accounts, err := config.LoadAccounts(*accountsFile)
+		if err != nil {
+			log.Fatalf("Failed to load accounts: %v", err)
+		}
+		for _, a := range accounts {
+			users = append(users, dtfmock.User{UserName: a.UserName, Password: a.Password, Role: dtfmock.RoleDesigner})
+		}
+	}
+
+	mock := dtfmock.New(dtfmock.Config{
+		Users:          users,
+		TokenTTL:       *tokenTTL,
+		RefreshTTL:     *refreshTTL,
+		SampleDPI:      *sampleDPI,
+		PresignTTL:     *presignTTL,
+		MaxUploadBytes: *maxUploadBytes,
+		StoreBytes:     *storeBytes,
+		WebhookPath:    *webhookPath,
+		Seed:           *seed,
+		Faults: dtfmock.Faults{
+			ErrorRate:    *errorRate,
+			ThrottleRate: *throttleRate,
+			RetryAfter:   *retryAfter,
+			Latency:      *latency,
+			Jitter:       *jitter,
+		},
+	})
+	mock.Seed(*seedOrders)
+
+	server := &http.Server{Addr: *addr, Handler: mock}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to listen: %v", err)
+		}
+	}()
+	log.Printf("Mock DTF API listening on http://%s (webhooks on %s, %d orders queued)", *addr, *webhookPath, *seedOrders)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+
+	stats := mock.Stats()
+	log.Printf("\n=== Mock Stats ===")
+	log.Printf("Webhooks received: %d", stats.Webhooks)
+	log.Printf("Requests: %d (%d injected faults)", stats.Requests, stats.Faults)
+	log.Printf("Orders: %d queued, %d claimed, %d approved", stats.Queued, stats.Claimed, stats.Approved)
+	log.Printf("Uploads: %d", stats.Uploads)
+}
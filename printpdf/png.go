@@ -0,0 +1,456 @@
+package printpdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image/color"
+	"io"
+	"os"
+)
+
+// maxRowBytes bounds the row buffers a PNG header can ask for.
+const maxRowBytes = 1 << 30
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// errNotPNG and errInterlaced send ConvertFile to the full decoder.
+	errNotPNG     = errors.New("not a PNG")
+	errInterlaced = errors.New("interlaced PNG")
+)
+
+// PNG colour types.
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPaletted  = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// pngSource streams a non-interlaced PNG file one row at a time, so a gang
+// sheet is never decoded into memory as a whole. Every pass reopens the
+// file and inflates the IDAT chunks again.
+type pngSource struct {
+	path          string
+	width, height int
+	depth         int
+	colorType     int
+	palette       []color.NRGBA
+	trns          []byte // tRNS chunk, the transparent key for gray and RGB
+}
+
+// openPNG reads the header chunks of the PNG at path.
+func openPNG(path string) (*pngSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src := &pngSource{path: path}
+	if _, err := src.readHeader(bufio.NewReader(f)); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// readHeader checks the signature and reads chunks up to the first IDAT,
+// whose length it returns. r is left at the start of the IDAT data.
+func (s *pngSource) readHeader(r *bufio.Reader) (uint32, error) {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || !bytes.Equal(sig, pngSignature) {
+		return 0, errNotPNG
+	}
+
+	crc := crc32.NewIEEE()
+	for first := true; ; first = false {
+		length, typ, err := readChunkHeader(r, crc)
+		if err != nil {
+			return 0, err
+		}
+		if first != (typ == "IHDR") {
+			return 0, fmt.Errorf("png: IHDR is not the first chunk")
+		}
+		if typ == "IDAT" {
+			return length, nil
+		}
+		if typ == "IEND" {
+			return 0, fmt.Errorf("png: no image data")
+		}
+
+		var data []byte
+		switch typ {
+		case "IHDR", "PLTE", "tRNS":
+			if length > 3*256 {
+				return 0, fmt.Errorf("png: %s chunk of %d bytes", typ, length)
+			}
+			data = make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, fmt.Errorf("png: %s: %w", typ, noEOF(err))
+			}
+			crc.Write(data)
+		default:
+			if _, err := io.CopyN(crc, r, int64(length)); err != nil {
+				return 0, fmt.Errorf("png: %s: %w", typ, noEOF(err))
+			}
+		}
+		if err := checkCRC(r, crc, typ); err != nil {
+			return 0, err
+		}
+
+		switch typ {
+		case "IHDR":
+			err = s.parseIHDR(data)
+		case "PLTE":
+			err = s.parsePLTE(data)
+		case "tRNS":
+			err = s.parseTRNS(data)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *pngSource) parseIHDR(data []byte) error {
+	if len(data) != 13 {
+		return fmt.Errorf("png: IHDR of %d bytes", len(data))
+	}
+	w, h := binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8])
+	s.depth, s.colorType = int(data[8]), int(data[9])
+	if w == 0 || h == 0 || w > 1<<31-1 || h > 1<<31-1 {
+		return fmt.Errorf("png: invalid size %dx%d", w, h)
+	}
+	s.width, s.height = int(w), int(h)
+	if data[10] != 0 || data[11] != 0 {
+		return fmt.Errorf("png: unknown compression or filter method")
+	}
+	if data[12] != 0 {
+		return errInterlaced
+	}
+
+	valid := map[int][]int{
+		pngGray:      {1, 2, 4, 8, 16},
+		pngRGB:       {8, 16},
+		pngPaletted:  {1, 2, 4, 8},
+		pngGrayAlpha: {8, 16},
+		pngRGBA:      {8, 16},
+	}[s.colorType]
+	for _, d := range valid {
+		if d == s.depth {
+			if s.rowBytes() > maxRowBytes {
+				return fmt.Errorf("png: rows of %d pixels are too wide", s.width)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("png: unsupported colour type %d at bit depth %d", s.colorType, s.depth)
+}
+
+func (s *pngSource) parsePLTE(data []byte) error {
+	if len(data)%3 != 0 || len(data) == 0 {
+		return fmt.Errorf("png: PLTE of %d bytes", len(data))
+	}
+	s.palette = make([]color.NRGBA, 256)
+	for i := range s.palette {
+		s.palette[i] = color.NRGBA{A: 0xff}
+	}
+	for i := 0; i < len(data)/3; i++ {
+		s.palette[i] = color.NRGBA{data[3*i], data[3*i+1], data[3*i+2], 0xff}
+	}
+	if s.trns != nil {
+		return s.parseTRNS(s.trns)
+	}
+	return nil
+}
+
+func (s *pngSource) parseTRNS(data []byte) error {
+	switch s.colorType {
+	case pngGray:
+		if len(data) != 2 {
+			return fmt.Errorf("png: tRNS of %d bytes for a gray image", len(data))
+		}
+	case pngRGB:
+		if len(data) != 6 {
+			return fmt.Errorf("png: tRNS of %d bytes for an RGB image", len(data))
+		}
+	case pngPaletted:
+		for i, a := range data {
+			if i < len(s.palette) {
+				s.palette[i].A = a
+			}
+		}
+	default:
+		return fmt.Errorf("png: tRNS in an image with an alpha channel")
+	}
+	s.trns = data
+	return nil
+}
+
+// channels returns the samples per pixel.
+func (s *pngSource) channels() int {
+	switch s.colorType {
+	case pngRGB:
+		return 3
+	case pngGrayAlpha:
+		return 2
+	case pngRGBA:
+		return 4
+	}
+	return 1
+}
+
+func (s *pngSource) rowBytes() int {
+	return (s.width*s.channels()*s.depth + 7) / 8
+}
+
+func (s *pngSource) size() (int, int) { return s.width, s.height }
+
+// hasAlpha reports whether the PNG can have transparent pixels: it has an
+// alpha channel or a tRNS chunk. Whether any pixel actually is transparent
+// is only known after a full pass, so opaque RGBA files still get a mask.
+func (s *pngSource) hasAlpha() bool {
+	return s.colorType == pngGrayAlpha || s.colorType == pngRGBA || s.trns != nil
+}
+
+func (s *pngSource) rows() (rowReader, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(f, 64<<10)
+	length, err := s.readHeader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if s.colorType == pngPaletted && s.palette == nil {
+		f.Close()
+		return nil, fmt.Errorf("png: paletted image without PLTE")
+	}
+
+	idat := &idatReader{r: br, left: length, crc: crc32.NewIEEE()}
+	idat.crc.Write([]byte("IDAT"))
+	zr, err := zlib.NewReader(idat)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("png: %w", noEOF(err))
+	}
+
+	bpp := max(1, s.channels()*s.depth/8)
+	n := s.rowBytes() + 1
+	return &pngRows{src: s, f: f, zr: zr, bpp: bpp, cur: make([]byte, n), prev: make([]byte, n)}, nil
+}
+
+// pngRows inflates and unfilters one row at a time.
+type pngRows struct {
+	src       *pngSource
+	f         *os.File
+	zr        io.ReadCloser
+	bpp       int
+	cur, prev []byte // filter type byte followed by the row
+}
+
+func (p *pngRows) next(row []color.NRGBA) error {
+	if _, err := io.ReadFull(p.zr, p.cur); err != nil {
+		return fmt.Errorf("png: %w", noEOF(err))
+	}
+	if err := unfilter(p.cur[0], p.cur[1:], p.prev[1:], p.bpp); err != nil {
+		return err
+	}
+	p.src.convert(row, p.cur[1:])
+	p.cur, p.prev = p.prev, p.cur
+	return nil
+}
+
+func (p *pngRows) Close() error {
+	p.zr.Close()
+	return p.f.Close()
+}
+
+// unfilter reverses the PNG filter ft on cur, given the previous row.
+func unfilter(ft byte, cur, prev []byte, bpp int) error {
+	switch ft {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i] / 2
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += uint8((int(cur[i-bpp]) + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i]
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += paeth(cur[i-bpp], prev[i], prev[i-bpp])
+		}
+	default:
+		return fmt.Errorf("png: unknown filter type %d", ft)
+	}
+	return nil
+}
+
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// convert unpacks an unfiltered row into non-premultiplied colour,
+// keeping the high byte of 16 bit samples.
+func (s *pngSource) convert(row []color.NRGBA, data []byte) {
+	if s.depth < 8 {
+		mask := 1<<s.depth - 1
+		perByte := 8 / s.depth
+		for x := range row {
+			shift := 8 - s.depth*(x%perByte+1)
+			v := int(data[x/perByte]>>shift) & mask
+			if s.colorType == pngPaletted {
+				row[x] = s.palette[v]
+				continue
+			}
+			g := uint8(v * 255 / mask)
+			row[x] = color.NRGBA{g, g, g, 0xff}
+			if s.trns != nil && v == int(binary.BigEndian.Uint16(s.trns)) {
+				row[x].A = 0
+			}
+		}
+		return
+	}
+
+	b := s.depth / 8 // bytes per sample
+	px := b * s.channels()
+	for x := range row {
+		p := data[x*px : (x+1)*px]
+		switch s.colorType {
+		case pngGray:
+			row[x] = color.NRGBA{p[0], p[0], p[0], 0xff}
+			if s.trns != nil && sample(p, b) == binary.BigEndian.Uint16(s.trns) {
+				row[x].A = 0
+			}
+		case pngRGB:
+			row[x] = color.NRGBA{p[0], p[b], p[2*b], 0xff}
+			if s.trns != nil &&
+				sample(p, b) == binary.BigEndian.Uint16(s.trns[0:]) &&
+				sample(p[b:], b) == binary.BigEndian.Uint16(s.trns[2:]) &&
+				sample(p[2*b:], b) == binary.BigEndian.Uint16(s.trns[4:]) {
+				row[x].A = 0
+			}
+		case pngPaletted:
+			row[x] = s.palette[p[0]]
+		case pngGrayAlpha:
+			row[x] = color.NRGBA{p[0], p[0], p[0], p[b]}
+		case pngRGBA:
+			row[x] = color.NRGBA{p[0], p[b], p[2*b], p[3*b]}
+		}
+	}
+}
+
+// sample returns the b byte sample at the start of p.
+func sample(p []byte, b int) uint16 {
+	if b == 2 {
+		return binary.BigEndian.Uint16(p)
+	}
+	return uint16(p[0])
+}
+
+// idatReader reads the data of consecutive IDAT chunks, checking each
+// chunk's CRC.
+type idatReader struct {
+	r    *bufio.Reader
+	left uint32 // bytes left in the current chunk
+	crc  hash.Hash32
+	done bool
+}
+
+func (d *idatReader) Read(p []byte) (int, error) {
+	for d.left == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := checkCRC(d.r, d.crc, "IDAT"); err != nil {
+			return 0, err
+		}
+		length, typ, err := readChunkHeader(d.r, d.crc)
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			d.done = true
+			return 0, io.EOF
+		}
+		d.left = length
+	}
+	n, err := d.r.Read(p[:min(uint32(len(p)), d.left)])
+	d.crc.Write(p[:n])
+	d.left -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// readChunkHeader reads a chunk's length and type and restarts crc with
+// the type.
+func readChunkHeader(r io.Reader, crc hash.Hash32) (uint32, string, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, "", fmt.Errorf("png: %w", noEOF(err))
+	}
+	length := binary.BigEndian.Uint32(hdr[:4])
+	if length > 1<<31-1 {
+		return 0, "", fmt.Errorf("png: chunk of %d bytes", length)
+	}
+	crc.Reset()
+	crc.Write(hdr[4:])
+	return length, string(hdr[4:]), nil
+}
+
+func checkCRC(r io.Reader, crc hash.Hash32, typ string) error {
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return fmt.Errorf("png: %s: %w", typ, noEOF(err))
+	}
+	if binary.BigEndian.Uint32(sum[:]) != crc.Sum32() {
+		return fmt.Errorf("png: %s: checksum mismatch", typ)
+	}
+	return nil
+}
+
+// noEOF reports a file that ends early as such.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,389 @@
+// Package printpdf turns customer PNGs into print-ready PDFs: a single page
+// the physical size of the gang sheet with the image filling it, alpha
+// kept as a soft mask so transparent areas stay unprinted.
+package printpdf
+
+import (
+	"bufio"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// pointsPerInch is the PDF user space unit.
+const pointsPerInch = 72
+
+// Options describe the page. Width and Height are in inches.
+type Options struct {
+	Width  float64
+	Height float64
+	// DPI is the resolution the image is embedded at; it is resampled to
+	// Width*DPI x Height*DPI pixels. 0 embeds it at its own resolution.
+	DPI int
+	// Level is the zlib compression level; 0 uses the default.
+	Level int
+}
+
+// Pixels returns the image size the options embed at, or 0, 0 when DPI
+// is 0.
+func (o Options) Pixels() (int, int) {
+	if o.DPI <= 0 {
+		return 0, 0
+	}
+	return int(math.Round(o.Width * float64(o.DPI))), int(math.Round(o.Height * float64(o.DPI)))
+}
+
+func (o Options) validate() error {
+	if o.Width <= 0 || o.Height <= 0 {
+		return fmt.Errorf("page size %gx%g inches is not positive", o.Width, o.Height)
+	}
+	if o.DPI < 0 {
+		return fmt.Errorf("negative DPI %d", o.DPI)
+	}
+	return nil
+}
+
+// ConvertFile writes the PDF of the image at src to dst. A PNG is read
+// row by row while it is written, in one pass for the colour and one for
+// the alpha, so it is never decoded in full. Interlaced PNGs and other
+// formats are decoded into memory first; they need their decoder
+// registered.
+func ConvertFile(src, dst string, o Options) error {
+	var in source
+	png, err := openPNG(src)
+	switch {
+	case err == nil:
+		in = png
+	case errors.Is(err, errNotPNG), errors.Is(err, errInterlaced):
+		img, err := decodeFile(src)
+		if err != nil {
+			return err
+		}
+		in = imageSource{img}
+	default:
+		return fmt.Errorf("%s: %w", src, err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := encode(out, in, o); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	return out.Close()
+}
+
+func decodeFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
+
+// Encode writes img as a single page PDF to w. Rows are resampled and
+// compressed as they are written, so the image is never held in memory at
+// the target resolution.
+func Encode(w io.Writer, img image.Image, o Options) error {
+	if img.Bounds().Empty() {
+		return fmt.Errorf("image has no pixels")
+	}
+	return encode(w, imageSource{img}, o)
+}
+
+func encode(w io.Writer, src source, o Options) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	pw, ph := o.Pixels()
+	if o.DPI == 0 {
+		pw, ph = src.size()
+	}
+	if pw < 1 || ph < 1 {
+		return fmt.Errorf("%d DPI gives an empty image", o.DPI)
+	}
+
+	pdf := &writer{w: bufio.NewWriterSize(w, 64<<10), level: o.Level}
+	if pdf.level == 0 {
+		pdf.level = zlib.DefaultCompression
+	}
+	rows := newSampler(src, pw, ph)
+	alpha := src.hasAlpha()
+
+	// UserUnit needs PDF 1.6.
+	version := "1.4"
+	if userUnit(o) != 1 {
+		version = "1.6"
+	}
+	pdf.printf("%%PDF-%s\n%%\xe2\xe3\xcf\xd3\n", version)
+
+	// Objects: 1 catalog, 2 pages, 3 page, 4 contents, 5 image, 6 its
+	// length, and 7 and 8 for the soft mask when there is one.
+	pdf.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	pdf.object(2, "<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	pdf.writePage(o)
+
+	smask := ""
+	if alpha {
+		smask = " /SMask 7 0 R"
+	}
+	pdf.stream(5, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8%s", pw, ph, smask),
+		func(zw io.Writer) error { return rows.write(zw, rgbRow) })
+	if alpha {
+		pdf.stream(7, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8", pw, ph),
+			func(zw io.Writer) error { return rows.write(zw, alphaRow) })
+	}
+
+	return pdf.finish()
+}
+
+// writer writes numbered objects and remembers their offsets for the
+// cross-reference table. The first error sticks.
+type writer struct {
+	w       *bufio.Writer
+	n       int64
+	offsets map[int]int64
+	level   int
+	err     error
+}
+
+func (p *writer) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	p.err = err
+	return n, err
+}
+
+func (p *writer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(p, format, args...)
+}
+
+func (p *writer) begin(num int) {
+	if p.offsets == nil {
+		p.offsets = make(map[int]int64)
+	}
+	p.offsets[num] = p.n
+	p.printf("%d 0 obj\n", num)
+}
+
+func (p *writer) object(num int, body string) {
+	p.begin(num)
+	p.printf("%s\nendobj\n", body)
+}
+
+// writePage writes the page and its content stream, which scales the unit
+// square image to the full page. Pages over the 200 inch limit most
+// viewers enforce are described with a UserUnit instead.
+func (p *writer) writePage(o Options) {
+	unit := userUnit(o)
+	w := num(o.Width * pointsPerInch / unit)
+	h := num(o.Height * pointsPerInch / unit)
+
+	userUnit := ""
+	if unit != 1 {
+		userUnit = " /UserUnit " + num(unit)
+	}
+	p.object(3, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s]%s /Resources << /XObject << /Im0 5 0 R >> >> /Contents 4 0 R >>", w, h, userUnit))
+
+	content := fmt.Sprintf("q %s 0 0 %s 0 0 cm /Im0 Do Q\n", w, h)
+	p.object(4, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+}
+
+// userUnit returns the user space unit, in points, that keeps the page
+// within 200 inches of user space.
+func userUnit(o Options) float64 {
+	if longest := math.Max(o.Width, o.Height); longest > 200 {
+		return math.Ceil(longest/200*100) / 100
+	}
+	return 1
+}
+
+// stream writes a Flate compressed stream object whose length follows as
+// object num+1, so the data can be produced while it is written.
+func (p *writer) stream(num int, dict string, data func(io.Writer) error) {
+	p.begin(num)
+	p.printf("<< %s /Filter /FlateDecode /Length %d 0 R >>\nstream\n", dict, num+1)
+	start := p.n
+	zw, err := zlib.NewWriterLevel(p, p.level)
+	if err != nil {
+		p.err = err
+		return
+	}
+	if err := data(zw); err != nil && p.err == nil {
+		p.err = err
+	}
+	if err := zw.Close(); err != nil && p.err == nil {
+		p.err = err
+	}
+	length := p.n - start
+	p.printf("\nendstream\nendobj\n")
+	p.object(num+1, strconv.FormatInt(length, 10))
+}
+
+// finish writes the cross-reference table and trailer.
+func (p *writer) finish() error {
+	size := len(p.offsets) + 1
+	xref := p.n
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", size)
+	for i := 1; i < size; i++ {
+		p.printf("%010d 00000 n \n", p.offsets[i])
+	}
+	p.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, xref)
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+// num formats a PDF real number.
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+// source hands out an image one row at a time, top to bottom. Every call
+// of rows starts a new pass at the first row.
+type source interface {
+	size() (int, int)
+	hasAlpha() bool
+	rows() (rowReader, error)
+}
+
+// rowReader is one pass over a source.
+type rowReader interface {
+	// next fills row, one pixel per column, with the next row.
+	next(row []color.NRGBA) error
+	Close() error
+}
+
+// imageSource is a source for an image already in memory.
+type imageSource struct {
+	img image.Image
+}
+
+func (s imageSource) size() (int, int) {
+	return s.img.Bounds().Dx(), s.img.Bounds().Dy()
+}
+
+func (s imageSource) hasAlpha() bool {
+	return !isOpaque(s.img)
+}
+
+func (s imageSource) rows() (rowReader, error) {
+	return &imageRows{img: s.img, y: s.img.Bounds().Min.Y}, nil
+}
+
+type imageRows struct {
+	img image.Image
+	y   int
+}
+
+// next converts the next row to non-premultiplied colour.
+func (r *imageRows) next(row []color.NRGBA) error {
+	b := r.img.Bounds()
+	if img, ok := r.img.(*image.NRGBA); ok {
+		pix := img.Pix[img.PixOffset(b.Min.X, r.y):]
+		for x := range row {
+			row[x] = color.NRGBA{pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3]}
+		}
+	} else {
+		for x := range row {
+			row[x] = color.NRGBAModel.Convert(r.img.At(b.Min.X+x, r.y)).(color.NRGBA)
+		}
+	}
+	r.y++
+	return nil
+}
+
+func (r *imageRows) Close() error { return nil }
+
+// sampler produces rows of a source resampled to w x h with nearest
+// neighbour sampling, which keeps hard edges of gang sheet artwork. Only
+// the current source row is held.
+type sampler struct {
+	src  source
+	w, h int
+	xs   []int
+	row  []color.NRGBA
+	out  []byte
+}
+
+func newSampler(src source, w, h int) *sampler {
+	sw, _ := src.size()
+	s := &sampler{src: src, w: w, h: h, xs: make([]int, w), row: make([]color.NRGBA, w)}
+	for x := range s.xs {
+		s.xs[x] = x * sw / w
+	}
+	return s
+}
+
+// rowFunc packs a row of pixels into dst and returns it.
+type rowFunc func(dst []byte, row []color.NRGBA) []byte
+
+func rgbRow(dst []byte, row []color.NRGBA) []byte {
+	for _, c := range row {
+		dst = append(dst, c.R, c.G, c.B)
+	}
+	return dst
+}
+
+func alphaRow(dst []byte, row []color.NRGBA) []byte {
+	for _, c := range row {
+		dst = append(dst, c.A)
+	}
+	return dst
+}
+
+// write makes one pass over the source, writing every output row packed
+// by pack.
+func (s *sampler) write(w io.Writer, pack rowFunc) error {
+	rows, err := s.src.rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sw, sh := s.src.size()
+	in := make([]color.NRGBA, sw)
+	cur := -1
+	for y := 0; y < s.h; y++ {
+		for sy := y * sh / s.h; cur < sy; cur++ {
+			if err := rows.next(in); err != nil {
+				return err
+			}
+		}
+		for x, sx := range s.xs {
+			s.row[x] = in[sx]
+		}
+		s.out = pack(s.out[:0], s.row)
+		if _, err := w.Write(s.out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isOpaque reports whether img has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
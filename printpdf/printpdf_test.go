@@ -0,0 +1,659 @@
+package printpdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsedPDF is what the tests read back from a generated PDF.
+type parsedPDF struct {
+	version string
+	objects map[int]string // dictionary part of each object
+	streams map[int][]byte // inflated stream data
+}
+
+// parsePDF checks the structure of a PDF written by Encode: every xref
+// offset must point at its object and startxref at the xref table.
+func parsePDF(t *testing.T, data []byte) parsedPDF {
+	t.Helper()
+	p := parsedPDF{objects: map[int]string{}, streams: map[int][]byte{}}
+
+	m := regexp.MustCompile(`^%PDF-(\d\.\d)\n`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("no PDF header in %q", data[:min(len(data), 20)])
+	}
+	p.version = string(m[1])
+
+	m = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("no startxref at the end")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(data[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscan(lines[1], &first, &count); err != nil || first != 0 {
+		t.Fatalf("xref subsection %q", lines[1])
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("xref entry 0 = %q", lines[2])
+	}
+	if !strings.Contains(string(data[xref:]), "/Size "+strconv.Itoa(count)+" ") {
+		t.Errorf("trailer /Size does not match the %d xref entries", count)
+	}
+
+	for num := 1; num < count; num++ {
+		entry := lines[2+num]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("xref entry %d = %q", num, entry)
+		}
+		off, _ := strconv.Atoi(entry[:10])
+		header := strconv.Itoa(num) + " 0 obj\n"
+		if !bytes.HasPrefix(data[off:], []byte(header)) {
+			t.Fatalf("xref offset %d of object %d points at %q", off, num, data[off:min(len(data), off+12)])
+		}
+		body := data[off+len(header):]
+		end := bytes.Index(body, []byte("\nendobj\n"))
+		if end < 0 {
+			t.Fatalf("object %d has no endobj", num)
+		}
+		body = body[:end]
+
+		dict, stream, isStream := bytes.Cut(body, []byte("\nstream\n"))
+		p.objects[num] = string(dict)
+		if !isStream {
+			continue
+		}
+		if !strings.Contains(string(dict), "/FlateDecode") {
+			n, _ := strconv.Atoi(field(string(dict), "/Length"))
+			if !bytes.HasPrefix(stream[n:], []byte("endstream")) {
+				t.Fatalf("object %d: /Length %d does not end at endstream", num, n)
+			}
+			p.streams[num] = stream[:n]
+			continue
+		}
+		stream = bytes.TrimSuffix(stream, []byte("\nendstream"))
+		m := regexp.MustCompile(`/Length (\d+) 0 R`).FindStringSubmatch(string(dict))
+		if m == nil {
+			t.Fatalf("object %d has no indirect /Length: %s", num, dict)
+		}
+		p.streams[num] = stream // checked against the length object below
+		lengthObj, _ := strconv.Atoi(m[1])
+		defer func(num, lengthObj int, raw []byte) {
+			if want := strconv.Itoa(len(raw)); p.objects[lengthObj] != want {
+				t.Errorf("object %d is %d bytes, its length object %d says %s", num, len(raw), lengthObj, p.objects[lengthObj])
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("object %d: %v", num, err)
+			}
+			inflated, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("object %d: %v", num, err)
+			}
+			p.streams[num] = inflated
+		}(num, lengthObj, stream)
+	}
+	return p
+}
+
+// field returns the value following key in dict.
+func field(dict, key string) string {
+	m := regexp.MustCompile(regexp.QuoteMeta(key) + `\s+(\[[^\]]*\]|[^\s/>]+)`).FindStringSubmatch(dict)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func encodePDF(t *testing.T, img image.Image, o Options) parsedPDF {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, o); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	var p parsedPDF
+	t.Run("structure", func(t *testing.T) { p = parsePDF(t, buf.Bytes()) })
+	return p
+}
+
+func TestEncodePage(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         Options
+		wantVersion  string
+		wantMediaBox string
+		wantUserUnit string
+		wantPixels   string // width x height of the embedded image
+	}{
+		{"22x10 at image size", Options{Width: 22, Height: 10}, "1.4", "[0 0 1584 720]", "", "8x4"},
+		{"22x10 at 2 DPI", Options{Width: 22, Height: 10, DPI: 2}, "1.4", "[0 0 1584 720]", "", "44x20"},
+		{"22x200 is the largest without UserUnit", Options{Width: 22, Height: 200}, "1.4", "[0 0 1584 14400]", "", "8x4"},
+		{"22x500", Options{Width: 22, Height: 500}, "1.6", "[0 0 633.6 14400]", "2.5", "8x4"},
+		{"22x1000", Options{Width: 22, Height: 1000}, "1.6", "[0 0 316.8 14400]", "5", "8x4"},
+		{"22x750 rounds the unit up", Options{Width: 22, Height: 750}, "1.6", "[0 0 422.4 14400]", "3.75", "8x4"},
+		{"wide 250x22", Options{Width: 250, Height: 22}, "1.6", "[0 0 14400 1267.2]", "1.25", "8x4"},
+		{"22x201 at 1 DPI", Options{Width: 22, Height: 201, DPI: 1}, "1.6", "[0 0 1568.317 14328.713]", "1.01", "22x201"},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := encodePDF(t, img, tt.opts)
+			if p.version != tt.wantVersion {
+				t.Errorf("version = %s, want %s", p.version, tt.wantVersion)
+			}
+			page := p.objects[3]
+			if got := field(page, "/MediaBox"); got != tt.wantMediaBox {
+				t.Errorf("MediaBox = %s, want %s", got, tt.wantMediaBox)
+			}
+			if got := field(page, "/UserUnit"); got != tt.wantUserUnit {
+				t.Errorf("UserUnit = %q, want %q", got, tt.wantUserUnit)
+			}
+
+			// The physical size is MediaBox * UserUnit / 72 inches.
+			box := strings.Fields(strings.Trim(field(page, "/MediaBox"), "[]"))
+			unit := 1.0
+			if tt.wantUserUnit != "" {
+				unit, _ = strconv.ParseFloat(tt.wantUserUnit, 64)
+			}
+			w, _ := strconv.ParseFloat(box[2], 64)
+			h, _ := strconv.ParseFloat(box[3], 64)
+			if dw, dh := w*unit/72-tt.opts.Width, h*unit/72-tt.opts.Height; abs64(dw) > 0.01 || abs64(dh) > 0.01 {
+				t.Errorf("page is %gx%g inches, want %gx%g", w*unit/72, h*unit/72, tt.opts.Width, tt.opts.Height)
+			}
+			if w > 14400 || h > 14400 {
+				t.Errorf("MediaBox %v exceeds 200 inches of user space", box)
+			}
+
+			// The content stream scales the image to the MediaBox.
+			content := string(p.streams[4])
+			if want := "q " + box[2] + " 0 0 " + box[3] + " 0 0 cm /Im0 Do Q\n"; content != want {
+				t.Errorf("content = %q, want %q", content, want)
+			}
+
+			im := p.objects[5]
+			if got := field(im, "/Width") + "x" + field(im, "/Height"); got != tt.wantPixels {
+				t.Errorf("image is %s px, want %s", got, tt.wantPixels)
+			}
+		})
+	}
+}
+
+func abs64(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestEncodeSMask(t *testing.T) {
+	opaque := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	transparent.SetNRGBA(0, 0, color.NRGBA{10, 20, 30, 255})
+	transparent.SetNRGBA(1, 0, color.NRGBA{40, 50, 60, 128})
+	transparent.SetNRGBA(0, 1, color.NRGBA{70, 80, 90, 0})
+	transparent.SetNRGBA(1, 1, color.NRGBA{100, 110, 120, 255})
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	gray.Pix = []byte{0, 85, 170, 255}
+
+	tests := []struct {
+		name      string
+		img       image.Image
+		wantRGB   []byte
+		wantAlpha []byte // nil when there must be no soft mask
+	}{
+		{"opaque RGBA", opaque, bytes.Repeat([]byte{0xff}, 12), nil},
+		{"gray", gray, []byte{0, 0, 0, 85, 85, 85, 170, 170, 170, 255, 255, 255}, nil},
+		{"transparent", transparent, []byte{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120}, []byte{255, 128, 0, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := encodePDF(t, tt.img, Options{Width: 1, Height: 1})
+			im := p.objects[5]
+			if !bytes.Equal(p.streams[5], tt.wantRGB) {
+				t.Errorf("image data = %v, want %v", p.streams[5], tt.wantRGB)
+			}
+			if field(im, "/ColorSpace") != "/DeviceRGB" && !strings.Contains(im, "/ColorSpace /DeviceRGB") {
+				t.Errorf("image is not DeviceRGB: %s", im)
+			}
+
+			smask := field(im, "/SMask")
+			if tt.wantAlpha == nil {
+				if smask != "" || len(p.objects) != 6 {
+					t.Errorf("opaque image has /SMask %q and %d objects, want none and 6", smask, len(p.objects))
+				}
+				return
+			}
+			if smask != "7" || !strings.Contains(im, "/SMask 7 0 R") {
+				t.Fatalf("image dictionary %s has no /SMask 7 0 R", im)
+			}
+			mask := p.objects[7]
+			if !strings.Contains(mask, "/ColorSpace /DeviceGray") || field(mask, "/Width") != "2" || field(mask, "/Height") != "2" {
+				t.Errorf("soft mask dictionary = %s", mask)
+			}
+			if !bytes.Equal(p.streams[7], tt.wantAlpha) {
+				t.Errorf("soft mask data = %v, want %v", p.streams[7], tt.wantAlpha)
+			}
+		})
+	}
+}
+
+func TestEncodeResample(t *testing.T) {
+	// A 2x2 checkerboard of red and blue blown up to 4x4 keeps hard edges.
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	src.SetNRGBA(0, 0, red)
+	src.SetNRGBA(1, 0, blue)
+	src.SetNRGBA(0, 1, blue)
+	src.SetNRGBA(1, 1, red)
+
+	p := encodePDF(t, src, Options{Width: 2, Height: 2, DPI: 2})
+	r, b := []byte{255, 0, 0}, []byte{0, 0, 255}
+	var want []byte
+	for _, row := range [][][]byte{{r, r, b, b}, {r, r, b, b}, {b, b, r, r}, {b, b, r, r}} {
+		want = append(want, bytes.Join(row, nil)...)
+	}
+	if !bytes.Equal(p.streams[5], want) {
+		t.Errorf("resampled data = %v, want %v", p.streams[5], want)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	tests := []struct {
+		name string
+		img  image.Image
+		opts Options
+		want string
+	}{
+		{"no width", img, Options{Height: 1}, "not positive"},
+		{"negative DPI", img, Options{Width: 1, Height: 1, DPI: -1}, "negative DPI"},
+		{"empty image", image.NewNRGBA(image.Rectangle{}), Options{Width: 1, Height: 1}, "no pixels"},
+		{"DPI too low", img, Options{Width: 0.1, Height: 0.1, DPI: 1}, "empty image"},
+	}
+	for _, tt := range tests {
+		if err := Encode(io.Discard, tt.img, tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Encode error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// testImages are the PNG flavours the streaming decoder must agree with
+// image/png on.
+func testImages() map[string]image.Image {
+	const w, h = 37, 11 // odd sizes exercise partial bytes and filters
+	images := map[string]image.Image{}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rgba64 := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	gray16 := image.NewGray16(image.Rect(0, 0, w, h))
+	opaque := image.NewRGBA(image.Rect(0, 0, w, h))
+	pal := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{
+		color.NRGBA{0, 0, 0, 0}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 128},
+	})
+	pal256 := image.NewPaletted(image.Rect(0, 0, w, h), nil)
+	for i := 0; i < 256; i++ {
+		pal256.Palette = append(pal256.Palette, color.NRGBA{uint8(i), uint8(255 - i), uint8(i * 7), 255})
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x*13 + y*29 + x*y)
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, v ^ 0x5a, uint8(x * 7), uint8(y * 23)})
+			rgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(v) << 8, uint16(x) * 1000, uint16(y) * 3000, uint16(x+y) * 2000})
+			gray.SetGray(x, y, color.Gray{v})
+			gray16.SetGray16(x, y, color.Gray16{uint16(v)<<8 | uint16(x)})
+			opaque.SetRGBA(x, y, color.RGBA{v, uint8(y * 20), uint8(x * 5), 255})
+			pal.SetColorIndex(x, y, uint8((x+y)%3))
+			pal256.SetColorIndex(x, y, v)
+		}
+	}
+	images["rgba"] = nrgba
+	images["rgba16"] = rgba64
+	images["gray"] = gray
+	images["gray16"] = gray16
+	images["rgb"] = opaque
+	images["paletted 2 bit with tRNS"] = pal
+	images["paletted 8 bit"] = pal256
+	return images
+}
+
+func writePNG(t *testing.T, dir, name string, img image.Image) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, buf.Bytes())
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readAllRows makes one pass over src.
+func readAllRows(t *testing.T, src source) [][]color.NRGBA {
+	t.Helper()
+	rows, err := src.rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	w, h := src.size()
+	out := make([][]color.NRGBA, h)
+	for y := range out {
+		out[y] = make([]color.NRGBA, w)
+		if err := rows.next(out[y]); err != nil {
+			t.Fatalf("row %d: %v", y, err)
+		}
+	}
+	return out
+}
+
+func TestPNGSourceMatchesImagePNG(t *testing.T) {
+	dir := t.TempDir()
+	for name, img := range testImages() {
+		t.Run(name, func(t *testing.T) {
+			path := writePNG(t, dir, name, img)
+			src, err := openPNG(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := decodeFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h := src.size(); w != want.Bounds().Dx() || h != want.Bounds().Dy() {
+				t.Fatalf("size = %dx%d, want %v", w, h, want.Bounds().Size())
+			}
+			if got, wantAlpha := src.hasAlpha(), !isOpaque(img); wantAlpha && !got {
+				t.Errorf("hasAlpha = false for an image with transparency")
+			}
+			// Two passes read the same rows.
+			for pass := 0; pass < 2; pass++ {
+				for y, row := range readAllRows(t, src) {
+					for x, got := range row {
+						w := toNRGBA(want.At(x, y))
+						if got != w {
+							t.Fatalf("pass %d pixel (%d,%d) = %v, want %v", pass, x, y, got, w)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// toNRGBA converts c to 8 bit non-premultiplied colour by keeping the
+// high byte, which is what printpdf embeds, rather than going through
+// premultiplied colour like color.NRGBAModel.
+func toNRGBA(c color.Color) color.NRGBA {
+	if c, ok := c.(color.NRGBA64); ok {
+		return color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	}
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
+// setIHDR changes byte i of the IHDR data of a PNG and fixes its CRC.
+func setIHDR(data []byte, i int, v byte) []byte {
+	b := bytes.Clone(data)
+	start := len(pngSignature) + 8
+	b[start+i] = v
+	binary.BigEndian.PutUint32(b[start+13:], crc32.ChecksumIEEE(b[start-4:start+13]))
+	return b
+}
+
+// rawPNG builds a PNG with unfiltered rows and extra chunks before IDAT,
+// for the formats image/png does not write.
+func rawPNG(w, h, depth, colorType int, rows [][]byte, chunks ...[2]string) []byte {
+	var b bytes.Buffer
+	b.Write(pngSignature)
+	chunk := func(typ string, data []byte) {
+		binary.Write(&b, binary.BigEndian, uint32(len(data)))
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(typ))
+		crc.Write(data)
+		b.WriteString(typ)
+		b.Write(data)
+		binary.Write(&b, binary.BigEndian, crc.Sum32())
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = byte(depth), byte(colorType)
+	chunk("IHDR", ihdr)
+	for _, c := range chunks {
+		chunk(c[0], []byte(c[1]))
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	for _, row := range rows {
+		zw.Write(append([]byte{0}, row...))
+	}
+	zw.Close()
+	// Split the data over two IDAT chunks.
+	data := z.Bytes()
+	chunk("IDAT", data[:len(data)/2])
+	chunk("IDAT", data[len(data)/2:])
+	chunk("IEND", nil)
+	return b.Bytes()
+}
+
+func TestPNGSourceRaw(t *testing.T) {
+	opaque := func(v uint8) color.NRGBA { return color.NRGBA{v, v, v, 255} }
+	tests := []struct {
+		name      string
+		png       []byte
+		want      [][]color.NRGBA
+		wantAlpha bool
+	}{
+		{
+			name: "gray 1 bit",
+			png:  rawPNG(10, 1, 1, pngGray, [][]byte{{0b10110000, 0b01000000}}),
+			want: [][]color.NRGBA{{opaque(255), opaque(0), opaque(255), opaque(255), opaque(0), opaque(0), opaque(0), opaque(0), opaque(0), opaque(255)}},
+		},
+		{
+			name: "gray 2 bit",
+			png:  rawPNG(3, 1, 2, pngGray, [][]byte{{0b00011011}}),
+			want: [][]color.NRGBA{{opaque(0), opaque(85), opaque(170)}},
+		},
+		{
+			name:      "gray 4 bit with tRNS",
+			png:       rawPNG(2, 1, 4, pngGray, [][]byte{{0x0f}}, [2]string{"tRNS", "\x00\x0f"}),
+			want:      [][]color.NRGBA{{opaque(0), {255, 255, 255, 0}}},
+			wantAlpha: true,
+		},
+		{
+			name:      "gray 8 bit with tRNS",
+			png:       rawPNG(2, 1, 8, pngGray, [][]byte{{7, 8}}, [2]string{"tRNS", "\x00\x07"}),
+			want:      [][]color.NRGBA{{{7, 7, 7, 0}, opaque(8)}},
+			wantAlpha: true,
+		},
+		{
+			name:      "RGB with tRNS",
+			png:       rawPNG(2, 1, 8, pngRGB, [][]byte{{1, 2, 3, 1, 2, 4}}, [2]string{"tRNS", "\x00\x01\x00\x02\x00\x03"}),
+			want:      [][]color.NRGBA{{{1, 2, 3, 0}, {1, 2, 4, 255}}},
+			wantAlpha: true,
+		},
+		{
+			name:      "gray alpha 8 bit",
+			png:       rawPNG(2, 2, 8, pngGrayAlpha, [][]byte{{10, 255, 20, 0}, {30, 128, 40, 64}}),
+			want:      [][]color.NRGBA{{{10, 10, 10, 255}, {20, 20, 20, 0}}, {{30, 30, 30, 128}, {40, 40, 40, 64}}},
+			wantAlpha: true,
+		},
+		{
+			name:      "gray alpha 16 bit",
+			png:       rawPNG(1, 1, 16, pngGrayAlpha, [][]byte{{0x12, 0x34, 0x80, 0xff}}),
+			want:      [][]color.NRGBA{{{0x12, 0x12, 0x12, 0x80}}},
+			wantAlpha: true,
+		},
+		{
+			name: "paletted index past PLTE",
+			png:  rawPNG(2, 1, 8, pngPaletted, [][]byte{{0, 5}}, [2]string{"PLTE", "\x01\x02\x03"}),
+			want: [][]color.NRGBA{{{1, 2, 3, 255}, {0, 0, 0, 255}}},
+		},
+		{
+			name: "ancillary chunks are skipped",
+			png:  rawPNG(1, 1, 8, pngGray, [][]byte{{9}}, [2]string{"pHYs", "\x00\x00\x0b\x13\x00\x00\x0b\x13\x01"}, [2]string{"tEXt", "Comment\x00hello"}),
+			want: [][]color.NRGBA{{opaque(9)}},
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := openPNG(writeFile(t, dir, tt.name, tt.png))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.hasAlpha() != tt.wantAlpha {
+				t.Errorf("hasAlpha = %v, want %v", src.hasAlpha(), tt.wantAlpha)
+			}
+			got := readAllRows(t, src)
+			for y := range tt.want {
+				for x := range tt.want[y] {
+					if got[y][x] != tt.want[y][x] {
+						t.Errorf("pixel (%d,%d) = %v, want %v", x, y, got[y][x], tt.want[y][x])
+					}
+				}
+			}
+		})
+	}
+}
+
+// spliceIDAT replaces the image data of a PNG written by rawPNG.
+func spliceIDAT(png, data []byte) []byte {
+	i := bytes.Index(png, []byte("IDAT")) - 4
+	var b bytes.Buffer
+	b.Write(png[:i])
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.WriteString("IDAT")
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("IDAT"), data...)))
+	b.Write(png[bytes.LastIndex(png, []byte("IEND"))-4:])
+	return b.Bytes()
+}
+
+func TestPNGSourceErrors(t *testing.T) {
+	good := rawPNG(2, 2, 8, pngGray, [][]byte{{1, 2}, {3, 4}})
+	corrupt := bytes.Clone(good)
+	corrupt[len(pngSignature)+8+3] ^= 0xff // inside the IHDR data
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string // on open, or on reading the rows when wantRows
+		onRows  bool
+	}{
+		{"not a PNG", []byte("GIF89a"), "not a PNG", false},
+		{"bad IHDR checksum", corrupt, "IHDR: checksum mismatch", false},
+		{"interlaced", setIHDR(good, 12, 1), "interlaced", false},
+		{"filter method", setIHDR(good, 11, 1), "unknown compression or filter method", false},
+		{"bad filter type", func() []byte {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write([]byte{7, 1, 2, 0, 3, 4})
+			zw.Close()
+			b := rawPNG(2, 2, 8, pngGray, nil)
+			return spliceIDAT(b, z.Bytes())
+		}(), "unknown filter type 7", true},
+		{"bad depth", rawPNG(1, 1, 4, pngRGB, [][]byte{{0}}), "unsupported colour type 2 at bit depth 4", false},
+		{"truncated", good[:len(good)-30], "unexpected EOF", true},
+		{"too few rows", rawPNG(2, 3, 8, pngGray, [][]byte{{1, 2}}), "unexpected EOF", true},
+		{"paletted without PLTE", rawPNG(1, 1, 8, pngPaletted, [][]byte{{0}}), "without PLTE", true},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := openPNG(writeFile(t, dir, tt.name, tt.data))
+			if !tt.onRows {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("openPNG error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openPNG: %v", err)
+			}
+			err = encode(io.Discard, src, Options{Width: 1, Height: 1})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("reading rows: error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConvertFile(t *testing.T) {
+	dir := t.TempDir()
+	img := testImages()["rgba"]
+	path := writePNG(t, dir, "sheet", img)
+
+	dst := filepath.Join(dir, "sheet.pdf")
+	if err := ConvertFile(path, dst, Options{Width: 3.7, Height: 1.1}); err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded bytes.Buffer
+	if err := Encode(&decoded, img, Options{Width: 3.7, Height: 1.1}); err != nil {
+		t.Fatal(err)
+	}
+	ps, pd := parsePDF(t, streamed), parsePDF(t, decoded.Bytes())
+	if !bytes.Equal(ps.streams[5], pd.streams[5]) || !bytes.Equal(ps.streams[7], pd.streams[7]) {
+		t.Error("streamed PNG and decoded image give different image data")
+	}
+
+	// Other formats are decoded in full.
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	jpgPath := filepath.Join(dir, "sheet.jpg")
+	if err := os.WriteFile(jpgPath, jpg.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConvertFile(jpgPath, filepath.Join(dir, "jpeg.pdf"), Options{Width: 3.7, Height: 1.1}); err != nil {
+		t.Errorf("ConvertFile of a JPEG: %v", err)
+	}
+
+	// A broken file leaves no partial PDF behind.
+	bad := writeFile(t, dir, "bad", rawPNG(2, 3, 8, pngGray, [][]byte{{1, 2}}))
+	badDst := filepath.Join(dir, "bad.pdf")
+	if err := ConvertFile(bad, badDst, Options{Width: 1, Height: 1}); err == nil {
+		t.Error("ConvertFile of a truncated PNG succeeded")
+	}
+	if _, err := os.Stat(badDst); !os.IsNotExist(err) {
+		t.Errorf("partial PDF left behind: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"test_webhook_service/config"
+)
+
+// accountAssignments are the ways workers can be given accounts.
+var accountAssignments = []string{"round-robin", "block", "random"}
+
+// assignAccounts picks the account each worker logs in with.
+//
+//   - round-robin: worker i uses account i mod n, spreading workers evenly.
+//   - block: workers are split into n contiguous groups, one per account,
+//     like designers who each run several sessions.
+//   - random: every worker picks at random; seed makes it repeatable.
+func assignAccounts(accounts []config.Account, workers int, mode string, seed int64) ([]config.Account, error) {
+	assigned := make([]config.Account, workers)
+	n := len(accounts)
+	switch mode {
+	case "round-robin":
+		for i := range assigned {
+			assigned[i] = accounts[i%n]
+		}
+	case "block":
+		for i := range assigned {
+			assigned[i] = accounts[i*n/workers]
+		}
+	case "random":
+		rng := rand.New(rand.NewSource(seed))
+		for i := range assigned {
+			assigned[i] = accounts[rng.Intn(n)]
+		}
+	default:
+		return nil, fmt.Errorf("unknown account assignment %q (available: %s)", mode, strings.Join(accountAssignments, ", "))
+	}
+	return assigned, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"test_webhook_service/config"
+)
+
+func TestAssignAccounts(t *testing.T) {
+	accounts := []config.Account{{UserName: "a"}, {UserName: "b"}, {UserName: "c"}}
+	tests := []struct {
+		mode    string
+		workers int
+		want    string
+	}{
+		{"round-robin", 7, "abcabca"},
+		{"round-robin", 2, "ab"},
+		{"block", 6, "aabbcc"},
+		{"block", 7, "aaabbcc"},
+		{"block", 2, "ab"},
+		{"block", 1, "a"},
+	}
+	for _, tt := range tests {
+		got, err := assignAccounts(accounts, tt.workers, tt.mode, 1)
+		if err != nil {
+			t.Fatalf("assignAccounts(%s, %d): %v", tt.mode, tt.workers, err)
+		}
+		if names := userNames(got); names != tt.want {
+			t.Errorf("assignAccounts(%s, %d) = %s, want %s", tt.mode, tt.workers, names, tt.want)
+		}
+	}
+}
+
+func TestAssignAccountsRandom(t *testing.T) {
+	accounts := []config.Account{{UserName: "a"}, {UserName: "b"}, {UserName: "c"}}
+
+	first, err := assignAccounts(accounts, 30, "random", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := assignAccounts(accounts, 30, "random", 42)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("same seed gave %s and %s", userNames(first), userNames(again))
+	}
+	other, _ := assignAccounts(accounts, 30, "random", 43)
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("seeds 42 and 43 both gave %s", userNames(first))
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if !strings.Contains(userNames(first), name) {
+			t.Errorf("account %s never assigned to any of 30 workers: %s", name, userNames(first))
+		}
+	}
+}
+
+func TestAssignAccountsUnknownMode(t *testing.T) {
+	_, err := assignAccounts([]config.Account{{UserName: "a"}}, 1, "sticky", 1)
+	if err == nil || !strings.Contains(err.Error(), `unknown account assignment "sticky"`) {
+		t.Errorf("error = %v, want unknown account assignment", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "round-robin, block, random") {
+		t.Errorf("error = %v, want the available assignments listed", err)
+	}
+
+	// Every advertised assignment is accepted.
+	for _, mode := range accountAssignments {
+		if _, err := assignAccounts([]config.Account{{UserName: "a"}}, 2, mode, 1); err != nil {
+			t.Errorf("assignAccounts(%q) error = %v", mode, err)
+		}
+	}
+}
+
+func userNames(accounts []config.Account) string {
+	var b strings.Builder
+	for _, a := range accounts {
+		b.WriteString(a.UserName)
+	}
+	return b.String()
+}
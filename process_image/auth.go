@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"test_webhook_service/config"
+	"test_webhook_service/dtfapi"
+)
+
+const (
+	// maxLoginAttempts bounds how often a worker retries a login that
+	// fails for reasons other than rejected credentials before it stops.
+	maxLoginAttempts = 8
+	// tokenExpiryMargin renews tokens this long before they expire.
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// errDrained stops a login retry loop once the queue is drained.
+var errDrained = errors.New("queue drained")
+
+// session is one worker's login. It keeps the tokens from /auth/login and
+// renews them when they expire or the API stops accepting them.
+type session struct {
+	idx     int
+	account config.Account
+	api     *dtfapi.Client
+
+	refreshToken string
+	expiresAt    time.Time // zero when the API does not say
+	// fresh is set by a new token until an order has gone through with
+	// it. A 403 on a fresh token is the account's role, not expiry.
+	fresh bool
+}
+
+// expiring reports whether the token should be renewed before it is used.
+func (s *session) expiring() bool {
+	return !s.expiresAt.IsZero() && time.Now().After(s.expiresAt.Add(-tokenExpiryMargin))
+}
+
+// authenticate obtains a fresh access token, trying the refresh token first
+// when there is one. Failures are retried with backoff, except rejected
+// credentials, which end the worker, and cancellation of ctx.
+func (s *session) authenticate(ctx context.Context) error {
+	backoff := opts.PollInterval
+	for attempt := 1; ; attempt++ {
+		if s.refreshToken != "" {
+			auth, err := s.api.Refresh(ctx, s.refreshToken)
+			if err == nil {
+				s.use(auth)
+				return nil
+			}
+			log.Printf("worker-%d: token refresh failed, logging in again: %v", s.idx, err)
+			s.refreshToken = ""
+		}
+
+		auth, err := s.api.Login(ctx, s.account.UserName, s.account.Password)
+		if err == nil {
+			s.use(auth)
+			return nil
+		}
+		if dtfapi.IsUnauthorized(err) || dtfapi.IsForbidden(err) {
+			return err
+		}
+
+		wait := backoff
+		if d, ok := dtfapi.IsThrottled(err); ok {
+			wait = d
+		}
+		if attempt == maxLoginAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("worker-%d: login failed, retrying in %v: %v", s.idx, wait, err)
+		if !drain.sleep(ctx, wait) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errDrained
+		}
+		backoff = min(backoff*2, opts.MaxBackoff)
+	}
+}
+
+// use switches the session to the tokens in auth.
+func (s *session) use(auth *dtfapi.AuthResponse) {
+	s.api.Token = auth.AccessToken
+	s.fresh = true
+	if auth.RefreshToken != "" {
+		s.refreshToken = auth.RefreshToken
+	}
+	s.expiresAt = time.Time{}
+	if auth.ExpiresIn > 0 {
+		s.expiresAt = time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	}
+}
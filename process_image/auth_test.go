@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test_webhook_service/config"
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/dtfmock"
+)
+
+// useMock points the worker globals at handler for the duration of the
+// test, with every pipeline stage off so no images are fetched.
+func useMock(t *testing.T, handler http.Handler, workers, orders int) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	saved := struct {
+		opts       runOptions
+		apiURL     string
+		httpClient *http.Client
+		monitor    *Monitor
+		budget     *orderBudget
+		drain      *queueDrain
+	}{opts, apiURL, httpClient, monitor, budget, drain}
+	t.Cleanup(func() {
+		opts, apiURL, httpClient = saved.opts, saved.apiURL, saved.httpClient
+		monitor, budget, drain = saved.monitor, saved.budget, saved.drain
+	})
+
+	opts.Stages = stages{}
+	opts.WorkDir = t.TempDir()
+	opts.PollInterval = time.Millisecond
+	opts.MaxBackoff = 5 * time.Millisecond
+	apiURL, httpClient = srv.URL, srv.Client()
+	monitor = newMonitor(workers)
+	budget = newOrderBudget(orders)
+	drain = newQueueDrain(workers)
+}
+
+func runWorker(account config.Account) WorkerStatus {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	worker(context.Background(), 0, account, &wg)
+	wg.Wait()
+	return monitor.Workers()[0]
+}
+
+func TestSessionAuthenticate(t *testing.T) {
+	mock := dtfmock.New(dtfmock.Config{TokenTTL: time.Hour, Seed: 1})
+	useMock(t, mock, 1, 0)
+
+	sess := &session{account: config.Account{UserName: "designer1", Password: "designer"}, api: dtfapi.New(apiURL, httpClient)}
+	if err := sess.authenticate(context.Background()); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if sess.api.Token == "" || sess.refreshToken == "" {
+		t.Errorf("session has token %q and refresh token %q, want both", sess.api.Token, sess.refreshToken)
+	}
+	if !sess.fresh {
+		t.Error("new token is not marked fresh")
+	}
+	if sess.expiring() {
+		t.Errorf("token valid for an hour is expiring (expires at %v)", sess.expiresAt)
+	}
+
+	// Renewing uses the refresh token and hands out a new access token.
+	old := sess.api.Token
+	sess.fresh = false
+	if err := sess.authenticate(context.Background()); err != nil {
+		t.Fatalf("authenticate with refresh token: %v", err)
+	}
+	if sess.api.Token == old || !sess.fresh {
+		t.Errorf("refresh kept token %q (fresh %v)", sess.api.Token, sess.fresh)
+	}
+}
+
+func TestSessionAuthenticateRejected(t *testing.T) {
+	mock := dtfmock.New(dtfmock.Config{Seed: 1})
+	useMock(t, mock, 1, 0)
+	opts.PollInterval = time.Hour // a retry would hang the test
+
+	sess := &session{account: config.Account{UserName: "designer1", Password: "wrong"}, api: dtfapi.New(apiURL, httpClient)}
+	err := sess.authenticate(context.Background())
+	if !dtfapi.IsUnauthorized(err) {
+		t.Errorf("authenticate with a wrong password = %v, want the 401 without retrying", err)
+	}
+}
+
+func TestWorkerViewerStops(t *testing.T) {
+	mock := dtfmock.New(dtfmock.Config{Seed: 1})
+	mock.Seed(3)
+	useMock(t, mock, 1, 3)
+
+	status := runWorker(config.Account{UserName: "viewer", Password: "viewer"})
+	if status.State != stateLoginFailed {
+		t.Errorf("viewer worker ended %q, want %q", status.State, stateLoginFailed)
+	}
+	if monitor.Relogins() != 0 {
+		t.Errorf("viewer worker logged in again %d times, want it to stop on the first 403", monitor.Relogins())
+	}
+	if stats := mock.Stats(); stats.Approved != 0 {
+		t.Errorf("viewer approved %d orders", stats.Approved)
+	}
+}
+
+// rejectOnce answers the n-th call of a product POST with 401, as if the
+// token had been revoked mid-order.
+type rejectOnce struct {
+	http.Handler
+	n     int64
+	calls int64
+}
+
+func (h *rejectOnce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/products/") && atomic.AddInt64(&h.calls, 1) == h.n {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func TestWorkerRetriesOrderAfterRelogin(t *testing.T) {
+	mock := dtfmock.New(dtfmock.Config{Seed: 1})
+	id := mock.AddOrder(dtfmock.SamplePath+"tmp-img-ABC-1-22x5.png", dtfmock.SamplePath+"tmp-img-ABC-2-22x10.png")
+	useMock(t, &rejectOnce{Handler: mock, n: 2}, 1, 1)
+
+	status := runWorker(config.Account{UserName: "designer1", Password: "designer"})
+	if status.State != stateDone || status.Orders != 1 {
+		t.Errorf("worker ended %q with %d orders, want %q with 1", status.State, status.Orders, stateDone)
+	}
+	if monitor.Relogins() != 1 {
+		t.Errorf("relogins = %d, want 1", monitor.Relogins())
+	}
+	if stats := mock.Stats(); stats.Approved != 1 {
+		t.Errorf("mock approved %d orders, want order %d approved after the retry", stats.Approved, id)
+	}
+}
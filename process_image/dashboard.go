@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"test_webhook_service/tui"
+)
+
+// dashboardFrame renders the live process_image view for -tui.
+func dashboardFrame(m *Monitor) func() []string {
+	throughput := tui.NewSeries(60)
+	lastTick := time.Now()
+	lastTotal := 0
+
+	return func() []string {
+		width, height := tui.Size()
+		workers := m.Workers()
+		accounts := m.Accounts()
+
+		total := 0
+		states := make(map[workerState]int)
+		for _, w := range workers {
+			total += w.Orders
+			states[w.State]++
+		}
+
+		now := time.Now()
+		perMinute := 0.0
+		if elapsed := now.Sub(lastTick).Minutes(); elapsed > 0 {
+			perMinute = float64(total-lastTotal) / elapsed
+		}
+		throughput.Add(perMinute)
+		lastTick, lastTotal = now, total
+
+		lines := []string{
+			tui.Rule("process_image", width),
+			fmt.Sprintf(" Target   %s", apiURL),
+			fmt.Sprintf(" Elapsed  %-12s Approved %d   Orders/min %.1f  %s",
+				time.Since(m.start).Round(time.Second), total, perMinute, tui.Sparkline(throughput.Values())),
+			fmt.Sprintf(" States   logging in %d | polling %d | downloading %d | processing %d | uploading %d | approving %d | backing off %d | throttled %d | done %d",
+				states[stateLoggingIn], states[statePolling], states[stateDownloading], states[stateProcessing], states[stateUploading],
+				states[stateApproving], states[stateBackingOff], states[stateThrottled], states[stateDone]),
+		}
+
+		if failed := states[stateLoginFailed]; failed > 0 || m.Relogins() > 0 {
+			lines = append(lines, fmt.Sprintf(" Logins   %d re-logins, %d workers stopped after login failures", m.Relogins(), failed))
+		}
+		if failed := m.PreflightFailures(); failed > 0 {
+			lines = append(lines, fmt.Sprintf(" Preflight %d products failed, their orders are not approved", failed))
+		}
+		if throttledFor, events := m.Throttled(); events > 0 {
+			lines = append(lines, fmt.Sprintf(" Throttled %d responses, %s paused across workers", events, tui.FormatDuration(throttledFor)))
+		}
+		lines = append(lines, tui.Rule("Workers", width))
+
+		tail := []string{tui.Rule("Accounts", width)}
+		if len(accounts) == 0 {
+			tail = append(tail, " none yet")
+		}
+		for _, a := range accounts {
+			tail = append(tail, fmt.Sprintf(" %-16s %3dw %6d %s", a.Account, a.Workers, a.Orders, tui.Bar(float64(a.Orders), float64(total), 30)))
+		}
+
+		// The workers get the rows the rest of the frame leaves on the
+		// screen, at least one; the ones that do not fit are summed up by
+		// state.
+		rows := make([]string, len(workers))
+		for i, w := range workers {
+			rows[i] = fmt.Sprintf(" #%-3d %-12s %-14s %-10s orders %d",
+				i, w.Account, w.State, time.Since(w.Since).Round(time.Second), w.Orders)
+		}
+		fit := max(height-1-len(lines)-len(tail), 1)
+		rows = tui.Clip(rows, fit, func(hidden int) string {
+			return " " + hiddenWorkers(workers[len(workers)-hidden:])
+		})
+		lines = append(lines, rows...)
+
+		return append(lines, tail...)
+	}
+}
+
+// hiddenWorkers summarises the workers left off the dashboard, e.g.
+// "+12 more workers: polling 9, uploading 3".
+func hiddenWorkers(workers []WorkerStatus) string {
+	counts := make(map[workerState]int)
+	var order []workerState
+	for _, w := range workers {
+		if counts[w.State] == 0 {
+			order = append(order, w.State)
+		}
+		counts[w.State]++
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	parts := make([]string, len(order))
+	for i, st := range order {
+		parts[i] = fmt.Sprintf("%s %d", st, counts[st])
+	}
+	return fmt.Sprintf("+%d more workers: %s", len(workers), strings.Join(parts, ", "))
+}
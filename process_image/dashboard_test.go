@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+func TestDashboardFrameFitsScreen(t *testing.T) {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		t.Skip("terminal size comes from stdout, not LINES")
+	}
+	t.Setenv("COLUMNS", "120")
+	t.Setenv("LINES", "20")
+
+	m := newMonitor(50)
+	for i := range 50 {
+		state := statePolling
+		if i%5 == 0 {
+			state = stateUploading
+		}
+		m.SetState(i, state)
+	}
+	lines := dashboardFrame(m)()
+
+	if len(lines) > 19 {
+		t.Errorf("frame has %d lines, want at most 19 on a 20 line terminal", len(lines))
+	}
+	var summary string
+	shown := 0
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, " #"):
+			shown++
+		case strings.Contains(line, "more workers"):
+			summary = line
+		}
+	}
+	if want := " +" + strconv.Itoa(50-shown) + " more workers: "; !strings.HasPrefix(summary, want) {
+		t.Errorf("summary = %q, want it to start %q", summary, want)
+	}
+	if !strings.Contains(summary, "polling") || !strings.Contains(summary, "uploading") {
+		t.Errorf("summary %q does not count the hidden workers by state", summary)
+	}
+	if !strings.HasPrefix(lines[len(lines)-2], "── Accounts") {
+		t.Errorf("accounts section pushed off the frame: %q", lines[len(lines)-2:])
+	}
+}
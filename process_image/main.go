@@ -1,213 +1,397 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"test_webhook_service/config"
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/har"
+	"test_webhook_service/httpclient"
+	"test_webhook_service/soak"
+	"test_webhook_service/tracing"
+	"test_webhook_service/tui"
 )
 
-const PollInterval = 1 * time.Second
+// monitor tracks what every worker is doing for the dashboard.
+var monitor *Monitor
 
 func main() {
-
-	var wg sync.WaitGroup
-	// 5 workers pool
-	for i := 0; i < 20; i++ {
-		wg.Add(1)
-		go worker(i, &wg)
+	if err := run(); err != nil {
+		log.Fatal(err)
 	}
-
-	wg.Wait()
 }
 
-var processedImagesMap = map[string]string{
-
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-1-22x5.png":     "./pdf/tmp-out-ABC-1-22x5.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-2-22x10.png":    "./pdf/tmp-out-ABC-2-22x10.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-3-22x20.png":    "./pdf/tmp-out-ABC-3-22x20.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-4-22x30.png":    "./pdf/tmp-out-ABC-4-22x30.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-5-22x40.png":    "./pdf/tmp-out-ABC-5-22x40.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-6-22x50.png":    "./pdf/tmp-out-ABC-6-22x50.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-7-22x60.png":    "./pdf/tmp-out-ABC-7-22x60.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-8-22x70.png":    "./pdf/tmp-out-ABC-8-22x70.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-9-22x80.png":    "./pdf/tmp-out-ABC-9-22x80.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-10-22x90.png":   "./pdf/tmp-out-ABC-10-22x90.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-11-22x100.png":  "./pdf/tmp-out-ABC-11-22x100.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-12-22x110.png":  "./pdf/tmp-out-ABC-12-22x110.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-13-22x120.png":  "./pdf/tmp-out-ABC-13-22x120.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-14-22x130.png":  "./pdf/tmp-out-ABC-14-22x130.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-15-22x140.png":  "./pdf/tmp-out-ABC-15-22x140.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-16-22x150.png":  "./pdf/tmp-out-ABC-16-22x150.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-17-22x160.png":  "./pdf/tmp-out-ABC-17-22x160.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-18-22x170.png":  "./pdf/tmp-out-ABC-18-22x170.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-19-22x180.png":  "./pdf/tmp-out-ABC-19-22x180.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-20-22x190.png":  "./pdf/tmp-out-ABC-20-22x190.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-21-22x200.png":  "./pdf/tmp-out-ABC-21-22x200.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-22-22x250.png":  "./pdf/tmp-out-ABC-22-22x250.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-23-22x300.png":  "./pdf/tmp-out-ABC-23-22x300.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-24-22x400.png":  "./pdf/tmp-out-ABC-24-22x400.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-25-22x500.png":  "./pdf/tmp-out-ABC-25-22x500.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-26-22x600.png":  "./pdf/tmp-out-ABC-26-22x600.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-27-22x750.png":  "./pdf/tmp-out-ABC-27-22x750.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-28-22x1000.png": "./pdf/tmp-out-ABC-28-22x1000.pdf",
-}
+// run processes orders until the workers stop. It returns errors instead of
+// exiting so the final report and the HAR, soak and trace flushes run.
+func run() error {
+	showTUI := flag.Bool("tui", false, "Show a live full-screen dashboard instead of scrolling log lines")
+	envName := flag.String("env", "", "Target environment from the config file (default $"+config.EnvName+" or the configured default)")
+	configPath := flag.String("config", "", "Environment config file (default $"+config.EnvFile+" or "+config.DefaultFile+")")
+	listEnvs := flag.Bool("list-envs", false, "List the configured environments and exit")
+	harPath := flag.String("har", "", "Write slow and failed API request/response pairs to this HAR file")
+	harThreshold := flag.Duration("har-threshold", 2*time.Second, "Latency above which a successful API call is captured to the HAR file")
+	harBudget := flag.Int("har-budget", 100, "Maximum number of API calls captured to the HAR file")
+	soakDuration := flag.Duration("soak", 0, "Soak test: process orders for this long (ignoring -orders-per-worker and -total-orders unless set) while sampling client goroutines, heap, fds and connections for leaks")
+	soakInterval := flag.Duration("soak-interval", 30*time.Second, "How often to sample resource usage in soak mode")
+	soakFile := flag.String("soak-file", "", "Write the soak samples to this CSV file")
+	flag.IntVar(&opts.Workers, "workers", opts.Workers, "Number of concurrent workers")
+	flag.IntVar(&opts.OrdersPerWorker, "orders-per-worker", opts.OrdersPerWorker, "Polls each worker makes before stopping (used when -total-orders is 0)")
+	flag.IntVar(&opts.TotalOrders, "total-orders", opts.TotalOrders, "Orders to take from the queue across all workers (0 = per-worker limit)")
+	flag.DurationVar(&opts.PollInterval, "poll-interval", opts.PollInterval, "Delay between polls of /orders/next")
+	flag.DurationVar(&opts.MaxBackoff, "max-backoff", opts.MaxBackoff, "Cap for the backoff while the queue is empty and for Retry-After pauses")
+	flag.BoolVar(&opts.UntilEmpty, "until-empty", opts.UntilEmpty, "Exit once every worker has found /orders/next empty")
+	accountsFile := flag.String("accounts", "", "File with the accounts workers log in with: a JSON array or username:password lines (default $"+config.EnvAccounts+", then the environment's accounts)")
+	assignment := flag.String("account-assignment", "round-robin", "How workers get accounts: "+strings.Join(accountAssignments, ", "))
+	accountSeed := flag.Int64("account-seed", 0, "Seed for -account-assignment random (default time based)")
+	stageList := flag.String("stages", opts.Stages.String(), "Print file pipeline stages to run: download, process, upload, all or none (skipped stages use the prebuilt sample files and URLs)")
+	flag.StringVar(&opts.WorkDir, "work-dir", filepath.Join(os.TempDir(), "process_image"), "Directory for downloaded images and rendered print files")
+	flag.StringVar(&opts.InputDir, "input-dir", opts.InputDir, "Directory with the customer images when the download stage is off; the samples send_webhook orders, tmp-img-ABC-1-22x5.png to tmp-img-ABC-28-22x1000.png, are generated there when missing")
+	flag.StringVar(&opts.PrebuiltDir, "prebuilt-dir", opts.PrebuiltDir, "Directory with the prebuilt sample print files, used when the process stage is off; the 22x5 to 22x1000 inch samples (ABC-1 to ABC-28) are rendered there when missing")
+	flag.StringVar(&opts.PrebuiltURL, "prebuilt-url", opts.PrebuiltURL, "Base URL of the hosted prebuilt sample print files, used when the upload stage is off; it must serve the 22x5 to 22x1000 inch samples (ABC-1 to ABC-28)")
+	flag.BoolVar(&opts.KeepFiles, "keep-files", opts.KeepFiles, "Keep downloaded images and print files in -work-dir")
+	flag.IntVar(&opts.DPI, "dpi", opts.DPI, "Resolution print files are rendered at (0 = the customer image's own pixels)")
+	flag.BoolVar(&opts.Preflight, "preflight", opts.Preflight, "Check customer images before making print files and reject orders with an image that fails")
+	flag.IntVar(&opts.PreflightDPI, "preflight-dpi", opts.PreflightDPI, "Resolution customer images must have on their sheet to pass preflight")
+	flag.StringVar(&opts.PreflightDir, "preflight-dir", opts.PreflightDir, "Directory for the per-product preflight JSON reports")
+	flag.IntVar(&opts.UploadAttempts, "upload-attempts", opts.UploadAttempts, "Attempts for each presigned request and upload before the product fails")
+	flag.Int64Var(&opts.MultipartThreshold, "multipart-threshold", opts.MultipartThreshold, "Print files larger than this many bytes are uploaded in parts")
+	flag.Int64Var(&opts.PartSize, "part-size", opts.PartSize, "Size of each part of a multipart upload in bytes (0 disables multipart uploads)")
+	flag.IntVar(&opts.UploadConcurrency, "upload-concurrency", opts.UploadConcurrency, "Parts of one multipart upload sent at the same time")
+	flag.BoolVar(&opts.UploadChecksums, "upload-checksums", opts.UploadChecksums, "Send Content-MD5 and x-amz-checksum-sha256 with uploads")
+	flag.Int64Var(&opts.MaxImageBytes, "max-image-bytes", opts.MaxImageBytes, "Largest customer image the download stage accepts")
+	var transportOpts httpclient.Options
+	transportOpts.RegisterFlags(flag.CommandLine)
+	var traceOpts tracing.Options
+	traceOpts.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	var baseTLS *tls.Config
+
+	if path := config.Find(*configPath); path != "" {
+		cfg, err := config.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load environments: %w", err)
+		}
+		if *listEnvs {
+			cfg.List(os.Stdout, "process_image")
+			return nil
+		}
+		env, err := cfg.Select(*envName, "process_image")
+		if err != nil {
+			return fmt.Errorf("failed to select environment: %w", err)
+		}
 
-var processedImagesMapUrl = map[string]string{
-
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-1-22x5.png":     "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-1-22x5.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-2-22x10.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-2-22x10.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-3-22x20.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-3-22x20.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-4-22x30.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-4-22x30.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-5-22x40.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-5-22x40.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-6-22x50.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-6-22x50.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-7-22x60.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-7-22x60.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-8-22x70.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-8-22x70.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-9-22x80.png":    "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-9-22x80.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-10-22x90.png":   "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-10-22x90.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-11-22x100.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-11-22x100.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-12-22x110.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-12-22x110.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-13-22x120.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-13-22x120.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-14-22x130.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-14-22x130.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-15-22x140.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-15-22x140.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-16-22x150.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-16-22x150.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-17-22x160.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-17-22x160.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-18-22x170.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-18-22x170.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-19-22x180.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-19-22x180.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-20-22x190.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-20-22x190.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-21-22x200.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-21-22x200.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-22-22x250.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-22-22x250.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-23-22x300.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-23-22x300.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-24-22x400.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-24-22x400.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-25-22x500.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-25-22x500.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-26-22x600.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-26-22x600.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-27-22x750.png":  "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-27-22x750.pdf",
-	"https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-img-ABC-28-22x1000.png": "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/tmp-out-ABC-28-22x1000.pdf",
-}
+		apiURL = env.APIURL()
+		if len(env.Accounts) > 0 {
+			accounts = env.Accounts
+		}
+		if env.Workers > 0 && !config.IsFlagSet("workers") {
+			opts.Workers = env.Workers
+		}
+		if env.TotalOrders > 0 && !config.IsFlagSet("total-orders") {
+			opts.TotalOrders = env.TotalOrders
+		}
+		if env.PollInterval > 0 && !config.IsFlagSet("poll-interval") {
+			opts.PollInterval = time.Duration(env.PollInterval)
+		}
+		if env.MaxBackoff > 0 && !config.IsFlagSet("max-backoff") {
+			opts.MaxBackoff = time.Duration(env.MaxBackoff)
+		}
+		baseTLS, err = env.TLSConfig()
+		if err != nil {
+			return fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		log.Printf("Environment: %s (%s)", env.Name, apiURL)
+	} else if *listEnvs || *envName != "" {
+		return fmt.Errorf("no %s found (copy %s and fill in the passwords)", config.DefaultFile, config.ExampleFile)
+	}
 
-type Response[T any] struct {
-	Data      T         `json:"data,omitempty" doc:"Response data"`
-	Message   string    `json:"message,omitempty" doc:"Human-readable message"`
-	Timestamp time.Time `json:"timestamp" doc:"Response timestamp"`
-}
+	transport, err := httpclient.NewTransport(transportOpts, baseTLS)
+	if err != nil {
+		return fmt.Errorf("failed to configure transport: %w", err)
+	}
+	httpClient = &http.Client{Transport: transport}
 
-type LoginRequest struct {
-	UserName string `json:"username"`
-	Password string `json:"password"`
-}
+	var soakMonitor *soak.Monitor
+	if *soakDuration > 0 {
+		soakMonitor = soak.NewMonitor(*soakInterval)
+		soakMonitor.Track(transport)
+		httpClient.Transport = soakMonitor.Transport(transport)
+	}
 
-type PresignedRequest struct {
-	Key string `json:"key"`
-}
+	var sampler *har.Sampler
+	if *harPath != "" {
+		sampler = har.NewSampler("process_image", *harThreshold, *harBudget)
+		httpClient.Transport = sampler.Transport(httpClient.Transport)
+		log.Printf("HAR capture: %s (failed or slower than %v, up to %d)", *harPath, *harThreshold, *harBudget)
+	}
 
-type ProcessOrderProductRequest struct {
-	FinalImgUrl string `json:"final_img_url"`
-}
+	tracer, err := tracing.New("process_image", traceOpts)
+	if err != nil {
+		return fmt.Errorf("failed to configure tracing: %w", err)
+	}
+	httpClient.Transport = tracer.Transport(httpClient.Transport)
 
-type AuthResponse struct {
-	AccessToken string `json:"access_token"`
-	UserName    string `json:"user_name"`
-	UserId      int    `json:"user_id"`
-	Role        string `json:"role"`
-	Name        string `json:"name"`
-}
+	switch {
+	case *accountsFile != "":
+		loaded, err := config.LoadAccounts(*accountsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load accounts: %w", err)
+		}
+		accounts = loaded
+	case os.Getenv(config.EnvAccounts) != "":
+		loaded, err := config.ParseAccounts(os.Getenv(config.EnvAccounts))
+		if err != nil {
+			return fmt.Errorf("failed to parse $%s: %w", config.EnvAccounts, err)
+		}
+		accounts = loaded
+	}
+	if *accountSeed == 0 {
+		*accountSeed = time.Now().UnixNano()
+	}
 
-type OrderProductsResponse struct {
-	ID             int64     `json:"id"`
-	OrderID        int64     `json:"order_id"`
-	FulfillmentID  string    `json:"fulfillment_id"`
-	ProductID      int64     `json:"product_id"`
-	Sku            string    `json:"sku"`
-	CustomerImgUrl string    `json:"customer_img_url"`
-	FinalImgUrl    string    `json:"final_img_url"`
-	Processed      bool      `json:"processed"`
-	CreatedAt      time.Time `json:"created_at,omitempty"`
-	UpdatedAt      time.Time `json:"updated_at,omitempty"`
-	Quantity       int32     `json:"quantity"`
-}
+	if opts.Workers < 1 || opts.PollInterval <= 0 || opts.MaxBackoff < opts.PollInterval {
+		return errors.New("-workers must be positive and -max-backoff at least -poll-interval")
+	}
+	httpclient.MaxRetryAfter = opts.MaxBackoff
+	if opts.UploadAttempts < 1 || opts.PartSize < 0 {
+		return errors.New("-upload-attempts must be positive and -part-size not negative")
+	}
+	if opts.Stages, err = parseStages(*stageList); err != nil {
+		return fmt.Errorf("invalid -stages: %w", err)
+	}
+	log.Printf("Pipeline stages: %s", opts.Stages)
+	if err := prepareSamples(opts.Stages); err != nil {
+		return err
+	}
 
-type PresignedResponse struct {
-	URL         string `json:"upload_url"`
-	DownloadURL string `json:"download_url"`
-	ExpiresAt   string `json:"expires_at"`
-	Key         string `json:"key"`
-}
+	if *soakDuration > 0 {
+		if !config.IsFlagSet("orders-per-worker") {
+			opts.OrdersPerWorker = math.MaxInt
+		}
+		if !config.IsFlagSet("total-orders") {
+			opts.TotalOrders = 0
+		}
+		log.Printf("Soak: running for %v, sampling every %v", *soakDuration, *soakInterval)
+	}
+	switch {
+	case opts.TotalOrders > 0:
+		log.Printf("Workers: %d, %d orders shared", opts.Workers, opts.TotalOrders)
+	case opts.OrdersPerWorker == math.MaxInt:
+		log.Printf("Workers: %d, polling until the soak run ends", opts.Workers)
+	default:
+		log.Printf("Workers: %d, %d polls each", opts.Workers, opts.OrdersPerWorker)
+	}
+	budget = newOrderBudget(opts.TotalOrders)
+	if opts.UntilEmpty {
+		drain = newQueueDrain(opts.Workers)
+		log.Printf("Running until the queue is empty")
+	}
 
-//const url = "https://dtf-api-dedicated.daovudat.site"
+	workerAccounts, err := assignAccounts(accounts, opts.Workers, *assignment, *accountSeed)
+	if err != nil {
+		return err
+	}
+	log.Printf("Accounts: %d, assigned %s", len(accounts), *assignment)
+
+	monitor = newMonitor(opts.Workers)
+
+	// SIGINT and SIGTERM, like the end of a soak run, let every worker
+	// finish its current order and stop; a second signal kills the process.
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+	ctx := sigCtx
+	if *soakDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(sigCtx, *soakDuration)
+		defer cancel()
+	}
 
-//const url = "https://dtf-api.daovudat.site"
+	var screen *tui.Screen
+	screenDone := make(chan struct{})
+	screenStopped := make(chan struct{})
+	if *showTUI {
+		screen = tui.NewScreen(500)
+		screen.Start()
+		defer screen.Stop()
+		go func() {
+			defer close(screenStopped)
+			screen.Run(time.Second, screenDone, dashboardFrame(monitor))
+		}()
+	}
 
-//const url = "http://localhost:8000"
+	if soakMonitor != nil {
+		soakMonitor.Start()
+	}
 
-const url = "https://dtf-api-chicago.daovudat.site"
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go worker(ctx, i, workerAccounts[i], &wg)
+	}
 
-const numberOfOrderToProcess = 500
+	wg.Wait()
+	if sigCtx.Err() != nil {
+		log.Printf("Interrupted, workers stopped after their current order")
+	}
 
-func worker(idx int, wg *sync.WaitGroup) {
+	if screen != nil {
+		close(screenDone)
+		<-screenStopped
+		screen.Stop()
+	}
 
-	backoff := PollInterval
-	log.Printf("Worker %d started", idx)
+	elapsed := time.Since(monitor.start)
+	for _, a := range monitor.Accounts() {
+		log.Printf("Account %s (%d workers) approved %d orders, %.2f orders/min",
+			a.Account, a.Workers, a.Orders, float64(a.Orders)/elapsed.Minutes())
+	}
 
-	// 0. Login
-	urlLogin := fmt.Sprintf("%s/auth/login", url)
-	choice := rand.Intn(3)
-	var logReq LoginRequest
-	switch choice {
-	case 0:
-		logReq = LoginRequest{
-			UserName: "admin",
-			Password: "admin",
-		}
-	case 1:
-		logReq = LoginRequest{
-			UserName: "designer1",
-			Password: "designer",
-		}
-	case 2:
-		logReq = LoginRequest{
-			UserName: "designer2",
-			Password: "designer",
+	throttledFor, throttleEvents := monitor.Throttled()
+	log.Printf("Re-logins: %d", monitor.Relogins())
+	if opts.Preflight && opts.Stages.needsInput() {
+		log.Printf("Preflight Failures: %d (reports in %s)", monitor.PreflightFailures(), opts.PreflightDir)
+	}
+	log.Printf("Throttled Responses: %d", throttleEvents)
+	log.Printf("Time Throttled (all workers): %v", throttledFor.Round(time.Millisecond))
+	log.Printf("Effective Rate Permitted: %.2f orders/min", float64(monitor.TotalOrders())/elapsed.Minutes())
+
+	if sampler != nil {
+		captured, dropped := sampler.Len()
+		if err := sampler.WriteFile(*harPath); err != nil {
+			log.Printf("Failed to write %s: %v", *harPath, err)
+		} else {
+			log.Printf("HAR: %d API calls captured to %s (%d over budget)", captured, *harPath, dropped)
 		}
 	}
-	payload, _ := json.Marshal(logReq)
 
-	req, err := http.NewRequestWithContext(
-		context.Background(),
-		"POST",
-		urlLogin,
-		bytes.NewBuffer(payload))
-	if err != nil {
-		log.Fatalf("worker-%d: failed to create login request: %v", idx, err)
+	if soakMonitor != nil {
+		soakMonitor.Stop()
+		soakMonitor.Report()
+		if *soakFile != "" {
+			if err := soakMonitor.WriteCSV(*soakFile); err != nil {
+				log.Printf("Failed to write %s: %v", *soakFile, err)
+			}
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("worker-%d: failed to login: %v", idx, err)
+	if tracer != nil {
+		spans, dropped, err := tracer.Shutdown()
+		if err != nil {
+			log.Printf("Failed to export spans: %v", err)
+		}
+		log.Printf("Traces: %d client spans recorded (%d dropped)", spans, dropped)
 	}
+	return nil
+}
+
+// apiURL is the DTF API base URL. It defaults to the chicago deployment and
+// is replaced by the environment selected with -env, see
+// environments.example.json.
+var apiURL = "https://dtf-api-chicago.daovudat.site"
+
+// accounts are the credentials workers log in with.
+var accounts = []config.Account{
+	{UserName: "admin", Password: "admin"},
+	{UserName: "designer1", Password: "designer"},
+	{UserName: "designer2", Password: "designer"},
+}
+
+// httpClient is used for every API call.
+var httpClient = http.DefaultClient
+
+// claimedOrder is an order a worker claimed but could not finish because
+// the API stopped accepting its token. Products before next are already
+// processed.
+type claimedOrder struct {
+	products []dtfapi.OrderProductsResponse
+	next     int
+}
+
+func worker(ctx context.Context, idx int, account config.Account, wg *sync.WaitGroup) {
+
+	backoff := opts.PollInterval
+	log.Printf("Worker %d started", idx)
 
-	if resp.StatusCode != 200 {
-		log.Fatalf("worker-%d: login failed", idx)
+	// 0. Login
+	monitor.SetState(idx, stateLoggingIn)
+	monitor.SetAccount(idx, account.UserName)
+	api := dtfapi.New(apiURL, httpClient)
+	api.WithRoute = tracing.WithRoute
+	sess := &session{idx: idx, account: account, api: api}
+	if err := sess.authenticate(ctx); err != nil {
+		log.Printf("worker-%d: login as %s failed, stopping worker: %v", idx, account.UserName, err)
+		monitor.SetState(idx, stateLoginFailed)
+		drain.Exit(idx)
+		wg.Done()
+		return
 	}
-	var authResp Response[AuthResponse]
-	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		log.Fatalf("worker-%d: failed to decode login response: %v", idx, err)
+
+	// relogin renews the session; on failure only this worker stops.
+	finalState := stateDone
+	relogin := func() bool {
+		monitor.SetState(idx, stateLoggingIn)
+		if err := sess.authenticate(ctx); err != nil {
+			log.Printf("worker-%d: re-login as %s failed, stopping worker: %v", idx, account.UserName, err)
+			finalState = stateLoginFailed
+			return false
+		}
+		monitor.Relogin()
+		log.Printf("worker-%d: logged in again as %s", idx, account.UserName)
+		return true
 	}
-	accessToken := authResp.Data.AccessToken
 
 	numProcessedOrder := 0
+	// retry is the order to finish after logging in again, taken up
+	// instead of polling for a new one.
+	var retry *claimedOrder
 
 	for {
 
-		if numProcessedOrder == numberOfOrderToProcess {
+		if ctx.Err() != nil {
+			break
+		}
+		if budget == nil && numProcessedOrder == opts.OrdersPerWorker {
+			break
+		}
+		if sess.expiring() && !relogin() {
 			break
 		}
+		if retry == nil && !budget.Take() {
+			log.Printf("worker-%d: order budget spent", idx)
+			break
+		}
+		// claimed is set once /orders/next hands this worker an order;
+		// otherwise the budget reservation is returned.
+		claimed := retry != nil
+
+		// throttle is set when the backend answers 429 and holds how long
+		// it asked this worker to pause.
+		var throttle time.Duration
+		// reauth is set when the API no longer accepts the access token.
+		reauth := false
+		// denied is set when a fresh token is refused with 403: the account
+		// is not allowed to process orders and logging in again won't help.
+		denied := false
 
 		processed := func() bool {
 			// recovering from panic
@@ -220,236 +404,144 @@ func worker(idx int, wg *sync.WaitGroup) {
 			}()
 
 			// 1. Get next order
-			getNextOrderUrl := fmt.Sprintf("%s/orders/next", url)
-
-			reqNextOrder, err := http.NewRequestWithContext(
-				context.Background(),
-				"GET",
-				getNextOrderUrl,
-				nil)
-			if err != nil {
-				log.Fatalf("worker-%d: failed to create next order request: %v", idx, err)
+			var orderProducts []dtfapi.OrderProductsResponse
+			next := 0
+			retried := retry != nil
+
+			// failed logs an API error and ends this attempt, arranging a
+			// pause or a new login when the API asked for one. An order
+			// claimed before the token was rejected is kept to retry once
+			// after the new login.
+			failed := func(op string, err error) bool {
+				if d, ok := dtfapi.IsThrottled(err); ok {
+					log.Printf("worker-%d: throttled on %s, retry after %v", idx, op, d)
+					throttle = d
+				} else if dtfapi.IsUnauthorized(err) || dtfapi.IsForbidden(err) && !sess.fresh {
+					log.Printf("worker-%d: %s rejected token: %v", idx, op, err)
+					reauth = true
+					if claimed && !retried {
+						retry = &claimedOrder{products: orderProducts, next: next}
+					} else if claimed {
+						log.Printf("worker-%d: giving up on order %d, rejected again after logging in", idx, orderProducts[0].OrderID)
+					}
+				} else if dtfapi.IsForbidden(err) {
+					log.Printf("worker-%d: %s forbidden right after logging in as %s, the account may not process orders: %v", idx, op, account.UserName, err)
+					denied = true
+				} else {
+					log.Printf("worker-%d: failed to %s: %v", idx, op, err)
+				}
 				return true
 			}
+			ctx := context.Background()
+
+			if retry != nil {
+				orderProducts, next = retry.products, retry.next
+				retry = nil
+				log.Printf("worker-%d: retrying order %d after logging in again", idx, orderProducts[0].OrderID)
+			} else {
+				monitor.SetState(idx, statePolling)
+				var err error
+				orderProducts, err = sess.api.NextOrder(ctx)
+				if err != nil {
+					return failed("get next order", err)
+				}
+				log.Printf("worker-%d: got next order: %v", idx, orderProducts)
 
-			reqNextOrder.Header.Set("Authorization", "Bearer "+accessToken)
-			respNextOrder, err := http.DefaultClient.Do(reqNextOrder)
-			if err != nil {
-				log.Printf("worker-%d: failed to get next order: %v", idx, err)
-				return true
+				if len(orderProducts) == 0 {
+					log.Printf("worker-%d: no more orders", idx)
+					drain.Empty(idx)
+					return false
+				}
+				claimed = true
+				drain.Busy(idx)
 			}
 
-			var orderProducts Response[[]OrderProductsResponse]
-			if err := json.NewDecoder(respNextOrder.Body).Decode(&orderProducts); err != nil {
-				log.Printf("worker-%d: failed to decode next order response: %v", idx, err)
+			// 2. Download and preflight the customer image of every
+			// product before submitting any of them
+			jobs, err := prepareOrder(ctx, idx, orderProducts[next:])
+			defer cleanupJobs(jobs)
+			if errors.Is(err, errPreflight) {
+				log.Printf("worker-%d: order %d rejected by preflight, no product submitted: %v", idx, orderProducts[0].OrderID, err)
 				return true
 			}
-			respNextOrder.Body.Close()
-			log.Printf("worker-%d: got next order: %v", idx, orderProducts.Data)
-
-			if len(orderProducts.Data) == 0 {
-				log.Printf("worker-%d: no more orders", idx)
-				return false
+			if err != nil {
+				return failed("prepare print file", err)
 			}
 
-			// 2. Download Image of each product
-			for _, product := range orderProducts.Data {
-				// 2.1 Download Image
-				//log.Printf("worker-%d: downloading image: %s", idx, product.CustomerImgUrl)
-				//reqDownload, err := http.NewRequestWithContext(
-				//	context.Background(),
-				//	"GET",
-				//	product.CustomerImgUrl,
-				//	nil)
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to create download request: %v", idx, err)
-				//	return true
-				//}
-				//respDownload, err := http.DefaultClient.Do(reqDownload)
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to download image: %v", idx, err)
-				//	return true
-				//}
-				//
-				//imageData, err := io.ReadAll(respDownload.Body)
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to read image: %v", idx, err)
-				//	return true
-				//}
-				//
-				//respDownload.Body.Close()
-
-				// 2.2 Process Image (final img = customer img data)
-				//log.Printf("worker-%d: processing image: %s", idx, product.CustomerImgUrl)
-				//finalImageData := imageData
-				//contentType := http.DetectContentType(finalImageData)
-				//log.Printf("worker-%d: image content type: %s", idx, contentType)
-
-				//finalImgFilePath, ok := processedImagesMap[product.CustomerImgUrl]
-				//if !ok {
-				//	log.Printf("worker-%d: failed to map", idx)
-				//	panic(1)
-				//}
-				//
-				//// Load image from local file
-				//finalImageData, err := os.ReadFile(finalImgFilePath)
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to read image from %s: %v", idx, finalImgFilePath, err)
-				//	return true
-				//}
-				//contentType := http.DetectContentType(finalImageData)
-				//log.Printf("worker-%d: loaded image from %s, size: %d, content type: %s", idx, finalImgFilePath, len(finalImageData), contentType)
-				//
-				//////////////////////////////// REQUEST PRESIGNED URL
-				//// 2.3 Upload Image to R2 (POST /image/presigned)
-				//log.Printf("worker-%d: uploading image: %s", idx, product.CustomerImgUrl)
-				//
-				//presignedPayload, _ := json.Marshal(PresignedRequest{
-				//	Key: product.FulfillmentID,
-				//})
-				//
-				//presignedUrl := fmt.Sprintf("%s/image/presigned", url)
-				//
-				//reqPresigned, err := http.NewRequestWithContext(
-				//	context.Background(),
-				//	"POST",
-				//	presignedUrl,
-				//	bytes.NewBuffer(presignedPayload))
-				//
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to create presigned request: %v", idx, err)
-				//	return true
-				//}
-				//reqPresigned.Header.Set("Authorization", "Bearer "+accessToken)
-				//respPresigned, err := http.DefaultClient.Do(reqPresigned)
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to get presigned url: %v", idx, err)
-				//	return true
-				//}
-				//
-				//var presignedResp Response[PresignedResponse]
-				//if err := json.NewDecoder(respPresigned.Body).Decode(&presignedResp); err != nil {
-				//	log.Printf("worker-%d: failed to decode presigned response: %v", idx, err)
-				//	return true
-				//}
-				//
-				//respPresigned.Body.Close()
-				//
-				//log.Printf("worker-%d: presigned url: %s, download url: %s", idx, presignedResp.Data.URL, presignedResp.Data.DownloadURL)
-				//
-				//reqUpload, err := http.NewRequestWithContext(
-				//	context.Background(),
-				//	"PUT",
-				//	presignedResp.Data.URL,
-				//	bytes.NewBuffer(finalImageData))
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to create upload request: %v", idx, err)
-				//	return true
-				//}
-				//
-				//reqUpload.Header.Set("Content-Type", contentType)
-				//respUpload, err := http.DefaultClient.Do(reqUpload)
-				//if err != nil {
-				//	log.Printf("worker-%d: failed to upload image: %v", idx, err)
-				//	return true
-				//}
-				//
-				//if respUpload.StatusCode != 200 {
-				//	log.Printf("worker-%d: failed to upload image: %v", idx, respUpload.Status)
-				//	return true
-				//}
-				//respUpload.Body.Close()
-				////////////////////////////////////////////////////////////////
-
-				//2.4 Process Order Product (POST /orders/{order_id}/products/{order_product_fulfillment_id})
-				// Skip presigned
-				finalImgFilePath, _ := processedImagesMapUrl[product.CustomerImgUrl]
-
-				processOrderProductPayload, _ := json.Marshal(ProcessOrderProductRequest{
-					FinalImgUrl: finalImgFilePath,
-				})
-
-				//finalImgUrl, ok := processedImagesMap[product.CustomerImgUrl]
-				//if !ok {
-				//	log.Printf("worker-%d: failed to map", idx)
-				//	panic(1)
-				//}
-				//
-				//processOrderProductPayload, _ := json.Marshal(ProcessOrderProductRequest{
-				//	FinalImgUrl: finalImgUrl,
-				//})
-
-				log.Printf("worker-%d: processing order product: %s", idx, product.FulfillmentID)
-				processOrderProductUrl := fmt.Sprintf("%s/orders/%d/products/%s",
-					url, product.OrderID, product.FulfillmentID)
-				reqProcess, err := http.NewRequestWithContext(
-					context.Background(),
-					"POST",
-					processOrderProductUrl,
-					bytes.NewBuffer(processOrderProductPayload))
-				if err != nil {
-					log.Printf("worker-%d: failed to create process request: %v", idx, err)
-					return true
-				}
-
-				reqProcess.Header.Set("Authorization", "Bearer "+accessToken)
-				respProcess, err := http.DefaultClient.Do(reqProcess)
+			// 3. Process and upload the print file of each product
+			monitor.SetState(idx, stateProcessing)
+			for _, job := range jobs {
+				product := job.product
+				finalImgURL, err := job.printFile(ctx, idx, sess.api)
 				if err != nil {
-					log.Printf("worker-%d: failed to process order product: %v", idx, err)
-					return true
+					return failed("prepare print file", err)
 				}
 
-				if respProcess.StatusCode != 200 {
-					log.Printf("worker-%d: failed to process order product: %v", idx, respProcess.Status)
-					return true
+				// 3.1 Process Order Product (POST /orders/{order_id}/products/{order_product_fulfillment_id})
+				log.Printf("worker-%d: processing order product: %s", idx, product.FulfillmentID)
+				if err := sess.api.ProcessOrderProduct(ctx, product.OrderID, product.FulfillmentID, finalImgURL); err != nil {
+					return failed("process order product", err)
 				}
-
-				respProcess.Body.Close()
+				next++
 			}
 
-			// 3. Approve Image (POST /orders/{order_id}/designer)
-			log.Printf("worker-%d: approving image: %d", idx, orderProducts.Data[0].OrderID)
-			approveOrderUrl := fmt.Sprintf("%s/orders/%d/designer",
-				url, orderProducts.Data[0].OrderID)
-			reqApprove, err := http.NewRequestWithContext(
-				context.Background(),
-				"POST",
-				approveOrderUrl,
-				nil)
-			if err != nil {
-				log.Printf("worker-%d: failed to create approve request: %v", idx, err)
-				return true
+			// 4. Approve Image (POST /orders/{order_id}/designer)
+			monitor.SetState(idx, stateApproving)
+			log.Printf("worker-%d: approving image: %d", idx, orderProducts[0].OrderID)
+			if err := sess.api.ApproveDesigner(ctx, orderProducts[0].OrderID); err != nil {
+				return failed("approve image", err)
 			}
+			monitor.OrderDone(idx)
+			sess.fresh = false
 
-			reqApprove.Header.Set("Authorization", "Bearer "+accessToken)
-			respApprove, err := http.DefaultClient.Do(reqApprove)
-			if err != nil {
-				log.Printf("worker-%d: failed to approve image: %v", idx, err)
-				return true
-			}
-			if respApprove.StatusCode != 200 {
-				log.Printf("worker-%d: failed to approve image: %v", idx, respApprove.Status)
-				return true
-			}
-			respApprove.Body.Close()
-
-			log.Printf("worker-%d: approved order designer: %d", idx, orderProducts.Data[0].OrderID)
+			log.Printf("worker-%d: approved order designer: %d", idx, orderProducts[0].OrderID)
 			return true
 		}()
 
+		if !claimed {
+			budget.Return()
+		}
+
 		if processed {
 			log.Printf("Worker %d processed image", idx)
-			backoff = PollInterval
+			backoff = opts.PollInterval
 		} else {
 			log.Printf("Worker %d no more image to process", idx)
-			backoff = min(backoff*2, 5*time.Minute) // cap at 5 minutes
+			backoff = min(backoff*2, opts.MaxBackoff)
 		}
 
-		numProcessedOrder++
-		time.Sleep(backoff)
+		if denied {
+			finalState = stateLoginFailed
+			break
+		}
+		if retry == nil {
+			numProcessedOrder++
+		}
+		if reauth && !relogin() {
+			break
+		}
+		if retry != nil {
+			continue
+		}
+		pause := backoff
+		if throttle > 0 {
+			// Honour Retry-After instead of the regular poll interval.
+			monitor.Throttle(idx, throttle)
+			pause = throttle
+		} else if backoff > opts.PollInterval {
+			monitor.SetState(idx, stateBackingOff)
+		}
+		if !drain.sleep(ctx, pause) {
+			if ctx.Err() == nil {
+				log.Printf("worker-%d: queue is empty for all workers", idx)
+			}
+			break
+		}
 
 	}
 
+	drain.Exit(idx)
+	monitor.SetState(idx, finalState)
 	wg.Done()
 
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/printpdf"
+)
+
+// stages selects which steps of the print file pipeline run for each
+// order product. A stage that is off is replaced by its precomputed
+// counterpart:
+//
+//   - download: fetch CustomerImgUrl; off reads the image from InputDir
+//   - process: render the print file; off uses the sample's prebuilt file
+//     in PrebuiltDir
+//   - upload: send the print file to presigned URLs; off submits the
+//     sample's prebuilt file under PrebuiltURL
+type stages struct {
+	Download bool
+	Process  bool
+	Upload   bool
+}
+
+var allStages = stages{Download: true, Process: true, Upload: true}
+
+// parseStages parses a comma separated list of stage names; "all" and
+// "none" select every stage or none.
+func parseStages(list string) (stages, error) {
+	var s stages
+	for _, name := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "all":
+			s = allStages
+		case "none", "":
+		case "download":
+			s.Download = true
+		case "process":
+			s.Process = true
+		case "upload":
+			s.Upload = true
+		default:
+			return stages{}, fmt.Errorf("unknown stage %q (available: download, process, upload)", name)
+		}
+	}
+	return s, nil
+}
+
+func (s stages) String() string {
+	var names []string
+	if s.Download {
+		names = append(names, "download")
+	}
+	if s.Process {
+		names = append(names, "process")
+	}
+	if s.Upload {
+		names = append(names, "upload")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// needsInput reports whether the customer image is read at all.
+func (s stages) needsInput() bool {
+	return s.Download || s.Process
+}
+
+// printJob is the print file of one order product on its way through the
+// enabled stages. Files it creates in WorkDir are removed by cleanup
+// unless KeepFiles is set.
+type printJob struct {
+	product dtfapi.OrderProductsResponse
+	spec    sheetSpec
+	input   string
+	report  *preflightReport
+	created []string
+}
+
+// prepareOrder fetches and preflights the customer image of every product
+// before any print file is made, so an order is rejected as a whole. All
+// products are preflighted even after one fails, giving each its report;
+// the returned error then wraps errPreflight. The jobs are returned for
+// cleanup even on error.
+func prepareOrder(ctx context.Context, idx int, products []dtfapi.OrderProductsResponse) ([]*printJob, error) {
+	var jobs []*printJob
+	var rejected []string
+	for _, product := range products {
+		job, err := prepare(ctx, idx, product)
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+		if errors.Is(err, errPreflight) {
+			rejected = append(rejected, fmt.Sprintf("%s (%s)", product.FulfillmentID, strings.Join(job.report.failures(), "; ")))
+			continue
+		}
+		if err != nil {
+			return jobs, err
+		}
+	}
+	if len(rejected) > 0 {
+		return jobs, fmt.Errorf("%w in %d of %d products: %s", errPreflight, len(rejected), len(products), strings.Join(rejected, ", "))
+	}
+	return jobs, nil
+}
+
+// prepare parses the sheet of product and, when a stage reads the
+// customer image, fetches, validates and preflights it.
+func prepare(ctx context.Context, idx int, product dtfapi.OrderProductsResponse) (*printJob, error) {
+	st := opts.Stages
+	spec, err := parseSheet(product)
+	if err != nil {
+		return nil, fmt.Errorf("product %s: %w", product.FulfillmentID, err)
+	}
+	if err := os.MkdirAll(opts.WorkDir, 0o755); err != nil {
+		return nil, err
+	}
+	job := &printJob{product: product, spec: spec}
+	if !st.needsInput() {
+		return job, nil
+	}
+
+	if st.Download {
+		monitor.SetState(idx, stateDownloading)
+		job.input = filepath.Join(opts.WorkDir, product.FulfillmentID+"-input"+imageExt(product.CustomerImgUrl))
+		job.created = append(job.created, job.input)
+		if err := download(ctx, product.CustomerImgUrl, job.input); err != nil {
+			return job, fmt.Errorf("download %s: %w", product.CustomerImgUrl, err)
+		}
+	} else {
+		job.input = filepath.Join(opts.InputDir, path.Base(urlPath(product.CustomerImgUrl)))
+	}
+	if !opts.Preflight {
+		if err := validateImage(job.input); err != nil {
+			return job, fmt.Errorf("customer image %s: %w", product.CustomerImgUrl, err)
+		}
+		return job, nil
+	}
+	// Preflight makes the same checks as validateImage and more, so a
+	// corrupt image gets a report and fails like any other preflight check.
+	if job.report, err = preflight(product, spec, job.input); err != nil {
+		if errors.Is(err, errPreflight) {
+			monitor.PreflightFailed()
+		}
+		return job, fmt.Errorf("product %s: %w", product.FulfillmentID, err)
+	}
+	return job, nil
+}
+
+// printFile produces the final image URL of the job by running the
+// remaining stages.
+func (j *printJob) printFile(ctx context.Context, idx int, api *dtfapi.Client) (string, error) {
+	st := opts.Stages
+	product := j.product
+
+	var output string
+	var err error
+	if st.Process {
+		monitor.SetState(idx, stateProcessing)
+		output = filepath.Join(opts.WorkDir, product.FulfillmentID+"-print.pdf")
+		j.created = append(j.created, output)
+		if err := renderPrintFile(j.input, output, j.spec); err != nil {
+			return "", fmt.Errorf("process %s: %w", product.CustomerImgUrl, err)
+		}
+	} else if st.Upload {
+		if output, err = j.spec.prebuiltFile(); err != nil {
+			return "", fmt.Errorf("product %s: %w", product.FulfillmentID, err)
+		}
+	}
+
+	if !st.Upload {
+		finalURL, err := j.spec.prebuiltURL()
+		if err != nil {
+			return "", fmt.Errorf("product %s: %w", product.FulfillmentID, err)
+		}
+		return finalURL, nil
+	}
+
+	monitor.SetState(idx, stateUploading)
+	finalURL, err := uploadPrintFile(ctx, idx, api, product.FulfillmentID, output)
+	if err != nil {
+		return "", fmt.Errorf("upload %s: %w", output, err)
+	}
+	return finalURL, nil
+}
+
+// cleanupJobs removes the files the jobs created unless KeepFiles is set.
+func cleanupJobs(jobs []*printJob) {
+	if opts.KeepFiles {
+		return
+	}
+	for _, j := range jobs {
+		for _, f := range j.created {
+			os.Remove(f)
+		}
+	}
+}
+
+// download saves the body of url to dst, refusing anything larger than
+// MaxImageBytes.
+func download(ctx context.Context, url, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("returned status: %s", resp.Status)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, opts.MaxImageBytes+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n > opts.MaxImageBytes {
+		return fmt.Errorf("larger than %d bytes", opts.MaxImageBytes)
+	}
+	return nil
+}
+
+// validateImage checks that path holds a non-empty PNG or JPEG whose
+// header decodes.
+func validateImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if n == 0 {
+		return fmt.Errorf("empty file")
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if ct := http.DetectContentType(head[:n]); ct != "image/png" && ct != "image/jpeg" {
+		return fmt.Errorf("unsupported content type %s", ct)
+	}
+
+	cfg, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head[:n]), f))
+	if err != nil {
+		return err
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return fmt.Errorf("image has no pixels")
+	}
+	return nil
+}
+
+// renderPrintFile converts input to a print-ready PDF at output, sized
+// for the sheet and embedded at opts.DPI.
+func renderPrintFile(input, output string, spec sheetSpec) error {
+	return printpdf.ConvertFile(input, output, printpdf.Options{
+		Width:  spec.Width,
+		Height: spec.Height,
+		DPI:    opts.DPI,
+	})
+}
+
+// urlPath returns the path of raw without query or fragment.
+func urlPath(raw string) string {
+	if i := strings.IndexAny(raw, "?#"); i >= 0 {
+		raw = raw[:i]
+	}
+	return raw
+}
+
+// imageExt returns the file extension of the image at raw, defaulting to
+// .png.
+func imageExt(raw string) string {
+	if ext := path.Ext(urlPath(raw)); ext != "" && len(ext) <= 5 {
+		return ext
+	}
+	return ".png"
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"test_webhook_service/dtfapi"
+)
+
+// Preflight check results.
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// preflightCheck is one line of a preflight report.
+type preflightCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// preflightReport tells a designer whether a customer image can be printed
+// on the sheet the customer ordered. It is written as JSON for every
+// checked order product.
+type preflightReport struct {
+	OrderID       int64     `json:"order_id"`
+	FulfillmentID string    `json:"fulfillment_id"`
+	Image         string    `json:"image"`
+	CheckedAt     time.Time `json:"checked_at"`
+
+	Sheet       string  `json:"sheet"`
+	RequiredDPI int     `json:"required_dpi"`
+	Format      string  `json:"format"`
+	Width       int     `json:"width_px"`
+	Height      int     `json:"height_px"`
+	DPI         float64 `json:"dpi"`
+	MetadataDPI float64 `json:"metadata_dpi,omitempty"`
+	ColorMode   string  `json:"color_mode"`
+	Alpha       bool    `json:"alpha"`
+	FileBytes   int64   `json:"file_bytes"`
+
+	Checks []preflightCheck `json:"checks"`
+	Passed bool             `json:"passed"`
+}
+
+func (r *preflightReport) check(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, preflightCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	if status == checkFail {
+		r.Passed = false
+	}
+}
+
+// failures lists the names of the failed checks.
+func (r *preflightReport) failures() []string {
+	var names []string
+	for _, c := range r.Checks {
+		if c.Status == checkFail {
+			names = append(names, c.Name+": "+c.Detail)
+		}
+	}
+	return names
+}
+
+// errPreflight marks a product whose image failed preflight.
+var errPreflight = errors.New("preflight failed")
+
+// preflight checks the customer image at path against spec, writes the
+// report to PreflightDir and returns an error wrapping errPreflight when
+// a check failed.
+func preflight(product dtfapi.OrderProductsResponse, spec sheetSpec, path string) (*preflightReport, error) {
+	r := &preflightReport{
+		OrderID:       product.OrderID,
+		FulfillmentID: product.FulfillmentID,
+		Image:         product.CustomerImgUrl,
+		CheckedAt:     time.Now(),
+		Sheet:         spec.size(),
+		RequiredDPI:   opts.PreflightDPI,
+		Passed:        true,
+	}
+	inspectImage(r, spec, path)
+
+	if err := writePreflight(r); err != nil {
+		return r, err
+	}
+	if !r.Passed {
+		return r, fmt.Errorf("%w: %v", errPreflight, r.failures())
+	}
+	return r, nil
+}
+
+func inspectImage(r *preflightReport, spec sheetSpec, path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		r.check("file", checkFail, "%v", err)
+		return
+	}
+	r.FileBytes = fi.Size()
+	switch {
+	case fi.Size() == 0:
+		r.check("file size", checkFail, "empty file")
+		return
+	case fi.Size() > opts.MaxImageBytes:
+		r.check("file size", checkFail, "%d bytes is over the %d byte limit", fi.Size(), opts.MaxImageBytes)
+	default:
+		r.check("file size", checkPass, "%d bytes", fi.Size())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		r.check("file", checkFail, "%v", err)
+		return
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if ct := http.DetectContentType(head[:n]); ct != "image/png" && ct != "image/jpeg" {
+		r.check("format", checkFail, "%s, customer images must be PNG or JPEG", ct)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		r.check("file", checkFail, "%v", err)
+		return
+	}
+
+	// Only the header is decoded. For PNG the chunk walk below checks
+	// every CRC and inflates the image data into io.Discard, so a corrupt
+	// file is caught without holding the pixels in memory.
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(f))
+	r.Format = format
+	if err != nil {
+		r.check("integrity", checkFail, "image header does not decode: %v", err)
+		return
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		r.check("integrity", checkFail, "image has no pixels")
+		return
+	}
+
+	meta := pngMeta{ColorType: -1}
+	if format == "png" {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			meta, err = readPNGMeta(f)
+		}
+		if err != nil {
+			r.check("integrity", checkFail, "%v", err)
+		} else {
+			r.check("integrity", checkPass, "png chunks and image data are intact")
+		}
+	} else {
+		r.check("integrity", checkPass, "%s header decodes, image data not checked", format)
+	}
+
+	r.Width, r.Height = cfg.Width, cfg.Height
+	checkDimensions(r, spec)
+
+	r.ColorMode = colorMode(cfg.ColorModel, meta)
+	switch r.ColorMode {
+	case "rgb", "rgba", "indexed":
+		r.check("color mode", checkPass, "%s", r.ColorMode)
+	default:
+		r.check("color mode", checkFail, "%s, print files must be RGB", r.ColorMode)
+	}
+
+	r.Alpha = hasAlpha(cfg.ColorModel, meta)
+	if r.Alpha {
+		r.check("alpha", checkPass, "image has an alpha channel")
+	} else {
+		r.check("alpha", checkFail, "no alpha channel, the background would be printed")
+	}
+
+	switch {
+	case meta.DPI == 0:
+		r.check("dpi metadata", checkWarn, "no resolution stored in the file")
+	case math.Abs(meta.DPI-float64(opts.PreflightDPI)) > 1:
+		r.MetadataDPI = meta.DPI
+		r.check("dpi metadata", checkWarn, "file says %.0f DPI, the sheet needs %d", meta.DPI, opts.PreflightDPI)
+	default:
+		r.MetadataDPI = meta.DPI
+		r.check("dpi metadata", checkPass, "%.0f DPI", meta.DPI)
+	}
+}
+
+// checkDimensions compares the pixel size with the sheet at the required
+// DPI. The aspect ratio must match within 1% and the resolution must
+// reach the requirement in both directions.
+func checkDimensions(r *preflightReport, spec sheetSpec) {
+	dpiX := float64(r.Width) / spec.Width
+	dpiY := float64(r.Height) / spec.Height
+	r.DPI = math.Round(math.Min(dpiX, dpiY)*10) / 10
+
+	wantW := int(math.Round(spec.Width * float64(opts.PreflightDPI)))
+	wantH := int(math.Round(spec.Height * float64(opts.PreflightDPI)))
+	switch {
+	case math.Abs(dpiX-dpiY)/math.Max(dpiX, dpiY) > 0.01:
+		r.check("dimensions", checkFail, "%dx%d px does not have the %s aspect ratio", r.Width, r.Height, spec.size())
+	case r.DPI+0.5 < float64(opts.PreflightDPI):
+		r.check("dimensions", checkFail, "%dx%d px is %.0f DPI on %s, needs %dx%d px", r.Width, r.Height, r.DPI, spec.size(), wantW, wantH)
+	default:
+		r.check("dimensions", checkPass, "%dx%d px, %.0f DPI on %s", r.Width, r.Height, r.DPI, spec.size())
+	}
+}
+
+func colorMode(model color.Model, meta pngMeta) string {
+	if meta.ColorType >= 0 {
+		switch meta.ColorType {
+		case 0:
+			return "gray"
+		case 2:
+			return "rgb"
+		case 3:
+			return "indexed"
+		case 4:
+			return "gray+alpha"
+		case 6:
+			return "rgba"
+		}
+	}
+	switch model {
+	case color.GrayModel, color.Gray16Model:
+		return "gray"
+	case color.CMYKModel:
+		return "cmyk"
+	case color.YCbCrModel:
+		return "rgb"
+	case color.NRGBAModel, color.NRGBA64Model, color.RGBAModel, color.RGBA64Model:
+		return "rgba"
+	}
+	if _, ok := model.(color.Palette); ok {
+		return "indexed"
+	}
+	return "unknown"
+}
+
+func hasAlpha(model color.Model, meta pngMeta) bool {
+	if meta.ColorType == 4 || meta.ColorType == 6 || meta.Transparency {
+		return true
+	}
+	if meta.ColorType >= 0 {
+		return false
+	}
+	switch model {
+	case color.NRGBAModel, color.NRGBA64Model, color.RGBAModel, color.RGBA64Model:
+		return true
+	}
+	return false
+}
+
+// pngMeta is what preflight reads from PNG chunks.
+type pngMeta struct {
+	ColorType     int // -1 when not a PNG
+	Width, Height int
+	Depth         int
+	Interlaced    bool
+	Transparency  bool
+	DPI           float64
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChannels is the number of samples per pixel of each PNG color type.
+var pngChannels = map[int]int64{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}
+
+// readPNGMeta walks the chunks of a PNG, checking each chunk's CRC,
+// including ancillary chunks the image decoder skips. The image data is
+// inflated on the way through and must hold exactly the scanlines the
+// IHDR chunk describes.
+func readPNGMeta(r io.Reader) (pngMeta, error) {
+	meta := pngMeta{ColorType: -1}
+	br := bufio.NewReader(r)
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, sig); err != nil || !bytes.Equal(sig, pngSignature) {
+		return meta, fmt.Errorf("not a PNG file")
+	}
+
+	var header [8]byte
+	pending, sawIDAT := false, false
+	for {
+		if !pending {
+			if _, err := io.ReadFull(br, header[:]); err != nil {
+				return meta, fmt.Errorf("truncated before IEND: %v", err)
+			}
+		}
+		pending = false
+		length := binary.BigEndian.Uint32(header[:4])
+		kind := string(header[4:8])
+
+		if kind == "IDAT" {
+			switch {
+			case meta.ColorType < 0:
+				return meta, fmt.Errorf("IDAT chunk before IHDR")
+			case sawIDAT:
+				return meta, fmt.Errorf("IDAT chunks are not consecutive")
+			}
+			sawIDAT = true
+			next, err := checkImageData(br, header, meta)
+			if err != nil {
+				return meta, err
+			}
+			header, pending = next, true
+			continue
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:8])
+		var data []byte
+		if kind == "IHDR" || kind == "pHYs" {
+			data = make([]byte, length)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return meta, fmt.Errorf("truncated %s chunk", kind)
+			}
+			crc.Write(data)
+		} else if _, err := io.CopyN(crc, br, int64(length)); err != nil {
+			return meta, fmt.Errorf("truncated %s chunk", kind)
+		}
+
+		var sum [4]byte
+		if _, err := io.ReadFull(br, sum[:]); err != nil {
+			return meta, fmt.Errorf("truncated %s chunk", kind)
+		}
+		if binary.BigEndian.Uint32(sum[:]) != crc.Sum32() {
+			return meta, fmt.Errorf("%s chunk checksum mismatch", kind)
+		}
+
+		switch kind {
+		case "IHDR":
+			if len(data) != 13 {
+				return meta, fmt.Errorf("IHDR chunk is %d bytes, want 13", len(data))
+			}
+			meta.Width = int(binary.BigEndian.Uint32(data[0:4]))
+			meta.Height = int(binary.BigEndian.Uint32(data[4:8]))
+			meta.Depth = int(data[8])
+			meta.ColorType = int(data[9])
+			meta.Interlaced = data[12] == 1
+			if _, ok := pngChannels[meta.ColorType]; !ok {
+				return meta, fmt.Errorf("unknown color type %d", meta.ColorType)
+			}
+		case "tRNS":
+			meta.Transparency = true
+		case "pHYs":
+			// Pixels per unit on each axis; unit 1 is the metre.
+			if len(data) == 9 && data[8] == 1 {
+				meta.DPI = math.Round(float64(binary.BigEndian.Uint32(data[:4])) * 0.0254)
+			}
+		case "IEND":
+			if !sawIDAT {
+				return meta, fmt.Errorf("no IDAT chunk")
+			}
+			return meta, nil
+		}
+	}
+}
+
+// checkImageData inflates the run of IDAT chunks starting with header
+// into io.Discard, checking each chunk's CRC, the zlib checksum and the
+// number of scanline bytes. It returns the header of the chunk after the
+// run.
+func checkImageData(br *bufio.Reader, header [8]byte, meta pngMeta) ([8]byte, error) {
+	idat := &idatReader{r: br, header: header}
+	idat.start()
+	zr, err := zlib.NewReader(idat)
+	if err != nil {
+		return header, fmt.Errorf("image data: %v", err)
+	}
+	n, err := io.Copy(io.Discard, zr)
+	if err != nil {
+		return header, fmt.Errorf("image data: %v", err)
+	}
+	if want := scanlineBytes(meta); n != want {
+		return header, fmt.Errorf("image data inflates to %d bytes, %dx%d needs %d", n, meta.Width, meta.Height, want)
+	}
+	// Skip whatever follows the zlib stream in the last chunk.
+	if _, err := io.Copy(io.Discard, idat); err != nil {
+		return header, fmt.Errorf("image data: %v", err)
+	}
+	return idat.header, nil
+}
+
+// idatReader reads the data of consecutive IDAT chunks, checking the CRC
+// at the end of each. It stops at the first other chunk, leaving its
+// header in header.
+type idatReader struct {
+	r         *bufio.Reader
+	header    [8]byte
+	remaining uint32
+	crc       hash.Hash32
+	done      bool
+}
+
+func (d *idatReader) start() {
+	d.remaining = binary.BigEndian.Uint32(d.header[:4])
+	d.crc = crc32.NewIEEE()
+	d.crc.Write(d.header[4:8])
+}
+
+func (d *idatReader) Read(p []byte) (int, error) {
+	for d.remaining == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.endChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if uint32(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.r.Read(p)
+	d.crc.Write(p[:n])
+	d.remaining -= uint32(n)
+	if err == io.EOF {
+		err = errors.New("truncated IDAT chunk")
+	}
+	return n, err
+}
+
+// endChunk checks the CRC of the current chunk and reads the header of
+// the next one.
+func (d *idatReader) endChunk() error {
+	var sum [4]byte
+	if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+		return errors.New("truncated IDAT chunk")
+	}
+	if binary.BigEndian.Uint32(sum[:]) != d.crc.Sum32() {
+		return errors.New("IDAT chunk checksum mismatch")
+	}
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return fmt.Errorf("truncated before IEND: %v", err)
+	}
+	if string(d.header[4:8]) != "IDAT" {
+		d.done = true
+		return nil
+	}
+	d.start()
+	return nil
+}
+
+// adam7 holds the x and y offsets and steps of the seven interlace
+// passes.
+var adam7 = [7][4]int64{
+	{0, 0, 8, 8}, {4, 0, 8, 8}, {0, 4, 4, 8}, {2, 0, 4, 4},
+	{0, 2, 2, 4}, {1, 0, 2, 2}, {0, 1, 1, 2},
+}
+
+// scanlineBytes is the size of the filtered scanlines of the image meta
+// describes, one filter byte per row, summed over the passes when it is
+// interlaced.
+func scanlineBytes(meta pngMeta) int64 {
+	bits := int64(meta.Depth) * pngChannels[meta.ColorType]
+	size := func(w, h int64) int64 {
+		if w == 0 || h == 0 {
+			return 0
+		}
+		return h * (1 + (w*bits+7)/8)
+	}
+	w, h := int64(meta.Width), int64(meta.Height)
+	if !meta.Interlaced {
+		return size(w, h)
+	}
+	var n int64
+	for _, p := range adam7 {
+		n += size((w-p[0]+p[2]-1)/p[2], (h-p[1]+p[3]-1)/p[3])
+	}
+	return n
+}
+
+// writePreflight saves r as <order>-<fulfillment>.json in PreflightDir.
+func writePreflight(r *preflightReport) error {
+	if err := os.MkdirAll(opts.PreflightDir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.json", r.OrderID, r.FulfillmentID)
+	return os.WriteFile(filepath.Join(opts.PreflightDir, name), append(data, '\n'), 0o644)
+}
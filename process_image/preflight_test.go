@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"test_webhook_service/config"
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/dtfmock"
+)
+
+// pngChunk encodes one chunk with its length and CRC.
+func pngChunk(kind string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(kind)
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+	return b.Bytes()
+}
+
+func ihdr(w, h uint32, depth, colorType, interlace byte) []byte {
+	data := make([]byte, 13)
+	binary.BigEndian.PutUint32(data[0:], w)
+	binary.BigEndian.PutUint32(data[4:], h)
+	data[8], data[9], data[12] = depth, colorType, interlace
+	return pngChunk("IHDR", data)
+}
+
+func deflate(raw []byte) []byte {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write(raw)
+	zw.Close()
+	return b.Bytes()
+}
+
+// buildPNG joins the signature and chunks.
+func buildPNG(chunks ...[]byte) []byte {
+	return append(append([]byte{}, pngSignature...), bytes.Join(chunks, nil)...)
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestReadPNGMeta(t *testing.T) {
+	phys := make([]byte, 9)
+	binary.BigEndian.PutUint32(phys[0:], 11811) // 300 DPI in pixels per metre
+	binary.BigEndian.PutUint32(phys[4:], 11811)
+	phys[8] = 1
+
+	// A 3x3 RGB image has 30 bytes of scanlines, or 33 when interlaced.
+	rows := deflate(make([]byte, 30))
+	passes := deflate(make([]byte, 33))
+	iend := pngChunk("IEND", nil)
+
+	badCRC := buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", rows), iend)
+	badCRC[len(pngSignature)+25+8+len(rows)] ^= 0xff // the IDAT CRC
+
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 2), color.Palette{color.NRGBA{0, 0, 0, 0}, color.NRGBA{255, 0, 0, 255}})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    pngMeta
+		wantErr string
+	}{
+		{
+			name: "rgb with pHYs",
+			data: buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("pHYs", phys), pngChunk("IDAT", rows), iend),
+			want: pngMeta{ColorType: 2, Width: 3, Height: 3, Depth: 8, DPI: 300},
+		},
+		{
+			name: "image data split over chunks",
+			data: buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", rows[:5]), pngChunk("IDAT", nil), pngChunk("IDAT", rows[5:]), iend),
+			want: pngMeta{ColorType: 2, Width: 3, Height: 3, Depth: 8},
+		},
+		{
+			name: "interlaced",
+			data: buildPNG(ihdr(3, 3, 8, 2, 1), pngChunk("IDAT", passes), iend),
+			want: pngMeta{ColorType: 2, Width: 3, Height: 3, Depth: 8, Interlaced: true},
+		},
+		{
+			name: "encoded rgba",
+			data: encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 7, 5))),
+			want: pngMeta{ColorType: 6, Width: 7, Height: 5, Depth: 8},
+		},
+		{
+			name: "encoded gray16",
+			data: encodePNG(t, image.NewGray16(image.Rect(0, 0, 9, 3))),
+			want: pngMeta{ColorType: 0, Width: 9, Height: 3, Depth: 16},
+		},
+		{
+			name: "encoded palette with transparency",
+			data: encodePNG(t, paletted),
+			want: pngMeta{ColorType: 3, Width: 4, Height: 2, Depth: 1, Transparency: true},
+		},
+		{
+			name:    "not a PNG",
+			data:    []byte("GIF89a"),
+			wantErr: "not a PNG file",
+		},
+		{
+			name:    "IDAT checksum",
+			data:    badCRC,
+			wantErr: "IDAT chunk checksum mismatch",
+		},
+		{
+			name:    "IHDR checksum",
+			data:    append(buildPNG(ihdr(3, 3, 8, 2, 0))[:len(pngSignature)+20], 0, 0, 0, 0, 0),
+			wantErr: "IHDR chunk checksum mismatch",
+		},
+		{
+			name:    "missing scanlines",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", deflate(make([]byte, 20))), iend),
+			wantErr: "image data inflates to 20 bytes, 3x3 needs 30",
+		},
+		{
+			name:    "interlaced size without passes",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 1), pngChunk("IDAT", rows), iend),
+			wantErr: "needs 33",
+		},
+		{
+			name:    "corrupt zlib stream",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", append(rows[:len(rows)-4:len(rows)-4], 1, 2, 3, 4)), iend),
+			wantErr: "image data: zlib: invalid checksum",
+		},
+		{
+			name:    "truncated image data",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", rows))[:len(pngSignature)+25+12],
+			wantErr: "truncated IDAT chunk",
+		},
+		{
+			name:    "split image data",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", rows[:5]), pngChunk("tEXt", []byte("a\x00b")), pngChunk("IDAT", rows[5:]), iend),
+			wantErr: "image data",
+		},
+		{
+			name:    "no image data",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 0), iend),
+			wantErr: "no IDAT chunk",
+		},
+		{
+			name:    "IDAT before IHDR",
+			data:    buildPNG(pngChunk("IDAT", rows), ihdr(3, 3, 8, 2, 0), iend),
+			wantErr: "IDAT chunk before IHDR",
+		},
+		{
+			name:    "no IEND",
+			data:    buildPNG(ihdr(3, 3, 8, 2, 0), pngChunk("IDAT", rows)),
+			wantErr: "truncated before IEND",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPNGMeta(bytes.NewReader(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readPNGMeta() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPNGMeta() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("readPNGMeta() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanlineBytes(t *testing.T) {
+	tests := []struct {
+		meta pngMeta
+		want int64
+	}{
+		{pngMeta{ColorType: 6, Width: 10, Height: 2, Depth: 8}, 2 * (1 + 40)},
+		{pngMeta{ColorType: 2, Width: 10, Height: 2, Depth: 16}, 2 * (1 + 60)},
+		{pngMeta{ColorType: 3, Width: 10, Height: 2, Depth: 1}, 2 * (1 + 2)},
+		{pngMeta{ColorType: 0, Width: 3, Height: 1, Depth: 4}, 1 + 2},
+		{pngMeta{ColorType: 0, Width: 1, Height: 1, Depth: 8, Interlaced: true}, 2},
+		{pngMeta{ColorType: 0, Width: 8, Height: 8, Depth: 8, Interlaced: true}, 8*8 + 15},
+	}
+	for _, tt := range tests {
+		if got := scanlineBytes(tt.meta); got != tt.want {
+			t.Errorf("scanlineBytes(%+v) = %d, want %d", tt.meta, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDimensions(t *testing.T) {
+	saved := opts.PreflightDPI
+	t.Cleanup(func() { opts.PreflightDPI = saved })
+	opts.PreflightDPI = 300
+
+	spec := sheetSpec{Width: 22, Height: 10}
+	tests := []struct {
+		name          string
+		width, height int
+		wantStatus    string
+		wantDPI       float64
+		wantDetail    string
+	}{
+		{name: "exact", width: 6600, height: 3000, wantStatus: checkPass, wantDPI: 300, wantDetail: "300 DPI on 22x10"},
+		{name: "higher resolution", width: 13200, height: 6000, wantStatus: checkPass, wantDPI: 600},
+		{name: "rounds up half a DPI", width: 6590, height: 2996, wantStatus: checkPass, wantDPI: 299.5},
+		{name: "low resolution", width: 3300, height: 1500, wantStatus: checkFail, wantDPI: 150, wantDetail: "needs 6600x3000 px"},
+		{name: "aspect ratio", width: 6600, height: 3300, wantStatus: checkFail, wantDPI: 300, wantDetail: "does not have the 22x10 aspect ratio"},
+		{name: "within 1% aspect", width: 6600, height: 3025, wantStatus: checkPass, wantDPI: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &preflightReport{Width: tt.width, Height: tt.height, Passed: true}
+			checkDimensions(r, spec)
+			if len(r.Checks) != 1 {
+				t.Fatalf("got %d checks, want 1", len(r.Checks))
+			}
+			c := r.Checks[0]
+			if c.Status != tt.wantStatus || !strings.Contains(c.Detail, tt.wantDetail) {
+				t.Errorf("check = %s %q, want %s containing %q", c.Status, c.Detail, tt.wantStatus, tt.wantDetail)
+			}
+			if r.DPI != tt.wantDPI {
+				t.Errorf("DPI = %v, want %v", r.DPI, tt.wantDPI)
+			}
+			if r.Passed != (tt.wantStatus == checkPass) {
+				t.Errorf("Passed = %v with status %s", r.Passed, c.Status)
+			}
+		})
+	}
+}
+
+// countProducts counts product POSTs.
+type countProducts struct {
+	http.Handler
+	calls int64
+}
+
+func (h *countProducts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/products/") {
+		atomic.AddInt64(&h.calls, 1)
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func TestPreflight(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts.PreflightDPI = 10
+	opts.PreflightDir = t.TempDir()
+	opts.MaxImageBytes = 1 << 20
+
+	good := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	tests := []struct {
+		name      string
+		data      []byte
+		wantCheck string // the failing check, "" when the image passes
+	}{
+		{name: "good", data: good},
+		{name: "empty", data: nil, wantCheck: "file size"},
+		{name: "not an image", data: []byte("<html>not found</html>"), wantCheck: "format"},
+		{name: "truncated", data: good[:len(good)-20], wantCheck: "integrity"},
+		{name: "header only", data: good[:33], wantCheck: "integrity"},
+		{name: "gray", data: encodePNG(t, image.NewGray(image.Rect(0, 0, 10, 10))), wantCheck: "color mode"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "input.png")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			product := dtfapi.OrderProductsResponse{OrderID: 1, FulfillmentID: fmt.Sprintf("F1-%d", i)}
+			r, err := preflight(product, sheetSpec{Width: 1, Height: 1}, path)
+			if tt.wantCheck == "" {
+				if err != nil {
+					t.Fatalf("preflight() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errPreflight) {
+				t.Fatalf("preflight() error = %v, want errPreflight", err)
+			}
+			if failed := r.failures(); len(failed) == 0 || !strings.HasPrefix(failed[0], tt.wantCheck+":") {
+				t.Errorf("failed checks %q, want %s first", failed, tt.wantCheck)
+			}
+			if _, err := os.Stat(filepath.Join(opts.PreflightDir, fmt.Sprintf("1-%s.json", product.FulfillmentID))); err != nil {
+				t.Errorf("no report written: %v", err)
+			}
+		})
+	}
+}
+
+func TestWorkerRejectsOrderOnPreflight(t *testing.T) {
+	good := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "ABC-1"):
+			w.Write(good[:len(good)-20])
+		case strings.Contains(r.URL.Path, "ABC-2"):
+			w.Write(encodePNG(t, image.NewGray(image.Rect(0, 0, 10, 10))))
+		case strings.Contains(r.URL.Path, "ABC-3"):
+			w.Write([]byte("<html>not found</html>"))
+		default:
+			w.Write(good)
+		}
+	}))
+	t.Cleanup(images.Close)
+
+	mock := dtfmock.New(dtfmock.Config{Seed: 1})
+	mock.AddOrder(images.URL+"/ABC-1-1x1.png", images.URL+"/ABC-2-1x1.png",
+		images.URL+"/ABC-3-1x1.png", images.URL+"/ABC-4-1x1.png")
+	counter := &countProducts{Handler: mock}
+	useMock(t, counter, 1, 1)
+	opts.Stages = stages{Download: true}
+	opts.Preflight = true
+	opts.PreflightDPI = 10
+	opts.PreflightDir = t.TempDir()
+	opts.MaxImageBytes = 1 << 20
+
+	runWorker(config.Account{UserName: "designer1", Password: "designer"})
+
+	if calls := atomic.LoadInt64(&counter.calls); calls != 0 {
+		t.Errorf("worker submitted %d products of an order that failed preflight", calls)
+	}
+	if stats := mock.Stats(); stats.Approved != 0 {
+		t.Errorf("worker approved %d orders, want 0", stats.Approved)
+	}
+	if n := monitor.PreflightFailures(); n != 3 {
+		t.Errorf("PreflightFailures() = %d, want 3", n)
+	}
+	reports, err := os.ReadDir(opts.PreflightDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 4 {
+		t.Errorf("wrote %d preflight reports, want one per product", len(reports))
+	}
+	if files, _ := os.ReadDir(opts.WorkDir); len(files) != 0 {
+		t.Errorf("left %d files in WorkDir", len(files))
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runOptions are the process_image run parameters. They come from flags,
+// falling back to the selected environment and then to these defaults.
+type runOptions struct {
+	Workers int
+	// OrdersPerWorker bounds the loop iterations of each worker when no
+	// shared TotalOrders budget is set.
+	OrdersPerWorker int
+	// TotalOrders is the number of orders all workers take from the queue
+	// together; 0 uses OrdersPerWorker instead.
+	TotalOrders int
+
+	PollInterval time.Duration
+	MaxBackoff   time.Duration
+
+	// UntilEmpty stops every worker once all of them have found
+	// /orders/next empty.
+	UntilEmpty bool
+
+	// Stages are the print file pipeline steps to run, see stages.
+	Stages stages
+	// WorkDir holds downloaded images and rendered print files.
+	WorkDir string
+	// InputDir holds customer images when the download stage is off;
+	// missing sample images are generated there, see prepareSamples.
+	InputDir string
+	// PrebuiltDir and PrebuiltURL hold the prebuilt sample print files
+	// used when the process or upload stage is off.
+	PrebuiltDir string
+	PrebuiltURL string
+	// KeepFiles leaves the files in WorkDir after each product.
+	KeepFiles     bool
+	MaxImageBytes int64
+	// Preflight checks every customer image before its print file is
+	// made and writes a report per product to PreflightDir; when any
+	// product fails, no product of its order is submitted.
+	Preflight    bool
+	PreflightDPI int
+	PreflightDir string
+	// UploadAttempts bounds the tries of each presigned request and PUT.
+	UploadAttempts int
+	// Files over MultipartThreshold bytes are uploaded in PartSize parts,
+	// UploadConcurrency at a time.
+	MultipartThreshold int64
+	PartSize           int64
+	UploadConcurrency  int
+	// UploadChecksums sends Content-MD5 and x-amz-checksum-sha256 with
+	// every PUT. It is off by default: storage that did not sign the
+	// headers into the presigned URL may refuse them.
+	UploadChecksums bool
+	// DPI is the resolution print files are rendered at; 0 keeps the
+	// customer image's own pixels.
+	DPI int
+}
+
+var opts = runOptions{
+	Workers:            20,
+	OrdersPerWorker:    500,
+	PollInterval:       1 * time.Second,
+	MaxBackoff:         5 * time.Minute,
+	Stages:             allStages,
+	InputDir:           "sample-images",
+	PrebuiltDir:        "pdf",
+	PrebuiltURL:        "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples",
+	MaxImageBytes:      512 << 20,
+	UploadAttempts:     5,
+	MultipartThreshold: 64 << 20,
+	PartSize:           16 << 20,
+	UploadConcurrency:  4,
+	Preflight:          true,
+	PreflightDPI:       300,
+	PreflightDir:       "preflight",
+}
+
+// budget and drain are shared by all workers; both are nil when the
+// corresponding option is off.
+var (
+	budget *orderBudget
+	drain  *queueDrain
+)
+
+// orderBudget is the TotalOrders budget shared by all workers. A nil
+// budget is unlimited.
+type orderBudget struct {
+	remaining int64
+}
+
+func newOrderBudget(total int) *orderBudget {
+	if total <= 0 {
+		return nil
+	}
+	return &orderBudget{remaining: int64(total)}
+}
+
+// Take reserves one order before polling. It returns false once the
+// budget is spent.
+func (b *orderBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	if atomic.AddInt64(&b.remaining, -1) >= 0 {
+		return true
+	}
+	atomic.AddInt64(&b.remaining, 1)
+	return false
+}
+
+// Return gives back a reservation that did not get an order.
+func (b *orderBudget) Return() {
+	if b != nil {
+		atomic.AddInt64(&b.remaining, 1)
+	}
+}
+
+// queueDrain detects that the queue is empty for everyone: it is done once
+// every worker's latest poll came back empty or the worker has exited. A
+// nil queueDrain is never done.
+type queueDrain struct {
+	mu      sync.Mutex
+	workers int
+	idle    map[int]bool
+	exited  map[int]bool
+	done    chan struct{}
+}
+
+func newQueueDrain(workers int) *queueDrain {
+	return &queueDrain{
+		workers: workers,
+		idle:    make(map[int]bool),
+		exited:  make(map[int]bool),
+		done:    make(chan struct{}),
+	}
+}
+
+// Empty records that worker idx found no order.
+func (d *queueDrain) Empty(idx int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.idle[idx] = true
+	d.check()
+}
+
+// Busy records that worker idx got an order.
+func (d *queueDrain) Busy(idx int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.exited[idx] {
+		delete(d.idle, idx)
+	}
+}
+
+// Exit records that worker idx stopped for another reason.
+func (d *queueDrain) Exit(idx int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.exited[idx] = true
+	d.idle[idx] = true
+	d.check()
+}
+
+// Done is closed once the queue is drained.
+func (d *queueDrain) Done() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.done
+}
+
+// check closes done when every worker is idle. Callers hold d.mu.
+func (d *queueDrain) check() {
+	if len(d.idle) < d.workers {
+		return
+	}
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+}
+
+// sleep waits for t, until the queue is drained or until ctx is done,
+// reporting whether the full time elapsed.
+func (d *queueDrain) sleep(ctx context.Context, t time.Duration) bool {
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-d.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
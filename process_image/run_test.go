@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderBudget(t *testing.T) {
+	b := newOrderBudget(3)
+	for i := 0; i < 3; i++ {
+		if !b.Take() {
+			t.Fatalf("Take %d = false, want true", i+1)
+		}
+	}
+	if b.Take() {
+		t.Fatal("Take after the budget was spent = true")
+	}
+
+	b.Return()
+	if !b.Take() {
+		t.Fatal("Take after Return = false, want true")
+	}
+	if b.Take() {
+		t.Fatal("Take after the returned order was used = true")
+	}
+}
+
+func TestOrderBudgetUnlimited(t *testing.T) {
+	for _, total := range []int{0, -1} {
+		b := newOrderBudget(total)
+		if b != nil {
+			t.Fatalf("newOrderBudget(%d) = %+v, want nil", total, b)
+		}
+		for i := 0; i < 100; i++ {
+			if !b.Take() {
+				t.Fatalf("nil budget Take %d = false", i+1)
+			}
+		}
+		b.Return()
+	}
+}
+
+func TestOrderBudgetConcurrent(t *testing.T) {
+	const total, workers = 50, 16
+	b := newOrderBudget(total)
+
+	var taken int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if b.Take() {
+					atomic.AddInt64(&taken, 1)
+				}
+				// A failed poll hands its reservation back half the time.
+				if i%2 == 0 && b.Take() {
+					b.Return()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if taken != total {
+		t.Errorf("workers took %d orders, want %d", taken, total)
+	}
+	if b.remaining != 0 {
+		t.Errorf("remaining = %d after the budget was spent, want 0", b.remaining)
+	}
+}
+
+func TestQueueDrain(t *testing.T) {
+	type event struct {
+		op  string // "empty", "busy" or "exit"
+		idx int
+	}
+	tests := []struct {
+		name     string
+		workers  int
+		events   []event
+		wantDone bool
+	}{
+		{"no polls yet", 2, nil, false},
+		{"one worker empty", 2, []event{{"empty", 0}}, false},
+		{"all empty", 2, []event{{"empty", 0}, {"empty", 1}}, true},
+		{"same worker empty twice", 2, []event{{"empty", 0}, {"empty", 0}}, false},
+		{"busy again before the last poll", 2, []event{{"empty", 0}, {"busy", 0}, {"empty", 1}}, false},
+		{"empty after busy", 2, []event{{"empty", 0}, {"busy", 0}, {"empty", 1}, {"empty", 0}}, true},
+		{"exited worker counts as idle", 2, []event{{"exit", 0}, {"empty", 1}}, true},
+		{"exited worker stays idle", 2, []event{{"exit", 0}, {"busy", 0}, {"empty", 1}}, true},
+		{"all exited", 3, []event{{"exit", 0}, {"exit", 1}, {"exit", 2}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newQueueDrain(tt.workers)
+			for _, e := range tt.events {
+				switch e.op {
+				case "empty":
+					d.Empty(e.idx)
+				case "busy":
+					d.Busy(e.idx)
+				case "exit":
+					d.Exit(e.idx)
+				}
+			}
+			select {
+			case <-d.Done():
+				if !tt.wantDone {
+					t.Error("queue reported drained")
+				}
+			default:
+				if tt.wantDone {
+					t.Error("queue not reported drained")
+				}
+			}
+		})
+	}
+}
+
+func TestQueueDrainDoneOnce(t *testing.T) {
+	d := newQueueDrain(1)
+	d.Empty(0)
+	// Further reports after the queue is drained must not close done again.
+	d.Empty(0)
+	d.Exit(0)
+	<-d.Done()
+}
+
+func TestQueueDrainNil(t *testing.T) {
+	var d *queueDrain
+	d.Empty(0)
+	d.Busy(0)
+	d.Exit(0)
+	if d.Done() != nil {
+		t.Error("nil queueDrain Done is not nil")
+	}
+	if !d.sleep(context.Background(), time.Millisecond) {
+		t.Error("nil queueDrain sleep was cut short")
+	}
+}
+
+func TestQueueDrainSleep(t *testing.T) {
+	d := newQueueDrain(1)
+	if !d.sleep(context.Background(), time.Millisecond) {
+		t.Error("sleep was cut short before the queue drained")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if d.sleep(ctx, time.Minute) {
+		t.Error("sleep ran to the end with a cancelled context")
+	}
+
+	go d.Empty(0)
+	start := time.Now()
+	if d.sleep(context.Background(), time.Minute) {
+		t.Error("sleep ran to the end after the queue drained")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sleep returned %v after the queue drained", elapsed)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"test_webhook_service/samples"
+)
+
+// sampleDPI is the resolution of the customer images prepareSamples
+// writes; print files rendered from them match the prebuilt ones.
+const sampleDPI = 300
+
+// prepareSamples fills in the local files the skipped stages read in
+// place of their work, for every sheet send_webhook orders: the customer
+// images in InputDir when the download stage is off and the print files
+// in PrebuiltDir when the process stage is off but upload is on. Files
+// already there are kept, so only the first run pays for generating them.
+func prepareSamples(st stages) error {
+	needInputs := st.Process && !st.Download
+	needPrebuilt := st.Upload && !st.Process
+	if !needInputs && !needPrebuilt {
+		return nil
+	}
+
+	// The customer images are only needed to render the print files;
+	// without an InputDir to keep them in they go to a temporary dir.
+	inputDir := opts.InputDir
+	if !needInputs {
+		dir, err := os.MkdirTemp("", "process_image-samples-")
+		if err != nil {
+			return fmt.Errorf("sample images: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		inputDir = dir
+	}
+
+	for _, sheet := range samples.Sheets {
+		input := filepath.Join(inputDir, sheet.ImageName())
+		if needInputs {
+			if err := writeMissingSample(input, sheet); err != nil {
+				return err
+			}
+		}
+		if !needPrebuilt {
+			continue
+		}
+		spec := sheetSpec{Sample: sheet.ID, Width: samples.Width, Height: float64(sheet.Height)}
+		output, _ := spec.prebuiltFile()
+		if exists, err := fileExists(output); err != nil || exists {
+			if err != nil {
+				return fmt.Errorf("prebuilt print file: %w", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(opts.PrebuiltDir, 0o755); err != nil {
+			return fmt.Errorf("prebuilt print files: %w", err)
+		}
+		if err := writeMissingSample(input, sheet); err != nil {
+			return err
+		}
+		log.Printf("Rendering missing prebuilt print file %s", output)
+		if err := renderPrintFile(input, output+".tmp", spec); err != nil {
+			os.Remove(output + ".tmp")
+			return fmt.Errorf("render prebuilt %s: %w", output, err)
+		}
+		if err := os.Rename(output+".tmp", output); err != nil {
+			return fmt.Errorf("prebuilt print file: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeMissingSample writes the customer image of sheet to path unless a
+// file is already there.
+func writeMissingSample(path string, sheet samples.Sheet) error {
+	exists, err := fileExists(path)
+	if err != nil || exists {
+		if err != nil {
+			return fmt.Errorf("sample image: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("sample image: %w", err)
+	}
+	log.Printf("Writing missing sample image %s", path)
+	if err := samples.WriteFile(path, sheet, sampleDPI); err != nil {
+		return fmt.Errorf("sample image %s: %w", path, err)
+	}
+	return nil
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
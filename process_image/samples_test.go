@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"test_webhook_service/config"
+	"test_webhook_service/dtfmock"
+	"test_webhook_service/samples"
+)
+
+func TestPrepareSamplesPrebuilt(t *testing.T) {
+	tests := []struct {
+		name       string
+		stages     stages
+		wantRender bool
+	}{
+		{name: "process off", stages: stages{Download: true, Upload: true}, wantRender: true},
+		{name: "upload only", stages: stages{Upload: true}, wantRender: true},
+		{name: "all stages", stages: allStages},
+		{name: "upload off", stages: stages{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+			opts.InputDir = filepath.Join(t.TempDir(), "inputs")
+			opts.PrebuiltDir = t.TempDir()
+
+			// Every print file but the smallest is already there.
+			placeholder := []byte("prebuilt")
+			for _, sheet := range samples.Sheets[1:] {
+				spec := sheetSpec{Sample: sheet.ID, Width: samples.Width, Height: float64(sheet.Height)}
+				if err := os.WriteFile(filepath.Join(opts.PrebuiltDir, spec.outputName()), placeholder, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := prepareSamples(tt.stages); err != nil {
+				t.Fatalf("prepareSamples() error = %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(opts.PrebuiltDir, "tmp-out-ABC-1-22x5.pdf"))
+			switch {
+			case !tt.wantRender && err == nil:
+				t.Errorf("rendered a prebuilt print file with stages %s", tt.stages)
+			case tt.wantRender && err != nil:
+				t.Fatalf("missing prebuilt print file not rendered: %v", err)
+			case tt.wantRender && !bytes.HasPrefix(data, []byte("%PDF-")):
+				t.Errorf("prebuilt print file starts %q, want a PDF", data[:min(len(data), 8)])
+			}
+			spec := sheetSpec{Sample: "ABC-28", Width: 22, Height: 1000}
+			if data, _ := os.ReadFile(filepath.Join(opts.PrebuiltDir, spec.outputName())); !bytes.Equal(data, placeholder) {
+				t.Errorf("existing prebuilt print file was rewritten")
+			}
+			if _, err := os.Stat(opts.InputDir); err == nil {
+				t.Errorf("wrote customer images to %s with stages %s", opts.InputDir, tt.stages)
+			}
+		})
+	}
+}
+
+func TestWorkerProcessesSampleInputs(t *testing.T) {
+	mock := dtfmock.New(dtfmock.Config{Seed: 1})
+	mock.AddOrder("https://cdn.example/samples/tmp-img-ABC-1-22x5.png", "https://cdn.example/samples/tmp-img-ABC-2-22x10.png")
+	useMock(t, mock, 1, 1)
+	opts.Stages = stages{Process: true, Upload: true}
+	opts.InputDir = t.TempDir()
+	opts.PreflightDir = t.TempDir()
+
+	kept := filepath.Join(opts.InputDir, samples.Sheets[1].ImageName())
+	if err := samples.WriteFile(kept, samples.Sheets[1], sampleDPI); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prepareSamples(opts.Stages); err != nil {
+		t.Fatalf("prepareSamples() error = %v", err)
+	}
+	for _, sheet := range samples.Sheets {
+		if _, err := os.Stat(filepath.Join(opts.InputDir, sheet.ImageName())); err != nil {
+			t.Errorf("sample %s: %v", sheet.ID, err)
+		}
+	}
+	if after, err := os.Stat(kept); err != nil || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("existing sample image was rewritten")
+	}
+
+	runWorker(config.Account{UserName: "designer1", Password: "designer"})
+
+	if stats := mock.Stats(); stats.Approved != 1 {
+		t.Errorf("worker approved %d orders, want 1", stats.Approved)
+	}
+	if n := monitor.PreflightFailures(); n != 0 {
+		t.Errorf("PreflightFailures() = %d, want 0", n)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type workerState string
+
+const (
+	stateStarting    workerState = "starting"
+	stateLoggingIn   workerState = "logging in"
+	statePolling     workerState = "polling"
+	stateDownloading workerState = "downloading"
+	stateProcessing  workerState = "processing"
+	stateUploading   workerState = "uploading"
+	stateApproving   workerState = "approving"
+	stateBackingOff  workerState = "backing off"
+	stateThrottled   workerState = "throttled"
+	stateDone        workerState = "done"
+	stateLoginFailed workerState = "login failed"
+)
+
+// WorkerStatus is what the dashboard shows for a single worker.
+type WorkerStatus struct {
+	State   workerState
+	Account string
+	Orders  int
+	Since   time.Time
+}
+
+// Monitor collects worker states and per-account throughput.
+type Monitor struct {
+	mu         sync.Mutex
+	start      time.Time
+	workers    []WorkerStatus
+	perAccount map[string]int
+
+	throttled      time.Duration
+	throttleEvents int
+	relogins       int
+	preflightFails int
+}
+
+func newMonitor(workers int) *Monitor {
+	m := &Monitor{
+		start:      time.Now(),
+		workers:    make([]WorkerStatus, workers),
+		perAccount: make(map[string]int),
+	}
+	for i := range m.workers {
+		m.workers[i] = WorkerStatus{State: stateStarting, Since: m.start}
+	}
+	return m
+}
+
+// SetState records that worker idx entered state.
+func (m *Monitor) SetState(idx int, state workerState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.workers[idx].State != state {
+		m.workers[idx].State = state
+		m.workers[idx].Since = time.Now()
+	}
+}
+
+// SetAccount records which account worker idx is logged in as.
+func (m *Monitor) SetAccount(idx int, account string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[idx].Account = account
+	// List accounts that have not approved anything yet as well.
+	m.perAccount[account] += 0
+}
+
+// OrderDone counts an approved order for worker idx and its account.
+func (m *Monitor) OrderDone(idx int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[idx].Orders++
+	m.perAccount[m.workers[idx].Account]++
+}
+
+// Throttle records that worker idx was told to pause for d.
+func (m *Monitor) Throttle(idx int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[idx].State = stateThrottled
+	m.workers[idx].Since = time.Now()
+	m.throttled += d
+	m.throttleEvents++
+}
+
+// Relogin counts a worker logging in again after its token was rejected
+// or expired.
+func (m *Monitor) Relogin() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.relogins++
+}
+
+// Relogins returns the number of logins after the first one.
+func (m *Monitor) Relogins() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.relogins
+}
+
+// PreflightFailed counts an order product whose image failed preflight.
+func (m *Monitor) PreflightFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.preflightFails++
+}
+
+// PreflightFailures returns the number of products that failed preflight.
+func (m *Monitor) PreflightFailures() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.preflightFails
+}
+
+// Throttled returns the total pause requested by the backend across all
+// workers and the number of throttling responses.
+func (m *Monitor) Throttled() (time.Duration, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.throttled, m.throttleEvents
+}
+
+// TotalOrders returns the number of orders approved by all workers.
+func (m *Monitor) TotalOrders() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := 0
+	for _, w := range m.workers {
+		total += w.Orders
+	}
+	return total
+}
+
+// Workers returns a copy of all worker states.
+func (m *Monitor) Workers() []WorkerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]WorkerStatus(nil), m.workers...)
+}
+
+// AccountCount is the number of orders approved by one account and the
+// number of workers logged in with it.
+type AccountCount struct {
+	Account string
+	Workers int
+	Orders  int
+}
+
+// Accounts returns per-account approved orders, highest first.
+func (m *Monitor) Accounts() []AccountCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]AccountCount, 0, len(m.perAccount))
+	for account, orders := range m.perAccount {
+		out = append(out, AccountCount{Account: account, Orders: orders})
+	}
+	for i := range out {
+		for _, w := range m.workers {
+			if w.Account == out[i].Account {
+				out[i].Workers++
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Orders != out[j].Orders {
+			return out[i].Orders > out[j].Orders
+		}
+		return out[i].Account < out[j].Account
+	})
+	return out
+}
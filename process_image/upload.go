@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/httpclient"
+)
+
+const (
+	// presignMargin renews a presigned URL this long before it expires so
+	// an upload does not start on a URL that runs out halfway. Parts of a
+	// multipart upload are tried until the URLs actually expire, since
+	// renewing them means starting over.
+	presignMargin = time.Minute
+	// maxMultipartRestarts bounds how often a multipart upload whose part
+	// URLs expired is started over.
+	maxMultipartRestarts = 2
+)
+
+// multipartUnsupported is set once the API turned out not to offer
+// multipart uploads; later uploads go straight to a single PUT.
+var multipartUnsupported atomic.Bool
+
+// uploadPrintFile uploads the file at path under key through the presigned
+// flow and returns its download URL. Files above MultipartThreshold are
+// sent in parts.
+func uploadPrintFile(ctx context.Context, idx int, api *dtfapi.Client, key, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(io.NewSectionReader(f, 0, fi.Size()), head)
+	contentType := http.DetectContentType(head[:n])
+
+	if opts.PartSize > 0 && fi.Size() > opts.MultipartThreshold && !multipartUnsupported.Load() {
+		url, err := uploadMultipart(ctx, idx, api, key, f, fi.Size(), contentType)
+		if !dtfapi.IsNotFound(err) {
+			return url, err
+		}
+		if !multipartUnsupported.Swap(true) {
+			log.Printf("worker-%d: API has no multipart uploads, sending large files in one request", idx)
+		}
+	}
+	return uploadSingle(ctx, idx, api, key, f, fi.Size(), contentType)
+}
+
+// uploadSingle PUTs the whole file to one presigned URL, asking for a new
+// URL whenever the current one has expired.
+func uploadSingle(ctx context.Context, idx int, api *dtfapi.Client, key string, f *os.File, size int64, contentType string) (string, error) {
+	body := io.NewSectionReader(f, 0, size)
+	sums, err := checksum(body)
+	if err != nil {
+		return "", err
+	}
+
+	var presigned *dtfapi.PresignedResponse
+	err = retryUpload(ctx, idx, "upload "+key, func() error {
+		if presigned == nil || expired(presigned.Expires(), presignMargin) {
+			p, err := api.Presign(ctx, key)
+			if err != nil {
+				return err
+			}
+			presigned = p
+		}
+		_, err := put(ctx, presigned.URL, body, sums, contentType)
+		if urlExpired(err, presigned.Expires()) {
+			presigned = nil
+			return fmt.Errorf("%w: %v", errURLExpired, err)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return presigned.DownloadURL, nil
+}
+
+// uploadMultipart sends the file in PartSize parts, UploadConcurrency at a
+// time, each with its own checksums and retries. When the part URLs expire
+// before every part is in, the upload is aborted and started over.
+func uploadMultipart(ctx context.Context, idx int, api *dtfapi.Client, key string, f *os.File, size int64, contentType string) (string, error) {
+	parts := int((size + opts.PartSize - 1) / opts.PartSize)
+	sections := make([]*io.SectionReader, parts)
+	sums := make([]checksums, parts)
+	for i := range sections {
+		off := int64(i) * opts.PartSize
+		sections[i] = io.NewSectionReader(f, off, min(opts.PartSize, size-off))
+		var err error
+		if sums[i], err = checksum(sections[i]); err != nil {
+			return "", err
+		}
+	}
+
+	for restart := 0; ; restart++ {
+		var mp *dtfapi.MultipartResponse
+		err := retryUpload(ctx, idx, "start multipart upload of "+key, func() error {
+			var err error
+			mp, err = api.PresignMultipart(ctx, key, parts)
+			if err == nil && len(mp.PartURLs) != parts {
+				return fmt.Errorf("asked for %d part URLs, got %d", parts, len(mp.PartURLs))
+			}
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		log.Printf("worker-%d: uploading %s in %d parts", idx, key, parts)
+
+		completed, err := uploadParts(ctx, idx, mp, sections, sums, contentType)
+		if err == nil {
+			err = retryUpload(ctx, idx, "complete multipart upload of "+key, func() error {
+				return api.CompleteMultipart(ctx, key, mp.UploadID, completed)
+			})
+			if err == nil {
+				return mp.DownloadURL, nil
+			}
+		}
+
+		if abortErr := api.AbortMultipart(ctx, key, mp.UploadID); abortErr != nil {
+			log.Printf("worker-%d: failed to abort multipart upload of %s: %v", idx, key, abortErr)
+		}
+		if !errors.Is(err, errPartsExpired) || restart == maxMultipartRestarts {
+			return "", err
+		}
+		log.Printf("worker-%d: part URLs for %s expired, starting over", idx, key)
+	}
+}
+
+// errPartsExpired stops a multipart upload whose part URLs ran out.
+var errPartsExpired = errors.New("multipart upload URLs expired")
+
+// errURLExpired is a single upload whose presigned URL ran out; it is
+// retried with a new URL.
+var errURLExpired = errors.New("upload URL expired")
+
+// urlExpired reports whether err is storage refusing a presigned URL
+// that expired at expires: a 403 once that time has passed, or one whose
+// body says the request has expired. Any other 403, such as a signature
+// mismatch, is final.
+func urlExpired(err error, expires time.Time) bool {
+	var pe *putError
+	return errors.As(err, &pe) && pe.StatusCode == http.StatusForbidden &&
+		(expired(expires, 0) || strings.Contains(pe.Body, "Request has expired"))
+}
+
+func uploadParts(ctx context.Context, idx int, mp *dtfapi.MultipartResponse, sections []*io.SectionReader, sums []checksums, contentType string) ([]dtfapi.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	completed := make([]dtfapi.CompletedPart, len(sections))
+	sem := make(chan struct{}, max(opts.UploadConcurrency, 1))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := range sections {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			what := fmt.Sprintf("upload part %d/%d of %s", i+1, len(sections), mp.Key)
+			err := retryUpload(ctx, idx, what, func() error {
+				if expired(mp.Expires(), 0) {
+					return errPartsExpired
+				}
+				etag, err := put(ctx, mp.PartURLs[i], sections[i], sums[i], contentType)
+				if urlExpired(err, mp.Expires()) {
+					return fmt.Errorf("%w: %v", errPartsExpired, err)
+				}
+				completed[i] = dtfapi.CompletedPart{PartNumber: i + 1, ETag: etag}
+				return err
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return completed, firstErr
+}
+
+// checksums are the digests sent with an upload so storage can reject
+// data corrupted on the way.
+type checksums struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+func checksum(r io.ReadSeeker) (checksums, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return checksums{}, err
+	}
+	m, s := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(m, s), r); err != nil {
+		return checksums{}, err
+	}
+	return checksums{MD5: m.Sum(nil), SHA256: s.Sum(nil)}, nil
+}
+
+// putError is a presigned PUT that storage did not accept.
+type putError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *putError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("returned status: %s: %s", e.Status, e.Body)
+	}
+	return fmt.Sprintf("returned status: %s", e.Status)
+}
+
+// errChecksumMismatch is an upload whose ETag does not match the data
+// sent.
+var errChecksumMismatch = errors.New("stored object does not match the uploaded data")
+
+// put streams body to a presigned URL and returns the ETag storage
+// assigned. A returned MD5 ETag must match what was sent.
+func put(ctx context.Context, url string, body *io.SectionReader, sums checksums, contentType string) (string, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, io.NopCloser(body))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = body.Size()
+	req.Header.Set("Content-Type", contentType)
+	if opts.UploadChecksums {
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sums.MD5))
+		req.Header.Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sums.SHA256))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		pe := &putError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(msg))}
+		pe.RetryAfter, _ = httpclient.IsThrottled(resp)
+		return "", pe
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if len(etag) == 32 && !strings.EqualFold(etag, hex.EncodeToString(sums.MD5)) {
+		return "", fmt.Errorf("%w: ETag %s, MD5 %x", errChecksumMismatch, etag, sums.MD5)
+	}
+	return etag, nil
+}
+
+// retryUpload runs attempt until it succeeds, fails in a way retrying
+// cannot fix, or UploadAttempts attempts were made.
+func retryUpload(ctx context.Context, idx int, what string, attempt func() error) error {
+	backoff := 500 * time.Millisecond
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		wait, ok := retryable(err)
+		if !ok || n >= opts.UploadAttempts {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff = min(backoff*2, 30*time.Second)
+		}
+		log.Printf("worker-%d: %s failed (attempt %d/%d), retrying in %v: %v", idx, what, n, opts.UploadAttempts, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether err is transient, and how long the server
+// asked to wait when it said.
+func retryable(err error) (time.Duration, bool) {
+	var pe *putError
+	var ae *dtfapi.APIError
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, errPartsExpired):
+		return 0, false
+	case errors.Is(err, errChecksumMismatch), errors.Is(err, errURLExpired):
+		return 0, true
+	case errors.As(err, &pe):
+		switch {
+		case pe.RetryAfter > 0:
+			return pe.RetryAfter, true
+		case pe.StatusCode == http.StatusRequestTimeout, pe.StatusCode == http.StatusTooManyRequests,
+			pe.StatusCode >= 500:
+			return 0, true
+		case pe.StatusCode == http.StatusBadRequest && strings.Contains(pe.Body, "BadDigest"):
+			return 0, true
+		}
+		return 0, false
+	case errors.As(err, &ae):
+		if d, ok := dtfapi.IsThrottled(err); ok {
+			return d, true
+		}
+		return 0, ae.StatusCode >= 500
+	case errors.As(err, &ne), errors.Is(err, io.ErrUnexpectedEOF):
+		return 0, true
+	}
+	return 0, false
+}
+
+// expired reports whether a presigned URL expiring at t is within margin
+// of its expiry. A zero t never expires.
+func expired(t time.Time, margin time.Duration) bool {
+	return !t.IsZero() && time.Now().Add(margin).After(t)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test_webhook_service/dtfapi"
+	"test_webhook_service/dtfmock"
+)
+
+func TestURLExpired(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	forbidden := func(body string) error {
+		return &putError{StatusCode: http.StatusForbidden, Status: "403 Forbidden", Body: body}
+	}
+	tests := []struct {
+		name    string
+		err     error
+		expires time.Time
+		want    bool
+	}{
+		{"past expiry", forbidden("AccessDenied"), past, true},
+		{"body says expired", forbidden("AccessDenied: Request has expired"), future, true},
+		{"wrapped", fmt.Errorf("upload: %w", forbidden("Request has expired")), time.Time{}, true},
+		{"signature mismatch", forbidden("SignatureDoesNotMatch"), future, false},
+		{"no expiry time", forbidden("AccessDenied"), time.Time{}, false},
+		{"not forbidden", &putError{StatusCode: http.StatusInternalServerError, Body: "Request has expired"}, past, false},
+		{"nil", nil, past, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := urlExpired(tt.err, tt.expires); got != tt.want {
+				t.Errorf("urlExpired(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		want     bool
+		wantWait time.Duration
+	}{
+		{"forbidden", &putError{StatusCode: http.StatusForbidden, Body: "SignatureDoesNotMatch"}, false, 0},
+		{"url expired", fmt.Errorf("%w: 403", errURLExpired), true, 0},
+		{"parts expired", fmt.Errorf("%w: 403", errPartsExpired), false, 0},
+		{"server error", &putError{StatusCode: http.StatusServiceUnavailable}, true, 0},
+		{"request timeout", &putError{StatusCode: http.StatusRequestTimeout}, true, 0},
+		{"retry after", &putError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second}, true, 3 * time.Second},
+		{"bad digest", &putError{StatusCode: http.StatusBadRequest, Body: "BadDigest"}, true, 0},
+		{"bad request", &putError{StatusCode: http.StatusBadRequest, Body: "EntityTooLarge"}, false, 0},
+		{"checksum mismatch", fmt.Errorf("%w: ETag x", errChecksumMismatch), true, 0},
+		{"api server error", &dtfapi.APIError{StatusCode: http.StatusBadGateway}, true, 0},
+		{"api not found", &dtfapi.APIError{StatusCode: http.StatusNotFound}, false, 0},
+		{"network", &net.OpError{Op: "dial", Err: timeoutError{}}, true, 0},
+		{"cut off", io.ErrUnexpectedEOF, true, 0},
+		{"canceled", context.Canceled, false, 0},
+		{"other", errors.New("boom"), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := retryable(tt.err)
+			if ok != tt.want || wait != tt.wantWait {
+				t.Errorf("retryable(%v) = %v, %v, want %v, %v", tt.err, wait, ok, tt.wantWait, tt.want)
+			}
+		})
+	}
+}
+
+// storageFaults refuses the first refuse upload PUTs with a 403 carrying
+// body and counts the presign requests and PUTs reaching the mock.
+type storageFaults struct {
+	http.Handler
+	body     string
+	refuse   int64
+	presigns int64
+	puts     int64
+}
+
+func (h *storageFaults) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/image/presigned") && !strings.Contains(r.URL.Path, "complete") && !strings.Contains(r.URL.Path, "abort"):
+		atomic.AddInt64(&h.presigns, 1)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/uploads/"):
+		if atomic.AddInt64(&h.puts, 1) <= h.refuse {
+			io.Copy(io.Discard, r.Body)
+			http.Error(w, h.body, http.StatusForbidden)
+			return
+		}
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// uploadFixture points the workers at the mock behind faults, logs in and
+// writes size bytes of test data to a file.
+func uploadFixture(t *testing.T, faults *storageFaults, size int) (*dtfapi.Client, string, []byte) {
+	t.Helper()
+	faults.Handler = dtfmock.New(dtfmock.Config{Seed: 1})
+	useMock(t, faults, 1, 0)
+	opts.UploadAttempts = 3
+	opts.UploadConcurrency = 2
+	opts.PartSize = 0
+
+	api := dtfapi.New(apiURL, httpClient)
+	auth, err := api.Login(context.Background(), "designer1", "designer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Token = auth.AccessToken
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(opts.WorkDir, "print.pdf")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return api, path, data
+}
+
+// fetch downloads url from the mock.
+func fetch(t *testing.T, url string) []byte {
+	t.Helper()
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUploadPrintFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		checksums bool
+		partSize  int64
+		wantPuts  int64
+	}{
+		{name: "single", wantPuts: 1},
+		{name: "single with checksums", checksums: true, wantPuts: 1},
+		{name: "multipart", partSize: 4 << 10, wantPuts: 3},
+		{name: "multipart with checksums", checksums: true, partSize: 4 << 10, wantPuts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faults := &storageFaults{}
+			api, path, data := uploadFixture(t, faults, 10<<10)
+			opts.UploadChecksums = tt.checksums
+			opts.PartSize = tt.partSize
+			opts.MultipartThreshold = 1 << 10
+
+			url, err := uploadPrintFile(context.Background(), 0, api, "F1-1", path)
+			if err != nil {
+				t.Fatalf("uploadPrintFile: %v", err)
+			}
+			if got := fetch(t, url); !bytes.Equal(got, data) {
+				t.Errorf("downloaded %d bytes, want the %d uploaded", len(got), len(data))
+			}
+			if puts := atomic.LoadInt64(&faults.puts); puts != tt.wantPuts {
+				t.Errorf("sent %d PUTs, want %d", puts, tt.wantPuts)
+			}
+		})
+	}
+}
+
+func TestUploadExpiredURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		partSize     int64
+		body         string
+		wantErr      string
+		wantPresigns int64
+	}{
+		{name: "single renews the URL", body: "AccessDenied: Request has expired", wantPresigns: 2},
+		{name: "multipart starts over", partSize: 4 << 10, body: "AccessDenied: Request has expired", wantPresigns: 2},
+		{name: "single refused", body: "SignatureDoesNotMatch", wantErr: "SignatureDoesNotMatch", wantPresigns: 1},
+		{name: "multipart refused", partSize: 4 << 10, body: "SignatureDoesNotMatch", wantErr: "SignatureDoesNotMatch", wantPresigns: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faults := &storageFaults{refuse: 1, body: tt.body}
+			api, path, data := uploadFixture(t, faults, 10<<10)
+			opts.PartSize = tt.partSize
+			opts.MultipartThreshold = 1 << 10
+			opts.UploadConcurrency = 1
+
+			url, err := uploadPrintFile(context.Background(), 0, api, "F1-1", path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("uploadPrintFile() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("uploadPrintFile: %v", err)
+			} else if got := fetch(t, url); !bytes.Equal(got, data) {
+				t.Errorf("downloaded %d bytes, want the %d uploaded", len(got), len(data))
+			}
+			if n := atomic.LoadInt64(&faults.presigns); n != tt.wantPresigns {
+				t.Errorf("asked for presigned URLs %d times, want %d", n, tt.wantPresigns)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"test_webhook_service/dtfapi"
+)
+
+var (
+	// sampleName matches the sample gang sheets, tmp-img-ABC-11-22x100.png,
+	// capturing the sample id and the size in inches.
+	sampleName = regexp.MustCompile(`^tmp-img-([A-Za-z0-9]+-\d+)-(\d+(?:\.\d+)?)x(\d+(?:\.\d+)?)\.[A-Za-z]+$`)
+	// sheetSize finds a WxH size in inches in a SKU or variant title, such
+	// as "22x100" or "DTF-22 x 100".
+	sheetSize = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*[xX×]\s*(\d+(?:\.\d+)?)`)
+)
+
+// sheetSpec is the gang sheet an order product is for.
+type sheetSpec struct {
+	// Sample is the sample id, e.g. ABC-11, when the customer image is one
+	// of the tmp-img samples; prebuilt outputs exist only for those.
+	Sample string
+	Width  float64 // inches
+	Height float64 // inches
+}
+
+func (s sheetSpec) size() string {
+	return formatInches(s.Width) + "x" + formatInches(s.Height)
+}
+
+// outputName is the prebuilt print file name of a sample.
+func (s sheetSpec) outputName() string {
+	return fmt.Sprintf("tmp-out-%s-%s.pdf", s.Sample, s.size())
+}
+
+// prebuiltFile returns the local prebuilt print file for the sheet.
+func (s sheetSpec) prebuiltFile() (string, error) {
+	if s.Sample == "" {
+		return "", fmt.Errorf("no prebuilt print file for a %s sheet that is not a sample", s.size())
+	}
+	return filepath.Join(opts.PrebuiltDir, s.outputName()), nil
+}
+
+// prebuiltURL returns the hosted prebuilt print file for the sheet.
+func (s sheetSpec) prebuiltURL() (string, error) {
+	if s.Sample == "" {
+		return "", fmt.Errorf("no prebuilt print file URL for a %s sheet that is not a sample", s.size())
+	}
+	return strings.TrimRight(opts.PrebuiltURL, "/") + "/" + s.outputName(), nil
+}
+
+// parseSheet works out the sheet of product from the variant title, the
+// SKU and the name of the customer image, whichever carry a size. They
+// must agree; a product none of them describes is an error.
+func parseSheet(product dtfapi.OrderProductsResponse) (sheetSpec, error) {
+	var spec sheetSpec
+	from := ""
+	set := func(source string, w, h float64) error {
+		if from != "" && (w != spec.Width || h != spec.Height) {
+			return fmt.Errorf("%s says %sx%s but %s says %s", source, formatInches(w), formatInches(h), from, spec.size())
+		}
+		if from == "" {
+			spec.Width, spec.Height, from = w, h, source
+		}
+		return nil
+	}
+
+	for _, field := range []struct{ name, value string }{
+		{"variant title", product.VariantTitle},
+		{"SKU", product.Sku},
+	} {
+		if m := sheetSize.FindStringSubmatch(field.value); m != nil {
+			if err := set(field.name, parseInches(m[1]), parseInches(m[2])); err != nil {
+				return sheetSpec{}, err
+			}
+		}
+	}
+
+	if m := sampleName.FindStringSubmatch(path.Base(urlPath(product.CustomerImgUrl))); m != nil {
+		spec.Sample = m[1]
+		if err := set("image name", parseInches(m[2]), parseInches(m[3])); err != nil {
+			return sheetSpec{}, err
+		}
+	}
+
+	if from == "" {
+		return sheetSpec{}, fmt.Errorf("no sheet size in variant %q, SKU %q or image %s", product.VariantTitle, product.Sku, product.CustomerImgUrl)
+	}
+	if spec.Width <= 0 || spec.Height <= 0 {
+		return sheetSpec{}, fmt.Errorf("invalid sheet size %s from %s", spec.size(), from)
+	}
+	return spec, nil
+}
+
+// parseInches parses a number the regular expressions above matched.
+func parseInches(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+func formatInches(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
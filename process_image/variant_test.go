@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"test_webhook_service/dtfapi"
+)
+
+const sampleBase = "https://pub-ac878ecfb32d4fabac12c91472c4714a.r2.dev/samples/"
+
+func TestParseSheet(t *testing.T) {
+	tests := []struct {
+		name    string
+		product dtfapi.OrderProductsResponse
+		want    sheetSpec
+		wantErr string
+	}{
+		{
+			name:    "sample image only",
+			product: dtfapi.OrderProductsResponse{CustomerImgUrl: sampleBase + "tmp-img-ABC-11-22x100.png"},
+			want:    sheetSpec{Sample: "ABC-11", Width: 22, Height: 100},
+		},
+		{
+			name:    "small sample",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "22x5", CustomerImgUrl: sampleBase + "tmp-img-ABC-1-22x5.png"},
+			want:    sheetSpec{Sample: "ABC-1", Width: 22, Height: 5},
+		},
+		{
+			name:    "sample with query string",
+			product: dtfapi.OrderProductsResponse{CustomerImgUrl: sampleBase + "tmp-img-ABC-28-22x1000.png?v=2#top"},
+			want:    sheetSpec{Sample: "ABC-28", Width: 22, Height: 1000},
+		},
+		{
+			name:    "variant title",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "22x250", CustomerImgUrl: "https://cdn.example/customer.png"},
+			want:    sheetSpec{Width: 22, Height: 250},
+		},
+		{
+			name:    "SKU with spaces and capital X",
+			product: dtfapi.OrderProductsResponse{Sku: "DTF-22 X 120", CustomerImgUrl: "https://cdn.example/customer.png"},
+			want:    sheetSpec{Width: 22, Height: 120},
+		},
+		{
+			name:    "multiplication sign and fractions",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "11.5×24.25 in"},
+			want:    sheetSpec{Width: 11.5, Height: 24.25},
+		},
+		{
+			name:    "all sources agree",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "22x100", Sku: "GS-22x100", CustomerImgUrl: sampleBase + "tmp-img-XYZ-3-22x100.jpg"},
+			want:    sheetSpec{Sample: "XYZ-3", Width: 22, Height: 100},
+		},
+		{
+			name:    "variant and SKU disagree",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "22x100", Sku: "GS-22x200"},
+			wantErr: "SKU says 22x200 but variant title says 22x100",
+		},
+		{
+			name:    "image disagrees",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "22x100", CustomerImgUrl: sampleBase + "tmp-img-ABC-1-22x5.png"},
+			wantErr: "image name says 22x5 but variant title says 22x100",
+		},
+		{
+			name:    "no size anywhere",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "Default Title", Sku: "GS", CustomerImgUrl: "https://cdn.example/customer.png"},
+			wantErr: `no sheet size in variant "Default Title", SKU "GS"`,
+		},
+		{
+			name:    "zero size",
+			product: dtfapi.OrderProductsResponse{VariantTitle: "0x100"},
+			wantErr: "invalid sheet size 0x100 from variant title",
+		},
+		{
+			name:    "not a sample name",
+			product: dtfapi.OrderProductsResponse{CustomerImgUrl: "https://cdn.example/tmp-img-22x100.png"},
+			wantErr: "no sheet size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSheet(tt.product)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseSheet error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSheet: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSheet = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSheetSpecPrebuilt(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts.PrebuiltDir = "pdf"
+	opts.PrebuiltURL = "https://cdn.example/samples/"
+
+	sample := sheetSpec{Sample: "ABC-11", Width: 22, Height: 100}
+	if got := sample.size(); got != "22x100" {
+		t.Errorf("size = %s, want 22x100", got)
+	}
+	if got, err := sample.prebuiltFile(); err != nil || got != "pdf/tmp-out-ABC-11-22x100.pdf" {
+		t.Errorf("prebuiltFile = %s, %v", got, err)
+	}
+	if got, err := sample.prebuiltURL(); err != nil || got != "https://cdn.example/samples/tmp-out-ABC-11-22x100.pdf" {
+		t.Errorf("prebuiltURL = %s, %v", got, err)
+	}
+
+	custom := sheetSpec{Width: 11.5, Height: 24}
+	if _, err := custom.prebuiltFile(); err == nil || !strings.Contains(err.Error(), "11.5x24 sheet that is not a sample") {
+		t.Errorf("prebuiltFile for a custom sheet = %v, want an error", err)
+	}
+	if _, err := custom.prebuiltURL(); err == nil {
+		t.Error("prebuiltURL for a custom sheet succeeded")
+	}
+}
@@ -0,0 +1,161 @@
+// Package samples describes the sample gang sheets the webhook sender
+// orders and generates stand-ins for their customer images, so the mock
+// API and process_image can run without the hosted files.
+package samples
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Width is the width in inches of every sample sheet.
+const Width = 22
+
+// Sheet is one of the sample gang sheets.
+type Sheet struct {
+	ID     string // e.g. ABC-11
+	Height int    // inches
+}
+
+// Sheets are the samples send_webhook orders, 22x5 to 22x1000 inches.
+var Sheets = []Sheet{
+	{"ABC-1", 5}, {"ABC-2", 10}, {"ABC-3", 20}, {"ABC-4", 30}, {"ABC-5", 40},
+	{"ABC-6", 50}, {"ABC-7", 60}, {"ABC-8", 70}, {"ABC-9", 80}, {"ABC-10", 90},
+	{"ABC-11", 100}, {"ABC-12", 110}, {"ABC-13", 120}, {"ABC-14", 130}, {"ABC-15", 140},
+	{"ABC-16", 150}, {"ABC-17", 160}, {"ABC-18", 170}, {"ABC-19", 180}, {"ABC-20", 190},
+	{"ABC-21", 200}, {"ABC-22", 250}, {"ABC-23", 300}, {"ABC-24", 400}, {"ABC-25", 500},
+	{"ABC-26", 600}, {"ABC-27", 750}, {"ABC-28", 1000},
+}
+
+// ImageName is the file name of the sheet's customer image.
+func (s Sheet) ImageName() string {
+	return fmt.Sprintf("tmp-img-%s-%dx%d.png", s.ID, Width, s.Height)
+}
+
+// WriteFile writes the customer image of sheet at dpi to path.
+func WriteFile(path string, sheet Sheet, dpi int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WritePNG(f, Width*dpi, sheet.Height*dpi, dpi); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// samplePalette is transparent followed by the box colours.
+var samplePalette = [][3]byte{{0, 0, 0}, {230, 57, 70}, {69, 123, 157}, {244, 162, 97}}
+
+// WritePNG writes a width x height PNG of one inch boxes on a
+// transparent background. It is 2-bit paletted and repeats every inch, so
+// even a 22x1000 inch sheet at 300 DPI compresses to a few megabytes and
+// decodes row by row.
+func WritePNG(out io.Writer, width, height, dpi int) error {
+	bw := bufio.NewWriter(out)
+	bw.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = 2, 3 // 2-bit palette
+	writeChunk(bw, "IHDR", ihdr)
+
+	plte := make([]byte, 0, 3*len(samplePalette))
+	for _, c := range samplePalette {
+		plte = append(plte, c[:]...)
+	}
+	writeChunk(bw, "PLTE", plte)
+	writeChunk(bw, "tRNS", []byte{0})
+
+	phys := make([]byte, 9)
+	ppm := uint32(float64(dpi)/0.0254 + 0.5)
+	binary.BigEndian.PutUint32(phys[0:], ppm)
+	binary.BigEndian.PutUint32(phys[4:], ppm)
+	phys[8] = 1
+	writeChunk(bw, "pHYs", phys)
+
+	idat := &idatWriter{w: bw}
+	zw, _ := zlib.NewWriterLevel(idat, zlib.BestSpeed)
+	gap := max(dpi/8, 1)
+	rowBytes := (width*2 + 7) / 8
+	blank := make([]byte, 1+rowBytes)
+	rows := make([][]byte, 3) // box rows by the colour of their first box
+	for y := 0; y < height; y++ {
+		by, iy := y/dpi, y%dpi
+		row := blank
+		if iy >= gap && iy < dpi-gap {
+			k := by % 3
+			if rows[k] == nil {
+				rows[k] = boxRow(width, dpi, gap, k)
+			}
+			row = rows[k]
+		}
+		if _, err := zw.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	idat.flush()
+	writeChunk(bw, "IEND", nil)
+	return bw.Flush()
+}
+
+// boxRow is a filtered scanline crossing a row of boxes whose colours
+// start at palette entry 1+k.
+func boxRow(width, dpi, gap, k int) []byte {
+	row := make([]byte, 1+(width*2+7)/8)
+	for x := 0; x < width; x++ {
+		bx, ix := x/dpi, x%dpi
+		if ix < gap || ix >= dpi-gap {
+			continue
+		}
+		idx := byte(1 + (bx+k)%3)
+		row[1+x/4] |= idx << (6 - 2*uint(x%4))
+	}
+	return row
+}
+
+func writeChunk(w *bufio.Writer, kind string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	w.Write(n[:])
+}
+
+// idatWriter splits the compressed image data into 64 KiB IDAT chunks.
+type idatWriter struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+func (c *idatWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= 64<<10 {
+		writeChunk(c.w, "IDAT", c.buf[:64<<10])
+		c.buf = append(c.buf[:0], c.buf[64<<10:]...)
+	}
+	return len(p), nil
+}
+
+func (c *idatWriter) flush() {
+	if len(c.buf) > 0 {
+		writeChunk(c.w, "IDAT", c.buf)
+		c.buf = c.buf[:0]
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"test_webhook_service/config"
+	"test_webhook_service/httpclient"
+)
+
+// compareTarget is one endpoint in a comparison run.
+type compareTarget struct {
+	Name   string
+	URL    string
+	Client *http.Client
+}
+
+// compareResult is the outcome of the run against one target.
+type compareResult struct {
+	Target  compareTarget
+	Stats   *Stats
+	Elapsed time.Duration
+}
+
+// resolveTargets turns a comma separated list of environment names or
+// webhook URLs into comparison targets.
+func resolveTargets(cfg *config.File, list string, opts httpclient.Options) ([]compareTarget, error) {
+	var targets []compareTarget
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var env *config.Environment
+		target := compareTarget{Name: name, URL: name}
+		if !strings.Contains(name, "://") {
+			if cfg == nil {
+				return nil, fmt.Errorf("%s is not a URL and no %s was found", name, config.DefaultFile)
+			}
+			var err error
+			env, err = cfg.Select(name, "send_webhook")
+			if err != nil {
+				return nil, err
+			}
+			target.URL = env.WebhookURL()
+		}
+
+		transport, err := newTransport(env, opts)
+		if err != nil {
+			return nil, err
+		}
+		target.Client = &http.Client{Transport: transport, Timeout: 3 * time.Minute}
+		targets = append(targets, target)
+	}
+
+	if len(targets) < 2 {
+		return nil, fmt.Errorf("need at least two targets, got %d", len(targets))
+	}
+	return targets, nil
+}
+
+// runCompare sends the identical order stream to every target, either all
+// at once or one after another, and prints a side-by-side comparison.
+func runCompare(ctx context.Context, targets []compareTarget, opts loadOptions, sequential bool) {
+	results := make([]compareResult, len(targets))
+
+	run := func(i int) {
+		t := targets[i]
+		o := opts
+		o.URL = t.URL
+
+		log.Printf("Comparison: starting %s (%s)", t.Name, t.URL)
+		stats := &Stats{}
+		start := time.Now()
+		runLoad(ctx, t.Client, o, newRateLimiter(o.Rate, o.Burst), stats)
+		results[i] = compareResult{Target: t, Stats: stats, Elapsed: time.Since(start)}
+		log.Printf("Comparison: finished %s in %v", t.Name, results[i].Elapsed.Round(time.Millisecond))
+	}
+
+	if sequential {
+		for i := range targets {
+			run(i)
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i := range targets {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	printComparison(results)
+}
+
+// printComparison logs one column per target, like the other final
+// reports, so the table ends up wherever the log goes.
+func printComparison(results []compareResult) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+	row := func(label string, value func(r compareResult) string) {
+		fmt.Fprintf(tw, "%s\t", label)
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t", value(r))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	row("", func(r compareResult) string { return r.Target.Name })
+	row("Requests", func(r compareResult) string {
+		return fmt.Sprint(atomic.LoadInt64(&r.Stats.TotalRequests))
+	})
+	row("Success rate", func(r compareResult) string {
+		total := atomic.LoadInt64(&r.Stats.TotalRequests)
+		if total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%%", float64(atomic.LoadInt64(&r.Stats.SuccessRequests))/float64(total)*100)
+	})
+	row("Errors", func(r compareResult) string {
+		return fmt.Sprint(atomic.LoadInt64(&r.Stats.FailedRequests))
+	})
+	row("Throughput (req/s)", func(r compareResult) string {
+		return fmt.Sprintf("%.2f", float64(atomic.LoadInt64(&r.Stats.TotalRequests))/r.Elapsed.Seconds())
+	})
+	for _, p := range []float64{50, 90, 99} {
+		p := p
+		row(fmt.Sprintf("p%g", p), func(r compareResult) string {
+			return r.Stats.CorrectedLatency.Percentile(p).Round(time.Millisecond).String()
+		})
+	}
+	row("max", func(r compareResult) string {
+		return r.Stats.CorrectedLatency.Max().Round(time.Millisecond).String()
+	})
+	row("Top error", func(r compareResult) string {
+		errs := r.Stats.Errors.Sorted()
+		if len(errs) == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%s (%d)", errs[0].Class, errs[0].Count)
+	})
+	tw.Flush()
+
+	log.Printf("\n=== Comparison ===")
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Print(line)
+	}
+}
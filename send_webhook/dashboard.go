@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"test_webhook_service/tui"
+)
+
+// dashboard renders the live send_webhook view for -tui.
+type dashboard struct {
+	stats   *Stats
+	limiter *RateLimiter
+	target  string
+	start   time.Time
+
+	lastTick  time.Time
+	lastTotal int64
+	lastHist  *Histogram
+
+	rps *tui.Series
+	p50 *tui.Series
+	p99 *tui.Series
+
+	curRPS float64
+	curP50 time.Duration
+	curP99 time.Duration
+}
+
+func newDashboard(stats *Stats, limiter *RateLimiter, target string, start time.Time) *dashboard {
+	return &dashboard{
+		stats:    stats,
+		limiter:  limiter,
+		target:   target,
+		start:    start,
+		lastTick: start,
+		lastHist: &Histogram{},
+		rps:      tui.NewSeries(60),
+		p50:      tui.NewSeries(60),
+		p99:      tui.NewSeries(60),
+	}
+}
+
+// sample advances the per-interval series. It is called once per frame.
+func (d *dashboard) sample() {
+	now := time.Now()
+	total := atomic.LoadInt64(&d.stats.TotalRequests)
+	window := d.stats.CorrectedLatency.Since(d.lastHist)
+
+	if elapsed := now.Sub(d.lastTick).Seconds(); elapsed > 0 {
+		d.curRPS = float64(total-d.lastTotal) / elapsed
+	}
+	d.curP50 = window.Percentile(50)
+	d.curP99 = window.Percentile(99)
+
+	d.rps.Add(d.curRPS)
+	d.p50.Add(float64(d.curP50))
+	d.p99.Add(float64(d.curP99))
+
+	d.lastTick = now
+	d.lastTotal = total
+	d.lastHist = d.stats.CorrectedLatency.Snapshot()
+}
+
+func (d *dashboard) frame() []string {
+	d.sample()
+
+	width, _ := tui.Size()
+	s := d.stats
+	total := atomic.LoadInt64(&s.TotalRequests)
+	success := atomic.LoadInt64(&s.SuccessRequests)
+	failed := atomic.LoadInt64(&s.FailedRequests)
+
+	lines := []string{
+		tui.Rule("send_webhook", width),
+		fmt.Sprintf(" Target   %s", d.target),
+		fmt.Sprintf(" Elapsed  %-12s In-flight %-6d", time.Since(d.start).Round(time.Second), atomic.LoadInt64(&s.InFlight)),
+		"",
+		fmt.Sprintf(" RPS      %8.2f  %s", d.curRPS, tui.Sparkline(d.rps.Values())),
+		fmt.Sprintf(" p50      %8s  %s", tui.FormatDuration(d.curP50), tui.Sparkline(d.p50.Values())),
+		fmt.Sprintf(" p99      %8s  %s", tui.FormatDuration(d.curP99), tui.Sparkline(d.p99.Values())),
+		"",
+		fmt.Sprintf(" Total %d   Success %d   Failed %d", total, success, failed),
+		fmt.Sprintf(" Overall  p50=%s p90=%s p99=%s max=%s (from schedule)",
+			tui.FormatDuration(s.CorrectedLatency.Percentile(50)),
+			tui.FormatDuration(s.CorrectedLatency.Percentile(90)),
+			tui.FormatDuration(s.CorrectedLatency.Percentile(99)),
+			tui.FormatDuration(s.CorrectedLatency.Max())),
+		fmt.Sprintf(" Payload  p50=%s p99=%s max=%s",
+			formatBytes(s.PayloadBytes.PercentileValue(50)),
+			formatBytes(s.PayloadBytes.PercentileValue(99)),
+			formatBytes(s.PayloadBytes.MaxValue())),
+	}
+
+	if throttledFor, events := d.limiter.Throttled(); events > 0 {
+		lines = append(lines, fmt.Sprintf(" Throttled %d responses, paused %s, permitted %.2f req/s",
+			events, tui.FormatDuration(throttledFor), d.limiter.CurrentRate()))
+	}
+
+	if lag, missed := d.limiter.Behind(); missed > 0 {
+		lines = append(lines, fmt.Sprintf(" Generator behind by %s (%d overdue)", tui.FormatDuration(lag), missed))
+	}
+
+	lines = append(lines, tui.Rule("Errors", width))
+	errs := s.Errors.Sorted()
+	if len(errs) == 0 {
+		lines = append(lines, " none")
+	}
+	for i, e := range errs {
+		if i == 8 {
+			lines = append(lines, fmt.Sprintf(" ... %d more classes", len(errs)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf(" %-16s %8d %s", e.Class, e.Count, tui.Bar(float64(e.Count), float64(failed), 30)))
+	}
+
+	return lines
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is one failed webhook delivery as stored in the dead-letter
+// file. Payload is the uncompressed JSON body.
+type DeadLetter struct {
+	Time       time.Time         `json:"time"`
+	URL        string            `json:"url"`
+	OrderID    int64             `json:"order_id"`
+	Headers    map[string]string `json:"headers"`
+	Payload    json.RawMessage   `json:"payload"`
+	Status     int               `json:"status,omitempty"`
+	Error      string            `json:"error"`
+	ErrorClass string            `json:"error_class"`
+	Attempts   int               `json:"attempts"`
+}
+
+// DeadLetterFile appends failed deliveries to a JSONL file, which is only
+// created once the first entry is written. It is safe for concurrent use; a
+// nil *DeadLetterFile discards everything.
+type DeadLetterFile struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+	count int64
+}
+
+// openDeadLetterFile prepares path for appending. It fails early when the
+// file exists but cannot be written.
+func openDeadLetterFile(path string) (*DeadLetterFile, error) {
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &DeadLetterFile{path: path, f: f, w: bufio.NewWriter(f)}, nil
+	}
+	return &DeadLetterFile{path: path}, nil
+}
+
+// Record appends one entry.
+func (d *DeadLetterFile) Record(entry DeadLetter) error {
+	if d == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		d.f, d.w = f, bufio.NewWriter(f)
+	}
+	if _, err := d.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	d.count++
+	// Flush every entry so a crashed run still leaves a usable file.
+	return d.w.Flush()
+}
+
+// Count returns the number of entries written.
+func (d *DeadLetterFile) Count() int64 {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.count
+}
+
+// Close flushes and closes the file.
+func (d *DeadLetterFile) Close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return nil
+	}
+	if err := d.w.Flush(); err != nil {
+		d.f.Close()
+		return err
+	}
+	return d.f.Close()
+}
+
+// readDeadLetters loads every entry of a dead-letter file.
+func readDeadLetters(path string) ([]DeadLetter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []DeadLetter
+	scanner := bufio.NewScanner(f)
+	// Bulk orders easily exceed the default 64KB line limit.
+	scanner.Buffer(make([]byte, 0, 1<<20), 256<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
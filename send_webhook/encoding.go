@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// bodyEncodings are the accepted -content-encoding values.
+var bodyEncodings = []string{"identity", "gzip", "deflate"}
+
+// encodeBody compresses payload for the given Content-Encoding. "deflate"
+// is the zlib format, as HTTP defines it.
+func encodeBody(payload []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch encoding {
+	case "", "identity":
+		return payload, nil
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrorCounts tallies failed requests by error class.
+type ErrorCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// Add increments the counter for class.
+func (e *ErrorCounts) Add(class string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.counts == nil {
+		e.counts = make(map[string]int64)
+	}
+	e.counts[class]++
+}
+
+// ErrorCount is one row of the error breakdown.
+type ErrorCount struct {
+	Class string
+	Count int64
+}
+
+// Sorted returns the breakdown ordered by count, highest first.
+func (e *ErrorCounts) Sorted() []ErrorCount {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := make([]ErrorCount, 0, len(e.counts))
+	for class, count := range e.counts {
+		out = append(out, ErrorCount{Class: class, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Class < out[j].Class
+	})
+	return out
+}
+
+// statusClass names the error class for a non-2xx response.
+func statusClass(status int) string {
+	return fmt.Sprintf("http_%d", status)
+}
+
+// classifyError maps a transport error to a short, stable class name.
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "conn_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "conn_reset"
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:"):
+		return "tls"
+	case strings.Contains(msg, "EOF"):
+		return "eof"
+	}
+	return "other"
+}
+
+// statusError is returned by sendWebhook for non-2xx responses.
+type statusError struct {
+	StatusCode int
+	// Throttled is set for 429s and for 503s with Retry-After; RetryAfter
+	// then holds how long the backend asked us to wait.
+	Throttled  bool
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned status: %d", e.StatusCode)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mutation turns a generated order into a malformed or adversarial body.
+type mutation struct {
+	Name  string
+	Apply func(order ShopifyOrder) ([]byte, error)
+}
+
+// orderFields round-trips the order through JSON so mutations can change
+// field types freely.
+func orderFields(order ShopifyOrder) (map[string]interface{}, error) {
+	payload, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// mutateFields applies fn to the generic form of the order and encodes it.
+func mutateFields(fn func(fields map[string]interface{})) func(ShopifyOrder) ([]byte, error) {
+	return func(order ShopifyOrder) ([]byte, error) {
+		fields, err := orderFields(order)
+		if err != nil {
+			return nil, err
+		}
+		fn(fields)
+		return json.Marshal(fields)
+	}
+}
+
+// eachLineItem calls fn for every line item object in fields.
+func eachLineItem(fields map[string]interface{}, fn func(item map[string]interface{})) {
+	items, _ := fields["line_items"].([]interface{})
+	for _, it := range items {
+		if item, ok := it.(map[string]interface{}); ok {
+			fn(item)
+		}
+	}
+}
+
+func deleteField(name string) func(ShopifyOrder) ([]byte, error) {
+	return mutateFields(func(fields map[string]interface{}) {
+		delete(fields, name)
+	})
+}
+
+var mutations = []mutation{
+	{Name: "missing_id", Apply: deleteField("id")},
+	{Name: "missing_line_items", Apply: deleteField("line_items")},
+	{Name: "missing_customer", Apply: deleteField("customer")},
+	{Name: "missing_created_at", Apply: deleteField("created_at")},
+	{Name: "null_line_items", Apply: mutateFields(func(fields map[string]interface{}) {
+		fields["line_items"] = nil
+	})},
+	{Name: "empty_line_items", Apply: mutateFields(func(fields map[string]interface{}) {
+		fields["line_items"] = []interface{}{}
+	})},
+	{Name: "wrong_type_id", Apply: mutateFields(func(fields map[string]interface{}) {
+		fields["id"] = fmt.Sprintf("%v", fields["id"])
+	})},
+	{Name: "wrong_type_line_items", Apply: mutateFields(func(fields map[string]interface{}) {
+		fields["line_items"] = map[string]interface{}{"id": 1}
+	})},
+	{Name: "wrong_type_quantity", Apply: mutateFields(func(fields map[string]interface{}) {
+		eachLineItem(fields, func(item map[string]interface{}) {
+			item["quantity"] = "ten"
+		})
+	})},
+	{Name: "negative_quantity", Apply: mutateFields(func(fields map[string]interface{}) {
+		eachLineItem(fields, func(item map[string]interface{}) {
+			item["quantity"] = -1
+		})
+	})},
+	{Name: "wrong_type_price", Apply: mutateFields(func(fields map[string]interface{}) {
+		fields["current_total_price"] = 15.5
+		eachLineItem(fields, func(item map[string]interface{}) {
+			item["price"] = true
+		})
+	})},
+	{Name: "null_properties", Apply: mutateFields(func(fields map[string]interface{}) {
+		eachLineItem(fields, func(item map[string]interface{}) {
+			item["properties"] = nil
+		})
+	})},
+	{Name: "huge_properties", Apply: mutateFields(func(fields map[string]interface{}) {
+		props := make([]interface{}, 20000)
+		for i := range props {
+			props[i] = map[string]interface{}{"name": fmt.Sprintf("Prop %d", i), "value": "x"}
+		}
+		eachLineItem(fields, func(item map[string]interface{}) {
+			item["properties"] = props
+		})
+	})},
+	{Name: "multi_megabyte_body", Apply: mutateFields(func(fields map[string]interface{}) {
+		fields["note"] = strings.Repeat("A", 8<<20)
+	})},
+	{Name: "deeply_nested", Apply: func(order ShopifyOrder) ([]byte, error) {
+		payload, err := json.Marshal(order)
+		if err != nil {
+			return nil, err
+		}
+		const depth = 100000
+		nested := strings.Repeat(`{"a":`, depth) + "1" + strings.Repeat("}", depth)
+		return append(payload[:len(payload)-1], []byte(`,"note_attributes":`+nested+"}")...), nil
+	}},
+	{Name: "invalid_utf8", Apply: func(order ShopifyOrder) ([]byte, error) {
+		payload, err := json.Marshal(order)
+		if err != nil {
+			return nil, err
+		}
+		name := []byte(`"first_name":"`)
+		return bytes.ReplaceAll(payload, name, append(name, 0xff, 0xfe, 0xc3, 0x28)), nil
+	}},
+	{Name: "truncated_json", Apply: func(order ShopifyOrder) ([]byte, error) {
+		payload, err := json.Marshal(order)
+		if err != nil {
+			return nil, err
+		}
+		return payload[:len(payload)/2], nil
+	}},
+	{Name: "not_json", Apply: func(order ShopifyOrder) ([]byte, error) {
+		return []byte("<html><body>orders/create</body></html>"), nil
+	}},
+	{Name: "empty_body", Apply: func(order ShopifyOrder) ([]byte, error) {
+		return nil, nil
+	}},
+}
+
+// selectMutations returns the mutations named in a comma separated list, or
+// all of them when the list is empty.
+func selectMutations(names string) ([]mutation, error) {
+	if names == "" {
+		return mutations, nil
+	}
+
+	var selected []mutation
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, m := range mutations {
+			if m.Name == name {
+				selected = append(selected, m)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown mutation %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// fuzzResult tallies how the server answered one mutation.
+type fuzzResult struct {
+	Sent      int64
+	Status2xx int64
+	Status4xx int64
+	Status5xx int64
+	Hangs     int64
+	Errors    int64
+	MaxBytes  int64
+}
+
+// runFuzz sends total mutated orders, cycling through muts, and reports
+// which mutations made the server fail or hang.
+func runFuzz(ctx context.Context, client *http.Client, opts loadOptions, muts []mutation, limiter *RateLimiter, timeout time.Duration) {
+	results := make([]fuzzResult, len(muts))
+	stats := &Stats{}
+
+	jobs := make(chan orderJob, opts.Concurrency*2)
+	var wg sync.WaitGroup
+
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for job := range jobs {
+				idx := int(job.OrderID) % len(muts)
+				m := muts[idx]
+				res := &results[idx]
+
+				payload, err := m.Apply(generateOrder(newOrderRand(opts.Seed, job.OrderID), job.OrderID, job.Scheduled, opts.Profile, stats))
+				if err != nil {
+					log.Printf("Worker %d: mutation %s failed: %v", workerID, m.Name, err)
+					continue
+				}
+				atomic.AddInt64(&res.Sent, 1)
+				for {
+					cur := atomic.LoadInt64(&res.MaxBytes)
+					if int64(len(payload)) <= cur || atomic.CompareAndSwapInt64(&res.MaxBytes, cur, int64(len(payload))) {
+						break
+					}
+				}
+
+				status, err := sendFuzzed(ctx, client, opts.URL, payload, timeout)
+				switch {
+				case errors.Is(err, context.DeadlineExceeded) || (err != nil && classifyError(err) == "timeout"):
+					atomic.AddInt64(&res.Hangs, 1)
+					log.Printf("Worker %d: %s: no response within %v", workerID, m.Name, timeout)
+				case err != nil:
+					atomic.AddInt64(&res.Errors, 1)
+					log.Printf("Worker %d: %s: %v", workerID, m.Name, err)
+				case status >= 500:
+					atomic.AddInt64(&res.Status5xx, 1)
+					log.Printf("Worker %d: %s: server returned status: %d", workerID, m.Name, status)
+				case status >= 400:
+					atomic.AddInt64(&res.Status4xx, 1)
+				default:
+					atomic.AddInt64(&res.Status2xx, 1)
+				}
+			}
+		}(w)
+	}
+
+	for i := int64(0); i < int64(opts.Total); i++ {
+		scheduled, err := limiter.Wait(ctx)
+		if err != nil {
+			break
+		}
+		jobs <- orderJob{OrderID: i, Scheduled: scheduled}
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("\n=== Fuzz Results ===")
+	log.Printf("%-24s %6s %6s %6s %6s %6s %6s %10s", "Mutation", "Sent", "2xx", "4xx", "5xx", "Hang", "Error", "MaxBytes")
+	var failing []string
+	for i, m := range muts {
+		r := results[i]
+		log.Printf("%-24s %6d %6d %6d %6d %6d %6d %10d", m.Name, r.Sent, r.Status2xx, r.Status4xx, r.Status5xx, r.Hangs, r.Errors, r.MaxBytes)
+		if r.Status5xx > 0 || r.Hangs > 0 {
+			failing = append(failing, m.Name)
+		}
+	}
+
+	if len(failing) == 0 {
+		log.Printf("No mutation caused a 5xx or a hang")
+	} else {
+		log.Printf("Mutations answered with 5xx or hanging: %s", strings.Join(failing, ", "))
+	}
+}
+
+// sendFuzzed posts a raw body and returns the status code. Responses that
+// take longer than timeout count as hangs.
+func sendFuzzed(ctx context.Context, client *http.Client, url string, payload []byte, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := newWebhookRequest(ctx, url, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
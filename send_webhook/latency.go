@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Buckets grow by 2% so every reported percentile is within 2% of the
+	// real value, from 1µs up to about 15 hours (1.02^1250 µs).
+	histogramGrowth  = 1.02
+	histogramBuckets = 1250
+)
+
+var histogramLogGrowth = math.Log(histogramGrowth)
+
+// Histogram is a fixed-size, lock-free latency histogram with logarithmic
+// buckets. Memory use does not grow with the number of samples, so it can
+// be kept for runs lasting many hours.
+type Histogram struct {
+	counts [histogramBuckets]int64
+	total  int64
+	sum    int64 // in microseconds for latencies
+	max    int64 // in microseconds for latencies
+}
+
+func bucketFor(v int64) int {
+	if v <= 1 {
+		return 0
+	}
+	idx := int(math.Log(float64(v)) / histogramLogGrowth)
+	if idx >= histogramBuckets {
+		idx = histogramBuckets - 1
+	}
+	return idx
+}
+
+func bucketUpperBound(idx int) int64 {
+	return int64(math.Ceil(math.Pow(histogramGrowth, float64(idx+1))))
+}
+
+// Record adds one latency sample.
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordValue(d.Microseconds())
+}
+
+// RecordValue adds one raw sample. Latencies are stored in microseconds;
+// other distributions, such as payload sizes, use their own unit.
+func (h *Histogram) RecordValue(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.counts[bucketFor(v)], 1)
+	atomic.AddInt64(&h.total, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		cur := atomic.LoadInt64(&h.max)
+		if v <= cur || atomic.CompareAndSwapInt64(&h.max, cur, v) {
+			break
+		}
+	}
+}
+
+// Count returns the number of recorded samples.
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.total)
+}
+
+// Mean returns the average of all samples.
+func (h *Histogram) Mean() time.Duration {
+	total := atomic.LoadInt64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum)/total) * time.Microsecond
+}
+
+// Max returns the largest recorded sample.
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max)) * time.Microsecond
+}
+
+// Percentile returns the latency below which p percent (0-100) of the
+// samples fall.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	return time.Duration(h.PercentileValue(p)) * time.Microsecond
+}
+
+// PercentileValue is Percentile for raw samples.
+func (h *Histogram) PercentileValue(p float64) int64 {
+	total := atomic.LoadInt64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(float64(total) * p / 100))
+	if target < 1 {
+		target = 1
+	}
+
+	maxVal := atomic.LoadInt64(&h.max)
+	var seen int64
+	for i := range h.counts {
+		seen += atomic.LoadInt64(&h.counts[i])
+		if seen >= target {
+			if i == histogramBuckets-1 {
+				// The last bucket also holds everything beyond its range.
+				return maxVal
+			}
+			return min(bucketUpperBound(i), maxVal)
+		}
+	}
+	return maxVal
+}
+
+// MaxValue returns the largest raw sample.
+func (h *Histogram) MaxValue() int64 {
+	return atomic.LoadInt64(&h.max)
+}
+
+// Snapshot returns a point-in-time copy of the histogram.
+func (h *Histogram) Snapshot() *Histogram {
+	s := &Histogram{}
+	for i := range h.counts {
+		s.counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	s.total = atomic.LoadInt64(&h.total)
+	s.sum = atomic.LoadInt64(&h.sum)
+	s.max = atomic.LoadInt64(&h.max)
+	return s
+}
+
+// Since returns the samples recorded between prev and h, which is how the
+// dashboard gets per-interval percentiles. The maximum is approximated by
+// the upper bound of the highest non-empty bucket.
+func (h *Histogram) Since(prev *Histogram) *Histogram {
+	cur := h.Snapshot()
+	d := &Histogram{
+		total: cur.total - prev.total,
+		sum:   cur.sum - prev.sum,
+	}
+	for i := range cur.counts {
+		d.counts[i] = cur.counts[i] - prev.counts[i]
+		if d.counts[i] > 0 {
+			d.max = bucketUpperBound(i)
+		}
+	}
+	return d
+}
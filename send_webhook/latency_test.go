@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		p       float64
+		want    time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single sample", []time.Duration{42 * time.Millisecond}, 99, 42 * time.Millisecond},
+		{"median of three", []time.Duration{time.Millisecond, 5 * time.Millisecond, 9 * time.Millisecond}, 50, 5 * time.Millisecond},
+		{"p0 is the smallest", []time.Duration{3 * time.Millisecond, time.Second}, 0, 3 * time.Millisecond},
+		{"p100 is the largest", []time.Duration{3 * time.Millisecond, time.Second}, 100, time.Second},
+		{"sub-microsecond", []time.Duration{100 * time.Nanosecond}, 50, 0},
+		{"one hour", []time.Duration{time.Hour}, 50, time.Hour},
+		{"ten hours", []time.Duration{10 * time.Hour}, 50, 10 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Histogram
+			for _, s := range tt.samples {
+				h.Record(s)
+			}
+			got := h.Percentile(tt.p)
+			if diff := math.Abs(float64(got - tt.want)); diff > 0.02*float64(tt.want) {
+				t.Errorf("Percentile(%v) = %v, want %v within 2%%", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramAccuracy(t *testing.T) {
+	var h Histogram
+	for v := int64(1); v <= 100000; v++ {
+		h.RecordValue(v)
+	}
+	for _, p := range []float64{1, 10, 50, 90, 99, 99.9} {
+		want := float64(100000) * p / 100
+		got := float64(h.PercentileValue(p))
+		if math.Abs(got-want) > 0.02*want+1 {
+			t.Errorf("p%v = %v, want %v within 2%%", p, got, want)
+		}
+	}
+}
+
+func TestHistogramStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []int64
+		wantCount int64
+		wantMean  time.Duration
+		wantMax   time.Duration
+	}{
+		{"empty", nil, 0, 0, 0},
+		{"values", []int64{1000, 2000, 6000}, 3, 3 * time.Millisecond, 6 * time.Millisecond},
+		{"negative clamps to zero", []int64{-500, 1000}, 2, 500 * time.Microsecond, time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Histogram
+			for _, v := range tt.values {
+				h.RecordValue(v)
+			}
+			if got := h.Count(); got != tt.wantCount {
+				t.Errorf("Count = %d, want %d", got, tt.wantCount)
+			}
+			if got := h.Mean(); got != tt.wantMean {
+				t.Errorf("Mean = %v, want %v", got, tt.wantMean)
+			}
+			if got := h.Max(); got != tt.wantMax {
+				t.Errorf("Max = %v, want %v", got, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestHistogramOverflow(t *testing.T) {
+	var h Histogram
+	h.Record(1000 * time.Hour)
+	if got := bucketFor(h.MaxValue()); got != histogramBuckets-1 {
+		t.Errorf("bucketFor(1000h) = %d, want the last bucket %d", got, histogramBuckets-1)
+	}
+	if got := h.Percentile(50); got != 1000*time.Hour {
+		t.Errorf("Percentile(50) = %v, want the recorded maximum", got)
+	}
+}
+
+func TestHistogramSince(t *testing.T) {
+	var h Histogram
+	h.Record(time.Millisecond)
+	h.Record(2 * time.Millisecond)
+	prev := h.Snapshot()
+	h.Record(100 * time.Millisecond)
+	h.Record(200 * time.Millisecond)
+
+	d := h.Since(prev)
+	if got := d.Count(); got != 2 {
+		t.Errorf("Count = %d, want 2", got)
+	}
+	if got := d.Mean(); got != 150*time.Millisecond {
+		t.Errorf("Mean = %v, want 150ms", got)
+	}
+	if got := d.Percentile(50); math.Abs(float64(got-100*time.Millisecond)) > 0.02*float64(100*time.Millisecond) {
+		t.Errorf("Percentile(50) = %v, want about 100ms", got)
+	}
+	if got := d.Max(); got < 200*time.Millisecond || got > 204*time.Millisecond {
+		t.Errorf("Max = %v, want the upper bound of the 200ms bucket", got)
+	}
+}
@@ -3,16 +3,30 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/google/uuid"
 
+	"test_webhook_service/config"
+	"test_webhook_service/har"
+	"test_webhook_service/httpclient"
+	"test_webhook_service/soak"
+	"test_webhook_service/tracing"
+	"test_webhook_service/tui"
+
 	"sync"
 	"sync/atomic"
 	"time"
@@ -138,18 +152,23 @@ type ShopifyOrder struct {
 	FulfillmentStatus      interface{}     `json:"fulfillment_status"`
 }
 
+// firstGeneratedVariant is the index of 22x100 in variants and
+// printReadyFiles. Generated orders use 22x100 and up; the smaller sheets
+// only appear when replayed history asks for them.
+const firstGeneratedVariant = 10
+
 var (
 	printReadyFiles = []string{
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-1-22x5.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-2-22x10.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-3-22x20.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-4-22x30.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-5-22x40.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-6-22x50.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-7-22x60.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-8-22x70.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-9-22x80.png",
-		//"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-10-22x90.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-1-22x5.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-2-22x10.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-3-22x20.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-4-22x30.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-5-22x40.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-6-22x50.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-7-22x60.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-8-22x70.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-9-22x80.png",
+		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-10-22x90.png",
 		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-11-22x100.png",
 		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-12-22x110.png",
 		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-13-22x120.png",
@@ -170,16 +189,16 @@ var (
 		"https://dtfgangsheetbk.daovudat.site/samples/tmp-img-ABC-28-22x1000.png",
 	}
 	variants = []string{
-		//"22x5",
-		//"22x10",
-		//"22x20",
-		//"22x30",
-		//"22x40",
-		//"22x50",
-		//"22x60",
-		//"22x70",
-		//"22x80",
-		//"22x90",
+		"22x5",
+		"22x10",
+		"22x20",
+		"22x30",
+		"22x40",
+		"22x50",
+		"22x60",
+		"22x70",
+		"22x80",
+		"22x90",
 		"22x100",
 		"22x110",
 		"22x120",
@@ -246,22 +265,56 @@ type Stats struct {
 	Type1Count int64 // File Upload with Shopify CDN URL
 	Type2Count int64 // File Upload with invalid value
 	Type3Count int64 // Print Ready File
+
+	// Open-model bookkeeping
+	InFlight int64 // requests currently awaiting a response
+	CapWaits int64 // dispatches delayed because -max-inflight was reached
+
+	Throttled int64 // 429 (or 503 with Retry-After) responses
+
+	Latency          Histogram // from actual send time (service time)
+	CorrectedLatency Histogram // from intended send time (includes queueing)
+
+	PayloadBytes Histogram // size of every body sent, in bytes
+
+	RawBytes      int64 // request bodies before Content-Encoding
+	WireBytes     int64 // request bodies as sent
+	ResponseBytes int64 // response bodies as read
+
+	Errors ErrorCounts
+}
+
+// orderJob is a single order to send together with the time the rate
+// limiter scheduled it for.
+type orderJob struct {
+	OrderID   int64
+	Scheduled time.Time
 }
 
-func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
-	firstNameIdx := rand.Intn(len(firstNames))
-	lastNameIdx := rand.Intn(len(lastNames))
-	cityIdx := rand.Intn(len(cityData))
+// Line item and shipping line IDs are base + orderID*maxLineItems + i, so
+// they are unique across orders as long as no order has more lines.
+const (
+	lineItemIDBase     = 15573094760617
+	shippingLineIDBase = 5468266823849
+	maxLineItems       = 10000
+)
+
+// generateOrder builds order orderID from rng. createdAt is the time the
+// order was scheduled, so the payload does not depend on when it is built.
+func generateOrder(rng *rand.Rand, orderID int64, createdAt time.Time, profile OrderProfile, stats *Stats) ShopifyOrder {
+	firstNameIdx := rng.Intn(len(firstNames))
+	lastNameIdx := rng.Intn(len(lastNames))
+	cityIdx := rng.Intn(len(cityData))
 
 	firstName := firstNames[firstNameIdx]
 	lastName := lastNames[lastNameIdx]
 	email := fmt.Sprintf("%s.%s%d@example.com", firstName, lastName, orderID)
 
-	// Keep total between $13-$17 by constraining item price to $8.10-$12.10 plus $4.90 shipping.
-	price := fmt.Sprintf("%.2f", 8.10+rand.Float64()*4.0)
+	// Every sheet costs $8.10-$12.10 and every shipping line $4.90; the
+	// order totals add them up in cents.
+	price := fmt.Sprintf("%.2f", 8.10+rng.Float64()*4.0)
 	shippingPrice := "4.90"
-	subtotal := price
-	totalPrice := fmt.Sprintf("%.2f", mustParseFloat(price)+mustParseFloat(shippingPrice))
+	priceCents := toCents(price)
 
 	productID := int64(8779236999337)
 	//randItem := rand.Intn(len(variants))
@@ -270,23 +323,26 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 	vendor := "DTFsheet and custom shirts"
 
 	// random the number of line items
-	numLineItems := rand.Intn(2) + 1
+	numLineItems := profile.lineItemCount(rng)
 
 	lineItems := make([]LineItem, numLineItems)
+	var subtotalCents int64
 
-	if rand.Float64() <= 0.5 {
+	if rng.Float64() <= 0.5 {
 
 		for i := 0; i < numLineItems; i++ {
-			quantity := rand.Intn(10) + 1
-			randLineItem := rand.Intn(len(printReadyFiles))
+			quantity := profile.quantity(rng)
+			randLineItem := firstGeneratedVariant + rng.Intn(len(printReadyFiles)-firstGeneratedVariant)
 			var properties []Property
 			properties = []Property{
 				{Name: "File Upload", Value: "https://cdn.shopify.com-uploadly.com/?ph_image=e10303d2-3ac9-43b7-8862-441a7b7e7a6e&ph_name=2_1_4_2_9_2_0_8_1___2_9_7_0_1_2_3_2_2_9_9_3_4_9_8_6___1_5_0_9_6_6_8_4_5_5_2_8_0_9_7_0_9_0_7___n&crop=&extension=j=p=e=g&live=true"},
 			}
 
+			lineItemID := lineItemIDBase + orderID*maxLineItems + int64(i)
+			subtotalCents += priceCents * int64(quantity)
 			lineItems[i] = LineItem{
-				ID:                  15573094760617 + orderID + 1,
-				AdminID:             fmt.Sprintf("gid://shopify/LineItem/%d", 15573094760617+orderID+int64(i)),
+				ID:                  lineItemID,
+				AdminID:             fmt.Sprintf("gid://shopify/LineItem/%d", lineItemID),
 				CurrentQuantity:     1,
 				FulfillableQuantity: 1,
 				ProductID:           &productID,
@@ -301,7 +357,7 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 					PresentmentMoney: Money{Amount: price, CurrencyCode: "USD"},
 				},
 				Grams:      0,
-				Properties: properties,
+				Properties: profile.padProperties(properties),
 			}
 		}
 
@@ -309,8 +365,8 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 		atomic.AddInt64(&stats.Type3Count, 1)
 		for i := 0; i < numLineItems; i++ {
 			// random quantity
-			quantity := rand.Intn(10) + 1
-			randLineItem := rand.Intn(len(printReadyFiles))
+			quantity := profile.quantity(rng)
+			randLineItem := firstGeneratedVariant + rng.Intn(len(printReadyFiles)-firstGeneratedVariant)
 
 			var properties []Property
 
@@ -324,9 +380,11 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 				{Name: "Background Removal", Value: "No"},
 			}
 
+			lineItemID := lineItemIDBase + orderID*maxLineItems + int64(i)
+			subtotalCents += priceCents * int64(quantity)
 			lineItems[i] = LineItem{
-				ID:                  15573094760617 + orderID + int64(i),
-				AdminID:             fmt.Sprintf("gid://shopify/LineItem/%d", 15573094760617+orderID+int64(i)),
+				ID:                  lineItemID,
+				AdminID:             fmt.Sprintf("gid://shopify/LineItem/%d", lineItemID),
 				CurrentQuantity:     1,
 				FulfillableQuantity: 1,
 				ProductID:           &productID,
@@ -341,21 +399,24 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 					PresentmentMoney: Money{Amount: price, CurrencyCode: "USD"},
 				},
 				Grams:      0,
-				Properties: properties,
+				Properties: profile.padProperties(properties),
 			}
 		}
 	}
 
+	subtotal := formatCents(subtotalCents)
+	totalPrice := formatCents(subtotalCents + toCents(shippingPrice)*int64(profile.ShippingLines))
+
 	// Get city data
 	city := cityData[cityIdx]
 
-	orderName := fmt.Sprintf("#%f-%s", rand.Float64(), firstNames[numLineItems-1])
+	orderName := fmt.Sprintf("#%f-%s", rng.Float64(), firstNames[(numLineItems-1)%len(firstNames)])
 
 	return ShopifyOrder{
 		ID:                6574664908969 + orderID, // fmt.Sprintf("657466490896%d", orderID),
-		AdminGraphqlAPIID: fmt.Sprintf("gid://shopify/Order/%s", uuid.NewString()),
+		AdminGraphqlAPIID: fmt.Sprintf("gid://shopify/Order/%s", orderUUID(rng)),
 		ContactEmail:      email,
-		CreatedAt:         time.Now().Format(time.RFC3339),
+		CreatedAt:         createdAt.Format(time.RFC3339),
 		Currency:          "USD",
 		CurrentTotalPrice: totalPrice,
 		CurrentTotalPriceSet: PriceSet{
@@ -375,8 +436,8 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 			Country:      "United States",
 			CountryCode:  "US",
 			ProvinceCode: city.state,
-			Latitude:     ptrFloat64(city.latitude + (rand.Float64()-0.5)*0.1),
-			Longitude:    ptrFloat64(city.longitude + (rand.Float64()-0.5)*0.1),
+			Latitude:     ptrFloat64(city.latitude + (rng.Float64()-0.5)*0.1),
+			Longitude:    ptrFloat64(city.longitude + (rng.Float64()-0.5)*0.1),
 		},
 		Customer: ShopifyCustomer{
 			ID:        8909317734569 + orderID,
@@ -407,8 +468,8 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 			Country:      "United States",
 			CountryCode:  "US",
 			ProvinceCode: city.state,
-			Latitude:     ptrFloat64(city.latitude + (rand.Float64()-0.5)*0.1),
-			Longitude:    ptrFloat64(city.longitude + (rand.Float64()-0.5)*0.1),
+			Latitude:     ptrFloat64(city.latitude + (rng.Float64()-0.5)*0.1),
+			Longitude:    ptrFloat64(city.longitude + (rng.Float64()-0.5)*0.1),
 		},
 		TotalLineItemsPrice: subtotal,
 		TotalLineItemsPriceSet: PriceSet{
@@ -424,38 +485,94 @@ func generateOrder(orderID int64, stats *Stats) ShopifyOrder {
 		FinancialStatus:   "paid",
 		FulfillmentStatus: nil,
 		LineItems:         lineItems,
-		ShippingLines: []ShippingLine{
-			{
-				ID:    5468266823849 + orderID,
-				Code:  "Economy",
-				Price: shippingPrice,
-				PriceSet: PriceSet{
-					ShopMoney:        Money{Amount: shippingPrice, CurrencyCode: "USD"},
-					PresentmentMoney: Money{Amount: shippingPrice, CurrencyCode: "USD"},
-				},
-				DiscountedPrice: shippingPrice,
-				DiscountedPriceSet: PriceSet{
-					ShopMoney:        Money{Amount: shippingPrice, CurrencyCode: "USD"},
-					PresentmentMoney: Money{Amount: shippingPrice, CurrencyCode: "USD"},
-				},
-				Source: "shopify",
-				Title:  "Economy",
+		ShippingLines:     generateShippingLines(orderID, profile.ShippingLines, shippingPrice),
+	}
+}
+
+func generateShippingLines(orderID int64, n int, shippingPrice string) []ShippingLine {
+	shippingLines := make([]ShippingLine, n)
+	for i := range shippingLines {
+		shippingLines[i] = ShippingLine{
+			ID:    shippingLineIDBase + orderID*maxLineItems + int64(i),
+			Code:  "Economy",
+			Price: shippingPrice,
+			PriceSet: PriceSet{
+				ShopMoney:        Money{Amount: shippingPrice, CurrencyCode: "USD"},
+				PresentmentMoney: Money{Amount: shippingPrice, CurrencyCode: "USD"},
 			},
-		},
+			DiscountedPrice: shippingPrice,
+			DiscountedPriceSet: PriceSet{
+				ShopMoney:        Money{Amount: shippingPrice, CurrencyCode: "USD"},
+				PresentmentMoney: Money{Amount: shippingPrice, CurrencyCode: "USD"},
+			},
+			Source: "shopify",
+			Title:  "Economy",
+		}
+	}
+	return shippingLines
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// newOrderRand returns the random source for one order. The same seed and
+// order ID always produce the same order, so several targets can be sent an
+// identical stream.
+func newOrderRand(seed, orderID int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed ^ (orderID * 0x5851F42D4C957F2D)))
+}
+
+func orderUUID(rng *rand.Rand) string {
+	id, err := uuid.NewRandomFromReader(rng)
+	if err != nil {
+		return uuid.NewString()
 	}
+	return id.String()
 }
 
 func ptrFloat64(f float64) *float64 {
 	return &f
 }
 
+// toCents converts a price such as "12.34" to cents.
+func toCents(price string) int64 {
+	return int64(math.Round(mustParseFloat(price) * 100))
+}
+
+// formatCents is the inverse of toCents.
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
 func mustParseFloat(s string) float64 {
 	var f float64
 	fmt.Sscanf(s, "%f", &f)
 	return f
 }
 
-func sendWebhook(ctx context.Context, client *http.Client, url string, order ShopifyOrder, stats *Stats) error {
+// newWebhookRequest builds an orders/create webhook POST carrying payload.
+func newWebhookRequest(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shopify-Topic", "orders/create")
+	req.Header.Set("X-Shopify-Hmac-SHA256", "test-signature")
+	req.Header.Set("X-Shopify-Shop-Domain", "dtfgangsheet.myshopify.com")
+	return req, nil
+}
+
+func sendWebhook(ctx context.Context, client *http.Client, opts loadOptions, order ShopifyOrder, scheduled time.Time, stats *Stats) error {
 	payload, err := json.Marshal(order)
 	if err != nil {
 		return err
@@ -466,121 +583,541 @@ func sendWebhook(ctx context.Context, client *http.Client, url string, order Sho
 	//json.Indent(&out, payload, "", "\t")
 	//out.WriteTo(os.Stdout)
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
+	return deliverWebhook(ctx, client, opts, order.OrderNumber, payload, 1, scheduled, stats)
+}
+
+// deliverWebhook posts an encoded order and records the outcome in stats.
+// Failed deliveries are written to opts.DeadLetter with the given attempt
+// number.
+func deliverWebhook(ctx context.Context, client *http.Client, opts loadOptions, orderID int64, payload []byte, attempt int, scheduled time.Time, stats *Stats) (err error) {
+	stats.PayloadBytes.RecordValue(int64(len(payload)))
+
+	// Registered first so deliveries that fail before reaching the wire,
+	// such as an encoding error, are dead-lettered too.
+	var req *http.Request
+	status := 0
+	defer func() {
+		if err == nil || opts.DeadLetter == nil {
+			return
+		}
+		class := classifyError(err)
+		if status != 0 {
+			class = statusClass(status)
+		}
+		var headers map[string]string
+		if req != nil {
+			headers = make(map[string]string, len(req.Header))
+			for name := range req.Header {
+				headers[name] = req.Header.Get(name)
+			}
+		}
+		if dlErr := opts.DeadLetter.Record(DeadLetter{
+			Time:       time.Now(),
+			URL:        opts.URL,
+			OrderID:    orderID,
+			Headers:    headers,
+			Payload:    payload,
+			Status:     status,
+			Error:      err.Error(),
+			ErrorClass: class,
+			Attempts:   attempt,
+		}); dlErr != nil {
+			log.Printf("Failed to write dead letter for order %d: %v", orderID, dlErr)
+		}
+	}()
+
+	body, err := encodeBody(payload, opts.ContentEncoding)
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&stats.RawBytes, int64(len(payload)))
+	atomic.AddInt64(&stats.WireBytes, int64(len(body)))
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Shopify-Topic", "orders/create")
-	req.Header.Set("X-Shopify-Hmac-SHA256", "test-signature")
-	req.Header.Set("X-Shopify-Shop-Domain", "dtfgangsheet.myshopify.com")
+	if req, err = newWebhookRequest(ctx, opts.URL, body); err != nil {
+		return err
+	}
+	if opts.ContentEncoding != "" && opts.ContentEncoding != "identity" {
+		req.Header.Set("Content-Encoding", opts.ContentEncoding)
+	}
+	if opts.AcceptEncoding != "" {
+		// Setting the header ourselves stops the transport from
+		// decompressing, so the bytes counted below are the wire bytes.
+		req.Header.Set("Accept-Encoding", opts.AcceptEncoding)
+	}
 
+	atomic.AddInt64(&stats.InFlight, 1)
 	start := time.Now()
 	resp, err := client.Do(req)
-	duration := time.Since(start).Milliseconds()
+	end := time.Now()
+	atomic.AddInt64(&stats.InFlight, -1)
 
-	atomic.AddInt64(&stats.TotalDuration, duration)
+	if scheduled.IsZero() {
+		scheduled = start
+	}
+	atomic.AddInt64(&stats.TotalDuration, end.Sub(start).Milliseconds())
+	stats.Latency.Record(end.Sub(start))
+	stats.CorrectedLatency.Record(end.Sub(scheduled))
 
 	if err != nil {
 		atomic.AddInt64(&stats.FailedRequests, 1)
+		stats.Errors.Add(classifyError(err))
 		return err
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
 
 	// Drain body so HTTP/2 stream ends cleanly instead of RST_STREAM spam
-	_, _ = io.Copy(io.Discard, resp.Body)
+	respBody := &countingReader{r: resp.Body}
+	_, _ = io.Copy(io.Discard, respBody)
+	atomic.AddInt64(&stats.ResponseBytes, respBody.n)
 
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		atomic.AddInt64(&stats.SuccessRequests, 1)
 	} else {
 		atomic.AddInt64(&stats.FailedRequests, 1)
-		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+		stats.Errors.Add(statusClass(resp.StatusCode))
+		retryAfter, throttled := httpclient.IsThrottled(resp)
+		if throttled {
+			atomic.AddInt64(&stats.Throttled, 1)
+		}
+		return &statusError{StatusCode: resp.StatusCode, Throttled: throttled, RetryAfter: retryAfter}
 	}
 
 	return nil
 }
 
-// const url = "https://dtf-api-dedicated.daovudat.site/webhooks/test/orders/create"
-// const url = "http://localhost:8000/webhooks/test/orders/create"
+// url is used when no environments.json is found. Other targets are
+// selected with -env, see environments.example.json.
+const url = "https://bgs.daovudat.site/webhooks/test/orders/create"
 
-//const url = "https://dtf-api.daovudat.site/webhooks/test/orders/create"
+// loadOptions describes one load run against a single target.
+type loadOptions struct {
+	URL         string
+	Total       int
+	Rate        float64 // requests per second, 0 = unlimited
+	Burst       int
+	Concurrency int
+	Open        bool
+	MaxInFlight int
+	Profile     OrderProfile
+	Seed        int64
+
+	ContentEncoding string // identity, gzip or deflate
+	AcceptEncoding  string // sent as Accept-Encoding when not empty
+
+	// DeadLetter receives every failed delivery; nil disables it.
+	DeadLetter *DeadLetterFile
+
+	// Backpressure pauses and slows the generator when the backend
+	// answers 429 with Retry-After.
+	Backpressure bool
+
+	// Replay, when set, replaces the fixed rate with the arrival pattern
+	// of a historical export; Total is its number of orders.
+	Replay *replaySchedule
+
+	// Until stops order generation at this time when not zero; orders
+	// already sent are still awaited.
+	Until time.Time
+}
 
-//const url = "https://dtf-api4gb-asia.daovudat.site/webhooks/test/orders/create"
+// expired reports whether the run has passed opts.Until.
+func (o loadOptions) expired() bool {
+	return !o.Until.IsZero() && time.Now().After(o.Until)
+}
 
-const url = "https://bgs.daovudat.site/webhooks/test/orders/create"
+// instrument wraps transport in the soak in-flight counter and then the
+// HAR sampler, each when it is set, so both see every request.
+func instrument(transport *http.Transport, soakMonitor *soak.Monitor, sampler *har.Sampler) http.RoundTripper {
+	var rt http.RoundTripper = transport
+	if soakMonitor != nil {
+		soakMonitor.Track(transport)
+		rt = soakMonitor.Transport(rt)
+	}
+	if sampler != nil {
+		rt = sampler.Transport(rt)
+	}
+	return rt
+}
+
+// newTransport builds the HTTP transport used for webhook deliveries,
+// applying the environment's TLS settings when env is not nil.
+func newTransport(env *config.Environment, opts httpclient.Options) (*http.Transport, error) {
+	var base *tls.Config
+	if env != nil {
+		var err error
+		if base, err = env.TLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	return httpclient.NewTransport(opts, base)
+}
+
+// loadEnvironment loads the environment config and selects envName from
+// it, or lists the environments when list is set. Without a config file
+// both are nil, unless an environment was asked for.
+func loadEnvironment(configPath, envName string, list bool) (*config.File, *config.Environment, error) {
+	path := config.Find(configPath)
+	if path == "" {
+		if list || envName != "" {
+			return nil, nil, fmt.Errorf("no %s found (copy %s and fill in the passwords)", config.DefaultFile, config.ExampleFile)
+		}
+		return nil, nil, nil
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load environments: %w", err)
+	}
+	if list {
+		cfg.List(os.Stdout, "send_webhook")
+		return cfg, nil, nil
+	}
+	env, err := cfg.Select(envName, "send_webhook")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select environment: %w", err)
+	}
+	return cfg, env, nil
+}
+
+// backOff pauses limiter for the Retry-After of a throttled delivery when
+// opts.Backpressure is on.
+func backOff(limiter *RateLimiter, opts loadOptions, err error) {
+	var se *statusError
+	if opts.Backpressure && errors.As(err, &se) && se.Throttled {
+		limiter.Throttle(se.RetryAfter)
+	}
+}
+
+// runLoad sends opts.Total orders to opts.URL paced by limiter, or by
+// opts.Replay, and blocks until every request has completed. Cancelling ctx
+// stops new orders; requests already sent are allowed to finish.
+func runLoad(ctx context.Context, client *http.Client, opts loadOptions, limiter *RateLimiter, stats *Stats) {
+	var wg sync.WaitGroup
+	sendCtx := context.WithoutCancel(ctx)
+
+	wait := limiter.Wait
+	if opts.Replay != nil {
+		wait = opts.Replay.pacer(limiter)
+	}
+
+	send := func(workerID int, job orderJob) {
+		var order ShopifyOrder
+		if opts.Replay != nil {
+			order = opts.Replay.order(opts, job.OrderID, job.Scheduled, stats)
+		} else {
+			order = generateOrder(newOrderRand(opts.Seed, job.OrderID), job.OrderID, job.Scheduled, opts.Profile, stats)
+		}
+
+		err := sendWebhook(sendCtx, client, opts, order, job.Scheduled, stats)
+		atomic.AddInt64(&stats.TotalRequests, 1)
+
+		if err != nil {
+			log.Printf("Worker %d: Error sending order %d: %v", workerID, job.OrderID, err)
+		}
+
+		backOff(limiter, opts, err)
+	}
+
+	if opts.Open {
+		// Every order gets its own goroutine so a slow backend cannot hold
+		// back the arrival rate; only the in-flight cap can.
+		inFlight := make(chan struct{}, opts.MaxInFlight)
+
+		for i := int64(1); i <= int64(opts.Total) && !opts.expired(); i++ {
+			scheduled, err := wait(ctx)
+			if err != nil {
+				break
+			}
+
+			select {
+			case inFlight <- struct{}{}:
+			default:
+				atomic.AddInt64(&stats.CapWaits, 1)
+				select {
+				case <-ctx.Done():
+				case inFlight <- struct{}{}:
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+
+			wg.Add(1)
+			go func(job orderJob) {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				send(int(job.OrderID), job)
+			}(orderJob{OrderID: i, Scheduled: scheduled})
+		}
+	} else {
+		orderChan := make(chan orderJob, opts.Concurrency*2)
+
+		// Start workers
+		for i := 0; i < opts.Concurrency; i++ {
+			wg.Add(1)
+			go func(workerID int) {
+				defer wg.Done()
+				for job := range orderChan {
+					send(workerID, job)
+				}
+			}(i)
+		}
+
+		func() {
+			defer close(orderChan)
+			for i := int64(1); i <= int64(opts.Total) && !opts.expired(); i++ {
+				scheduled, err := wait(ctx)
+				if err != nil {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case orderChan <- orderJob{OrderID: i, Scheduled: scheduled}:
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "resend" {
+		runResend(os.Args[2:])
+		return
+	}
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run is the load test itself. It returns errors instead of exiting so the
+// deferred HAR, trace and dead-letter flushes always run.
+func run() error {
 	webhookURL := flag.String("url", url, "Webhook endpoint URL")
 	totalOrders := flag.Int("total", 100, "Total number of orders to send")
-	ratePerMinute := flag.Int("rate", 100, "Number of requests per minute")
+	ratePerMinute := flag.Float64("rate", 100, "Number of requests per minute (fractional allowed, 0 = unlimited)")
+	ratePerSecond := flag.Float64("rps", 0, "Number of requests per second (overrides -rate when > 0)")
+	burst := flag.Int("burst", 1, "Maximum number of requests released at once when catching up")
 	_ = flag.Int("duration", 60, "Duration in minutes (0 = send all at configured rate)")
 	concurrency := flag.Int("concurrency", 10, "Number of concurrent workers")
+	openModel := flag.Bool("open", false, "Open-model load: dispatch every order at its scheduled time regardless of outstanding responses")
+	maxInFlight := flag.Int("max-inflight", 1000, "Safety cap on outstanding requests in open-model mode")
+	showTUI := flag.Bool("tui", false, "Show a live full-screen dashboard instead of periodic log lines")
+	profileName := flag.String("profile", "default", "Order shape: "+strings.Join(profileNames(), ", "))
+	fuzz := flag.Bool("fuzz", false, "Send malformed and adversarial payloads and report which ones the server fails on")
+	fuzzMutations := flag.String("fuzz-mutations", "", "Comma separated mutations to use in fuzz mode (default all)")
+	fuzzTimeout := flag.Duration("fuzz-timeout", 30*time.Second, "Time after which a fuzzed request counts as a hang")
+	envName := flag.String("env", "", "Target environment from the config file (default $"+config.EnvName+" or the configured default)")
+	configPath := flag.String("config", "", "Environment config file (default $"+config.EnvFile+" or "+config.DefaultFile+")")
+	listEnvs := flag.Bool("list-envs", false, "List the configured environments and exit")
+	seed := flag.Int64("seed", 0, "Seed for order generation; the same seed produces the same orders (default time based)")
+	compare := flag.String("compare", "", "Comma separated environments or URLs to send the identical order stream to and compare")
+	contentEncoding := flag.String("content-encoding", "identity", "Compress webhook bodies: "+strings.Join(bodyEncodings, ", "))
+	acceptEncoding := flag.String("accept-encoding", "", "Accept-Encoding to request compressed responses, e.g. gzip (counts wire bytes)")
+	deadLetterPath := flag.String("dead-letter", "dead_letter.jsonl", "File failed deliveries are appended to (empty disables); see 'send_webhook resend'")
+	backpressure := flag.Bool("backpressure", true, "Pause and slow down the generator when the backend answers 429 / Retry-After")
+	flag.DurationVar(&httpclient.MaxRetryAfter, "max-retry-after", httpclient.MaxRetryAfter, "Longest Retry-After pause honoured; longer ones are capped")
+	harPath := flag.String("har", "", "Write slow and failed request/response pairs to this HAR file")
+	harThreshold := flag.Duration("har-threshold", 2*time.Second, "Latency above which a successful request is captured to the HAR file")
+	harBudget := flag.Int("har-budget", 100, "Maximum number of requests captured to the HAR file")
+	soakDuration := flag.Duration("soak", 0, "Soak test: send for this long (ignoring -total unless set) while sampling client goroutines, heap, fds and connections for leaks")
+	soakInterval := flag.Duration("soak-interval", 30*time.Second, "How often to sample resource usage in soak mode")
+	soakFile := flag.String("soak-file", "", "Write the soak samples to this CSV file")
+	replayPath := flag.String("replay", "", "Reproduce the arrival pattern of historical orders from a CSV or JSONL file of creation timestamps (optional line_items and variants)")
+	replaySpeed := flag.Float64("replay-speed", 1, "Time compression for -replay, e.g. 60 plays an hour of orders in a minute")
+	compareSequential := flag.Bool("compare-sequential", false, "Run comparison targets one after another instead of concurrently")
+	transportOpts := httpclient.Options{
+		MaxConnsPerHost:     100,
+		MaxIdleConnsPerHost: 100,
+	}
+	transportOpts.RegisterFlags(flag.CommandLine)
+	var traceOpts tracing.Options
+	traceOpts.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	cfg, env, err := loadEnvironment(*configPath, *envName, *listEnvs)
+	if err != nil || *listEnvs {
+		return err
+	}
+
+	if env != nil {
+		if !config.IsFlagSet("url") {
+			*webhookURL = env.WebhookURL()
+		}
+		if env.RatePerMinute > 0 && !config.IsFlagSet("rate") {
+			*ratePerMinute = env.RatePerMinute
+		}
+		if env.Concurrency > 0 && !config.IsFlagSet("concurrency") {
+			*concurrency = env.Concurrency
+		}
+	}
+
 	log.Printf("Starting webhook load test...")
+	if env != nil {
+		log.Printf("Environment: %s", env.Name)
+	}
 	log.Printf("Target URL: %s", *webhookURL)
-	log.Printf("Total Orders: %d", *totalOrders)
-	log.Printf("Rate: %d req/min", *ratePerMinute)
-	log.Printf("Concurrency: %d", *concurrency)
-
-	rand.Seed(time.Now().UnixNano())
+	if *replayPath == "" {
+		log.Printf("Total Orders: %d", *totalOrders)
+	}
 
-	stats := &Stats{}
-	transport := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		MaxConnsPerHost:     100,
-		IdleConnTimeout:     90 * time.Second,
-		ForceAttemptHTTP2:   true, // keep HTTP/2, but better managed
+	profile, err := lookupProfile(*profileName)
+	if err != nil {
+		return err
+	}
+	log.Printf("Order Profile: %s (%s)", profile.Name, profile.Description)
+	if _, err := encodeBody(nil, *contentEncoding); err != nil {
+		return err
+	}
+	if *contentEncoding != "identity" {
+		log.Printf("Content-Encoding: %s", *contentEncoding)
+	}
+	rate := *ratePerMinute / 60
+	if *ratePerSecond > 0 {
+		rate = *ratePerSecond
 	}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   3 * time.Minute,
+	var replay *replaySchedule
+	if *replayPath != "" {
+		if *replaySpeed <= 0 {
+			return errors.New("-replay-speed must be positive")
+		}
+		records, err := loadReplay(*replayPath)
+		if err != nil {
+			return fmt.Errorf("failed to load replay: %w", err)
+		}
+		replay = &replaySchedule{Records: records, Speed: *replaySpeed}
+		*totalOrders = len(records)
+		// The recorded timestamps pace the run; the limiter only applies
+		// backpressure pauses.
+		rate = 0
+		log.Printf("Replay: %d orders from %s, %v at %gx speed (peak %d orders/min in the original)",
+			len(records), *replayPath, replay.Span().Round(time.Second), *replaySpeed, replay.Peak(time.Minute))
+	} else if rate > 0 {
+		log.Printf("Rate: %.3f req/s (%.2f req/min), burst %d", rate, rate*60, *burst)
+	} else {
+		log.Printf("Rate: unlimited")
+	}
+	if *openModel {
+		log.Printf("Load model: open (max in-flight %d)", *maxInFlight)
+	} else {
+		log.Printf("Concurrency: %d", *concurrency)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("Seed: %d", *seed)
 
-	orderChan := make(chan int64, *concurrency*2)
-	var wg sync.WaitGroup
+	opts := loadOptions{
+		URL:         *webhookURL,
+		Total:       *totalOrders,
+		Rate:        rate,
+		Burst:       *burst,
+		Concurrency: *concurrency,
+		Open:        *openModel,
+		MaxInFlight: *maxInFlight,
+		Profile:     profile,
+		Seed:        *seed,
 
-	// Start workers
-	for i := 0; i < *concurrency; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			for orderID := range orderChan {
+		ContentEncoding: *contentEncoding,
+		AcceptEncoding:  *acceptEncoding,
 
-				order := generateOrder(orderID, stats)
+		Backpressure: *backpressure,
 
-				err := sendWebhook(ctx, client, *webhookURL, order, stats)
-				atomic.AddInt64(&stats.TotalRequests, 1)
+		Replay: replay,
+	}
 
-				if err != nil {
-					log.Printf("Worker %d: Error sending order %d: %v", workerID, orderID, err)
-				}
-			}
-		}(i)
+	if *soakDuration > 0 {
+		opts.Until = time.Now().Add(*soakDuration)
+		if !config.IsFlagSet("total") {
+			opts.Total = math.MaxInt32
+		}
+		log.Printf("Soak: running for %v, sampling every %v", *soakDuration, *soakInterval)
 	}
 
-	// Generate orders at specified rate
-	startTime := time.Now()
-	ticker := time.NewTicker(time.Minute / time.Duration(*ratePerMinute))
-	defer ticker.Stop()
+	if *deadLetterPath != "" && !*fuzz {
+		deadLetter, err := openDeadLetterFile(*deadLetterPath)
+		if err != nil {
+			return fmt.Errorf("failed to open dead-letter file: %w", err)
+		}
+		defer deadLetter.Close()
+		opts.DeadLetter = deadLetter
+		log.Printf("Dead-letter file: %s", *deadLetterPath)
+	}
 
+	// SIGINT and SIGTERM stop generating orders; the run then ends as usual
+	// with the final report and the file flushes. A second signal kills it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	go func() {
-		for i := int64(1); i <= int64(*totalOrders); i++ {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				orderChan <- i
+		<-ctx.Done()
+		stop()
+	}()
+
+	if *compare != "" {
+		targets, err := resolveTargets(cfg, *compare, transportOpts)
+		if err != nil {
+			return fmt.Errorf("failed to resolve comparison targets: %w", err)
+		}
+		runCompare(ctx, targets, opts, *compareSequential)
+		return nil
+	}
+
+	stats := &Stats{}
+	transport, err := newTransport(env, transportOpts)
+	if err != nil {
+		return fmt.Errorf("failed to configure transport: %w", err)
+	}
+
+	var soakMonitor *soak.Monitor
+	if *soakDuration > 0 {
+		soakMonitor = soak.NewMonitor(*soakInterval)
+	}
+	var sampler *har.Sampler
+	if *harPath != "" {
+		sampler = har.NewSampler("send_webhook", *harThreshold, *harBudget)
+		log.Printf("HAR capture: %s (failed or slower than %v, up to %d)", *harPath, *harThreshold, *harBudget)
+	}
+	roundTripper := instrument(transport, soakMonitor, sampler)
+
+	tracer, err := tracing.New("send_webhook", traceOpts)
+	if err != nil {
+		return fmt.Errorf("failed to configure tracing: %w", err)
+	}
+	if tracer != nil {
+		// Outermost, so the HAR file shows the injected traceparent.
+		roundTripper = tracer.Transport(roundTripper)
+		defer shutdownTracer(tracer)
+	}
+
+	client := &http.Client{
+		Transport: roundTripper,
+		Timeout:   3 * time.Minute,
+	}
+	if sampler != nil {
+		defer writeHAR(sampler, *harPath)
+	}
+
+	if *fuzz {
+		muts, err := selectMutations(*fuzzMutations)
+		if err != nil {
+			names := make([]string, len(mutations))
+			for i, m := range mutations {
+				names[i] = m.Name
 			}
+			return fmt.Errorf("%v (available: %s)", err, strings.Join(names, ", "))
 		}
-		close(orderChan)
-	}()
+		log.Printf("Fuzz mode: %d mutations", len(muts))
+		runFuzz(ctx, client, opts, muts, newRateLimiter(rate, *burst), *fuzzTimeout)
+		return nil
+	}
+
+	// Generate orders at specified rate
+	startTime := time.Now()
+	limiter := newRateLimiter(rate, *burst)
 
 	// Stats reporter
 	statsTicker := time.NewTicker(10 * time.Second)
@@ -607,10 +1144,54 @@ func main() {
 
 			log.Printf("Stats: Total=%d, Success=%d, Failed=%d, RPS=%.2f, AvgLatency=%dms | Type1=%d, Type2=%d, Type3=%d",
 				total, success, failed, rps, avgLatency, type1, type2, type3)
+
+			log.Printf("Latency: p50=%v p90=%v p99=%v | Corrected: p50=%v p90=%v p99=%v | InFlight=%d",
+				stats.Latency.Percentile(50), stats.Latency.Percentile(90), stats.Latency.Percentile(99),
+				stats.CorrectedLatency.Percentile(50), stats.CorrectedLatency.Percentile(90), stats.CorrectedLatency.Percentile(99),
+				atomic.LoadInt64(&stats.InFlight))
+			log.Printf("Payload: p50=%s p99=%s max=%s",
+				formatBytes(stats.PayloadBytes.PercentileValue(50)), formatBytes(stats.PayloadBytes.PercentileValue(99)),
+				formatBytes(stats.PayloadBytes.MaxValue()))
+
+			if throttledFor, events := limiter.Throttled(); events > 0 {
+				log.Printf("Throttled: %d responses, paused %v, permitted rate %.2f req/s",
+					events, throttledFor.Round(time.Millisecond), limiter.CurrentRate())
+			}
+
+			if lag, missed := limiter.Behind(); missed > 0 {
+				log.Printf("Generator behind target rate by %v (%d orders overdue)", lag.Round(time.Millisecond), missed)
+			}
 		}
 	}()
 
-	wg.Wait()
+	var screen *tui.Screen
+	screenDone := make(chan struct{})
+	screenStopped := make(chan struct{})
+	if *showTUI {
+		screen = tui.NewScreen(200)
+		screen.Start()
+		defer screen.Stop()
+		dash := newDashboard(stats, limiter, *webhookURL, startTime)
+		go func() {
+			defer close(screenStopped)
+			screen.Run(time.Second, screenDone, dash.frame)
+		}()
+	}
+
+	if soakMonitor != nil {
+		soakMonitor.Start()
+	}
+
+	runLoad(ctx, client, opts, limiter, stats)
+	if ctx.Err() != nil {
+		log.Printf("Interrupted, stopped sending orders")
+	}
+
+	if screen != nil {
+		close(screenDone)
+		<-screenStopped
+		screen.Stop()
+	}
 
 	// Final stats
 	elapsed := time.Since(startTime)
@@ -636,7 +1217,67 @@ func main() {
 	log.Printf("Success Rate: %.2f%%", float64(success)/float64(total)*100)
 	log.Printf("Average RPS: %.2f", float64(total)/elapsed.Seconds())
 	log.Printf("Average Latency: %dms", avgLatency)
+	log.Printf("Latency (from send):      p50=%v p90=%v p99=%v max=%v",
+		stats.Latency.Percentile(50), stats.Latency.Percentile(90), stats.Latency.Percentile(99), stats.Latency.Max())
+	log.Printf("Latency (from schedule):  p50=%v p90=%v p99=%v max=%v",
+		stats.CorrectedLatency.Percentile(50), stats.CorrectedLatency.Percentile(90), stats.CorrectedLatency.Percentile(99), stats.CorrectedLatency.Max())
+	log.Printf("Payload Size: p50=%s p90=%s p99=%s max=%s",
+		formatBytes(stats.PayloadBytes.PercentileValue(50)), formatBytes(stats.PayloadBytes.PercentileValue(90)),
+		formatBytes(stats.PayloadBytes.PercentileValue(99)), formatBytes(stats.PayloadBytes.MaxValue()))
+	rawBytes := atomic.LoadInt64(&stats.RawBytes)
+	wireBytes := atomic.LoadInt64(&stats.WireBytes)
+	if rawBytes > 0 && *contentEncoding != "identity" {
+		log.Printf("Request Bodies (%s): %s -> %s (saved %.1f%%)", *contentEncoding,
+			formatBytes(rawBytes), formatBytes(wireBytes), (1-float64(wireBytes)/float64(rawBytes))*100)
+	} else {
+		log.Printf("Request Bodies: %s", formatBytes(wireBytes))
+	}
+	log.Printf("Response Bodies: %s", formatBytes(atomic.LoadInt64(&stats.ResponseBytes)))
+	if n := opts.DeadLetter.Count(); n > 0 {
+		log.Printf("Dead Letters Written: %d (%s)", n, *deadLetterPath)
+	}
+	log.Printf("Max Generator Lag: %v", limiter.MaxLag().Round(time.Millisecond))
+	throttledFor, _ := limiter.Throttled()
+	log.Printf("Throttled Responses: %d", atomic.LoadInt64(&stats.Throttled))
+	log.Printf("Time Throttled: %v", throttledFor.Round(time.Millisecond))
+	log.Printf("Effective Rate Permitted: %.2f req/s (target %.2f req/s)", float64(success)/elapsed.Seconds(), rate)
+	if *openModel {
+		log.Printf("In-flight Cap Waits: %d", atomic.LoadInt64(&stats.CapWaits))
+	}
+	for _, e := range stats.Errors.Sorted() {
+		log.Printf("Errors %s: %d", e.Class, e.Count)
+	}
 	log.Printf("Type1 (Shopify CDN): %d", type1)
 	log.Printf("Type2 (Invalid Upload): %d", type2)
 	log.Printf("Type3 (Print Ready): %d", type3)
+
+	if soakMonitor != nil {
+		soakMonitor.Stop()
+		soakMonitor.Report()
+		if *soakFile != "" {
+			if err := soakMonitor.WriteCSV(*soakFile); err != nil {
+				log.Printf("Failed to write %s: %v", *soakFile, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeHAR saves the sampled request/response pairs.
+func writeHAR(sampler *har.Sampler, path string) {
+	captured, dropped := sampler.Len()
+	if err := sampler.WriteFile(path); err != nil {
+		log.Printf("Failed to write %s: %v", path, err)
+		return
+	}
+	log.Printf("HAR: %d requests captured to %s (%d over budget)", captured, path, dropped)
+}
+
+// shutdownTracer exports the remaining spans.
+func shutdownTracer(tracer *tracing.Tracer) {
+	spans, dropped, err := tracer.Shutdown()
+	if err != nil {
+		log.Printf("Failed to export spans: %v", err)
+	}
+	log.Printf("Traces: %d client spans recorded (%d dropped)", spans, dropped)
 }
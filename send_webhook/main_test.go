@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test_webhook_service/samples"
+)
+
+// The samples process_image generates for skipped stages must be the ones
+// orders point at.
+func TestPrintReadyFilesAreSamples(t *testing.T) {
+	if len(printReadyFiles) != len(samples.Sheets) || len(variants) != len(samples.Sheets) {
+		t.Fatalf("%d print-ready files and %d variants, want %d samples", len(printReadyFiles), len(variants), len(samples.Sheets))
+	}
+	for i, sheet := range samples.Sheets {
+		if got := path.Base(printReadyFiles[i]); got != sheet.ImageName() {
+			t.Errorf("printReadyFiles[%d] = %s, want %s", i, got, sheet.ImageName())
+		}
+		if want := fmt.Sprintf("%dx%d", samples.Width, sheet.Height); variants[i] != want {
+			t.Errorf("variants[%d] = %s, want %s", i, variants[i], want)
+		}
+	}
+}
+
+func TestGenerateOrderLineItemIDs(t *testing.T) {
+	for _, name := range profileNames() {
+		t.Run(name, func(t *testing.T) {
+			profile, err := lookupProfile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[int64]int64)
+			shipping := make(map[int64]int64)
+			for orderID := int64(1); orderID <= 5; orderID++ {
+				order := generateOrder(newOrderRand(42, orderID), orderID, time.Now(), profile, &Stats{})
+				for _, item := range order.LineItems {
+					if prev, ok := seen[item.ID]; ok {
+						t.Fatalf("line item ID %d of order %d already used by order %d", item.ID, orderID, prev)
+					}
+					seen[item.ID] = orderID
+				}
+				for _, line := range order.ShippingLines {
+					if prev, ok := shipping[line.ID]; ok {
+						t.Fatalf("shipping line ID %d of order %d already used by order %d", line.ID, orderID, prev)
+					}
+					shipping[line.ID] = orderID
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateOrderTotals(t *testing.T) {
+	for _, name := range profileNames() {
+		t.Run(name, func(t *testing.T) {
+			profile, err := lookupProfile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for orderID := int64(1); orderID <= 5; orderID++ {
+				order := generateOrder(newOrderRand(7, orderID), orderID, time.Now(), profile, &Stats{})
+
+				var subtotal int64
+				for _, item := range order.LineItems {
+					subtotal += toCents(item.Price) * int64(item.Quantity)
+				}
+				var shipping int64
+				for _, line := range order.ShippingLines {
+					shipping += toCents(line.Price)
+				}
+
+				if got := toCents(order.SubtotalPrice); got != subtotal {
+					t.Errorf("order %d subtotal_price = %s, want %s", orderID, order.SubtotalPrice, formatCents(subtotal))
+				}
+				if order.TotalLineItemsPrice != order.SubtotalPrice {
+					t.Errorf("order %d total_line_items_price = %s, want %s", orderID, order.TotalLineItemsPrice, order.SubtotalPrice)
+				}
+				if got := toCents(order.CurrentTotalPrice); got != subtotal+shipping {
+					t.Errorf("order %d current_total_price = %s, want %s", orderID, order.CurrentTotalPrice, formatCents(subtotal+shipping))
+				}
+			}
+		})
+	}
+}
+
+func TestFormatCents(t *testing.T) {
+	tests := []struct {
+		cents int64
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{490, "4.90"},
+		{1234567, "12345.67"},
+	}
+	for _, tt := range tests {
+		if got := formatCents(tt.cents); got != tt.want {
+			t.Errorf("formatCents(%d) = %q, want %q", tt.cents, got, tt.want)
+		}
+		if got := toCents(tt.want); got != tt.cents {
+			t.Errorf("toCents(%q) = %d, want %d", tt.want, got, tt.cents)
+		}
+	}
+}
+
+func TestGenerateOrderDeterministic(t *testing.T) {
+	profile, err := lookupProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduled := time.Date(2024, 11, 29, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		seed      int64
+		orderID   int64
+		createdAt time.Time
+		same      bool
+	}{
+		{"same seed and schedule", 1, 3, scheduled, true},
+		{"other schedule", 1, 3, scheduled.Add(time.Minute), false},
+		{"other seed", 2, 3, scheduled, false},
+	}
+	want, err := json.Marshal(generateOrder(newOrderRand(1, 3), 3, scheduled, profile, &Stats{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := generateOrder(newOrderRand(tt.seed, tt.orderID), tt.orderID, tt.createdAt, profile, &Stats{})
+			if order.CreatedAt != tt.createdAt.Format(time.RFC3339) {
+				t.Errorf("created_at = %s, want %s", order.CreatedAt, tt.createdAt.Format(time.RFC3339))
+			}
+			got, err := json.Marshal(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := bytes.Equal(got, want); same != tt.same {
+				t.Errorf("payload identical = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestDeliverWebhookDeadLettersEncodeFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	dl, err := openDeadLetterFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := loadOptions{URL: "http://127.0.0.1:1/webhook", ContentEncoding: "br", DeadLetter: dl}
+	payload := []byte(`{"id":7}`)
+
+	sendErr := deliverWebhook(context.Background(), http.DefaultClient, opts, 7, payload, 1, time.Time{}, &Stats{})
+	if sendErr == nil {
+		t.Fatal("deliverWebhook() succeeded with an unknown content encoding")
+	}
+	if err := dl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("wrote %d dead letters, want 1", len(entries))
+	}
+	if e := entries[0]; e.OrderID != 7 || !bytes.Equal(e.Payload, payload) || e.Error != sendErr.Error() {
+		t.Errorf("dead letter = %+v, want order 7 with its payload and error", e)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// OrderProfile shapes the orders produced by generateOrder.
+type OrderProfile struct {
+	Name        string
+	Description string
+
+	MinLineItems int
+	MaxLineItems int
+	MaxQuantity  int
+
+	// ExtraProperties adds that many filler properties to every line item
+	// and PropertyValueBytes pads each filler value to the given length.
+	ExtraProperties    int
+	PropertyValueBytes int
+
+	ShippingLines int
+}
+
+var orderProfiles = map[string]OrderProfile{
+	"default": {
+		Description:  "1-2 line items, quantity up to 10 (storefront orders)",
+		MinLineItems: 1, MaxLineItems: 2, MaxQuantity: 10,
+		ShippingLines: 1,
+	},
+	"bulk": {
+		Description:  "B2B order with 100-500 line items, quantity up to 500",
+		MinLineItems: 100, MaxLineItems: 500, MaxQuantity: 500,
+		ShippingLines: 3,
+	},
+	"large-quantity": {
+		Description:  "1-5 line items with quantities up to 100000",
+		MinLineItems: 1, MaxLineItems: 5, MaxQuantity: 100000,
+		ShippingLines: 1,
+	},
+	"long-properties": {
+		Description:  "5-20 line items, 50 extra properties of 4KB each",
+		MinLineItems: 5, MaxLineItems: 20, MaxQuantity: 10,
+		ExtraProperties: 50, PropertyValueBytes: 4096,
+		ShippingLines: 1,
+	},
+	"many-shipping": {
+		Description:  "10-50 line items split over 50 shipping lines",
+		MinLineItems: 10, MaxLineItems: 50, MaxQuantity: 50,
+		ShippingLines: 50,
+	},
+	"heavy": {
+		Description:  "all of the above: 500-1000 line items, long properties, 100 shipping lines",
+		MinLineItems: 500, MaxLineItems: 1000, MaxQuantity: 1000,
+		ExtraProperties: 10, PropertyValueBytes: 1024,
+		ShippingLines: 100,
+	},
+}
+
+// lookupProfile returns the named profile.
+func lookupProfile(name string) (OrderProfile, error) {
+	p, ok := orderProfiles[name]
+	if !ok {
+		return OrderProfile{}, fmt.Errorf("unknown order profile %q (available: %s)", name, strings.Join(profileNames(), ", "))
+	}
+	p.Name = name
+	return p, nil
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(orderProfiles))
+	for name := range orderProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p OrderProfile) lineItemCount(rng *rand.Rand) int {
+	if p.MaxLineItems <= p.MinLineItems {
+		return p.MinLineItems
+	}
+	return p.MinLineItems + rng.Intn(p.MaxLineItems-p.MinLineItems+1)
+}
+
+func (p OrderProfile) quantity(rng *rand.Rand) int {
+	return rng.Intn(p.MaxQuantity) + 1
+}
+
+// padProperties appends the profile's filler properties.
+func (p OrderProfile) padProperties(properties []Property) []Property {
+	for i := 0; i < p.ExtraProperties; i++ {
+		properties = append(properties, Property{
+			Name:  fmt.Sprintf("_Layer %d", i+1),
+			Value: strings.Repeat("x", p.PropertyValueBytes),
+		})
+	}
+	return properties
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// minRateFactor bounds how far repeated 429s can slow the generator.
+	minRateFactor = 1.0 / 64
+	// rateRecoveryInterval is how long the backend must stay quiet before
+	// the rate is raised again after a 429.
+	rateRecoveryInterval = 5 * time.Second
+)
+
+// RateLimiter is a token bucket that paces order generation.
+//
+// Besides handing out tokens it keeps the ideal fixed-rate schedule
+// (start + n/rate) so that it can report how far the generator itself has
+// fallen behind the target when the workers cannot keep up. Those intended
+// times are what coordinated-omission aware latency is measured against.
+//
+// When the backend answers 429, Throttle pauses the limiter for the
+// Retry-After period and halves the rate; the rate then recovers gradually.
+// A pause does not move the schedule, so the requests held back by it keep
+// their intended times and the throttled time shows up in the corrected
+// latency. A rate change continues the schedule from the next intended
+// time at the new rate. Paused time is left out of the generator lag only.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // target tokens per second, 0 = unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+	start  time.Time
+	base   int64 // issued when the current schedule started
+	issued int64
+	maxLag time.Duration
+	paused time.Duration // time paused that is not generator lag
+
+	factor         float64 // share of rate currently permitted
+	lastAdjust     time.Time
+	pausedUntil    time.Time
+	throttled      time.Duration
+	throttleEvents int64
+}
+
+// newRateLimiter builds a limiter issuing perSecond tokens per second with
+// the given burst size. A rate <= 0 disables limiting.
+func newRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   perSecond,
+		burst:  float64(burst),
+		factor: 1,
+	}
+}
+
+// Wait blocks until a token is available and returns the time at which the
+// request was scheduled to be sent according to the target rate.
+func (l *RateLimiter) Wait(ctx context.Context) (time.Time, error) {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		if l.start.IsZero() {
+			l.start = now
+			l.last = now
+			l.lastAdjust = now
+			l.tokens = l.burst
+		}
+
+		if now.Before(l.pausedUntil) {
+			pause := l.pausedUntil.Sub(now)
+			l.mu.Unlock()
+			if err := sleepContext(ctx, pause); err != nil {
+				return now, err
+			}
+			continue
+		}
+
+		if l.rate <= 0 {
+			l.issued++
+			l.mu.Unlock()
+			return now, ctx.Err()
+		}
+
+		l.recover(now)
+		rate := l.rate * l.factor
+
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+		l.last = now
+		l.tokens--
+
+		var wait time.Duration
+		if l.tokens < 0 {
+			wait = time.Duration(-l.tokens / rate * float64(time.Second))
+		}
+		intended := l.next(rate)
+		paused := l.paused
+		l.issued++
+		l.mu.Unlock()
+
+		if wait > 0 {
+			if err := sleepContext(ctx, wait); err != nil {
+				l.mu.Lock()
+				l.tokens++
+				l.issued--
+				l.mu.Unlock()
+				return intended, err
+			}
+		}
+
+		if lag := time.Since(intended) - paused; lag > 0 {
+			l.mu.Lock()
+			if lag > l.maxLag {
+				l.maxLag = lag
+			}
+			l.mu.Unlock()
+		}
+
+		return intended, nil
+	}
+}
+
+// Throttle pauses the limiter for d and, at most once per second, halves
+// the permitted rate.
+func (l *RateLimiter) Throttle(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	until := now.Add(d)
+	l.throttleEvents++
+
+	if until.After(l.pausedUntil) {
+		added := d
+		if l.pausedUntil.After(now) {
+			added = until.Sub(l.pausedUntil)
+		}
+		l.throttled += added
+		l.paused += added
+		l.pausedUntil = until
+	}
+
+	if l.rate > 0 && now.Sub(l.lastAdjust) >= time.Second {
+		l.setFactor(math.Max(minRateFactor, l.factor/2))
+		l.lastAdjust = now
+	}
+
+	// No tokens accumulate while paused.
+	l.tokens = 0
+	l.last = l.pausedUntil
+}
+
+// recover raises the permitted rate again once the backend has stopped
+// throttling. Callers hold l.mu.
+func (l *RateLimiter) recover(now time.Time) {
+	if l.factor >= 1 || now.Sub(l.lastAdjust) < rateRecoveryInterval {
+		return
+	}
+	l.setFactor(math.Min(1, l.factor*1.25))
+	l.lastAdjust = now
+}
+
+// next returns the intended time of the next request at rate. Callers hold
+// l.mu.
+func (l *RateLimiter) next(rate float64) time.Time {
+	return l.start.Add(time.Duration(float64(l.issued-l.base) / rate * float64(time.Second)))
+}
+
+// setFactor changes the permitted share of the rate, continuing the
+// schedule from the next intended time. Callers hold l.mu.
+func (l *RateLimiter) setFactor(f float64) {
+	if !l.start.IsZero() {
+		l.start = l.next(l.rate * l.factor)
+		l.base = l.issued
+	}
+	l.factor = f
+}
+
+// Behind reports how far the generator is behind the ideal schedule: the
+// time the next request should already have gone out and the number of
+// requests that are overdue. Both are zero when the generator keeps up or
+// while the backend has asked us to pause.
+func (l *RateLimiter) Behind() (time.Duration, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.rate <= 0 || l.start.IsZero() || now.Before(l.pausedUntil) {
+		return 0, 0
+	}
+
+	rate := l.rate * l.factor
+	elapsed := now.Sub(l.start) - l.paused
+	expected := int64(elapsed.Seconds()*rate) + 1
+	missed := expected - (l.issued - l.base)
+	if missed <= 0 {
+		return 0, 0
+	}
+
+	return now.Sub(l.next(rate)) - l.paused, missed
+}
+
+// MaxLag returns the largest delay observed between a request's intended
+// send time and the moment it was released by the limiter.
+func (l *RateLimiter) MaxLag() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.maxLag
+}
+
+// Throttled returns the total time spent paused on the backend's request
+// and how many throttling responses were seen.
+func (l *RateLimiter) Throttled() (time.Duration, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.throttled, l.throttleEvents
+}
+
+// CurrentRate returns the rate, in requests per second, currently permitted
+// after backpressure adjustments. It is 0 when unlimited.
+func (l *RateLimiter) CurrentRate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate * l.factor
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSchedule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		n     int
+	}{
+		{"single token bucket", 200, 1, 5},
+		{"burst absorbs the first calls", 200, 5, 8},
+		{"fast rate", 1000, 10, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.rate, tt.burst)
+			interval := time.Duration(float64(time.Second) / tt.rate)
+
+			var first time.Time
+			for i := 0; i < tt.n; i++ {
+				intended, err := l.Wait(context.Background())
+				if err != nil {
+					t.Fatalf("Wait: %v", err)
+				}
+				if i == 0 {
+					first = intended
+					continue
+				}
+				want := first.Add(time.Duration(i) * interval)
+				if d := intended.Sub(want); d < -time.Microsecond || d > time.Microsecond {
+					t.Errorf("call %d intended %v after start, want %v", i, intended.Sub(first), want.Sub(first))
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPacing(t *testing.T) {
+	l := newRateLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first token is free, the next five take 10ms each.
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("6 tokens at 100/s took %v, want at least 50ms", elapsed)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := newRateLimiter(0, 1)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		if _, err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited limiter took %v for 1000 tokens", elapsed)
+	}
+	if rate := l.CurrentRate(); rate != 0 {
+		t.Errorf("CurrentRate = %v, want 0", rate)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	if _, err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(ctx); err == nil {
+		t.Fatal("Wait returned no error after the context expired")
+	}
+	if l.issued != 1 {
+		t.Errorf("issued = %d after a cancelled Wait, want 1", l.issued)
+	}
+}
+
+func TestRateLimiterThrottle(t *testing.T) {
+	tests := []struct {
+		name       string
+		throttles  []time.Duration
+		wantFactor float64
+		wantPaused time.Duration
+		wantEvents int64
+	}{
+		{"one 429 halves the rate", []time.Duration{30 * time.Millisecond}, 0.5, 30 * time.Millisecond, 1},
+		// Throttles within a second only halve the rate once and the
+		// pauses overlap rather than add up.
+		{"burst of 429s", []time.Duration{30 * time.Millisecond, 30 * time.Millisecond, 10 * time.Millisecond}, 0.5, 30 * time.Millisecond, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(100, 1)
+			if _, err := l.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			// Let the first adjustment through the once-per-second guard.
+			l.lastAdjust = time.Now().Add(-time.Second)
+
+			start := time.Now()
+			for _, d := range tt.throttles {
+				l.Throttle(d)
+			}
+			if got := l.CurrentRate(); got != 100*tt.wantFactor {
+				t.Errorf("CurrentRate = %v, want %v", got, 100*tt.wantFactor)
+			}
+			if _, err := l.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if waited := time.Since(start); waited < tt.wantPaused-2*time.Millisecond {
+				t.Errorf("Wait returned after %v, want the %v pause", waited, tt.wantPaused)
+			}
+
+			paused, events := l.Throttled()
+			if events != tt.wantEvents {
+				t.Errorf("throttle events = %d, want %d", events, tt.wantEvents)
+			}
+			if paused < tt.wantPaused-2*time.Millisecond || paused > tt.wantPaused+5*time.Millisecond {
+				t.Errorf("throttled time = %v, want about %v", paused, tt.wantPaused)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRecover(t *testing.T) {
+	l := newRateLimiter(100, 1)
+	if _, err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	l.factor = 0.5
+	l.lastAdjust = time.Now().Add(-rateRecoveryInterval)
+	if _, err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.CurrentRate(); got != 62.5 {
+		t.Errorf("CurrentRate after recovery = %v, want 62.5", got)
+	}
+}
+
+func TestRateLimiterThrottleKeepsSchedule(t *testing.T) {
+	l := newRateLimiter(100, 1)
+	first, err := l.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.lastAdjust = time.Now().Add(-time.Second)
+	l.Throttle(50 * time.Millisecond)
+
+	// The pause does not move the schedule: the held request keeps the
+	// time it was due at the old rate, the one after it follows at the
+	// halved rate.
+	want := []time.Duration{10 * time.Millisecond, 30 * time.Millisecond}
+	for i, offset := range want {
+		intended, err := l.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := intended.Sub(first); got < offset-time.Microsecond || got > offset+time.Microsecond {
+			t.Errorf("request %d intended %v after the first, want %v", i+2, got, offset)
+		}
+	}
+	if released := time.Since(first); released < 50*time.Millisecond {
+		t.Errorf("requests released %v after the first, before the 50ms pause ended", released)
+	}
+
+	// Time spent paused is not generator lag.
+	if lag := l.MaxLag(); lag > 20*time.Millisecond {
+		t.Errorf("MaxLag = %v, want the pause excluded", lag)
+	}
+	if lag, missed := l.Behind(); missed > 1 {
+		t.Errorf("Behind = %v, %d overdue, want the pause excluded", lag, missed)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayRecord is one historical order: when it was created and, when the
+// export has them, its number of line items and their variants.
+type replayRecord struct {
+	At        time.Time
+	LineItems int
+	Variants  []string
+}
+
+// replaySchedule reproduces the arrival pattern of a historical export.
+// Order n is sent at start + (At[n] - At[0]) / Speed.
+type replaySchedule struct {
+	Records []replayRecord
+	Speed   float64
+}
+
+// Span returns the wall-clock time the replay takes.
+func (s *replaySchedule) Span() time.Duration {
+	if len(s.Records) == 0 {
+		return 0
+	}
+	return time.Duration(float64(s.Records[len(s.Records)-1].At.Sub(s.Records[0].At)) / s.Speed)
+}
+
+// Peak returns the highest number of orders created within any window of
+// the given length in the original (uncompressed) timeline.
+func (s *replaySchedule) Peak(window time.Duration) int {
+	peak, lo := 0, 0
+	for hi, r := range s.Records {
+		for r.At.Sub(s.Records[lo].At) >= window {
+			lo++
+		}
+		peak = max(peak, hi-lo+1)
+	}
+	return peak
+}
+
+// pacer returns a Wait-like function that blocks until the next order is
+// due and returns its scheduled time. limiter is consulted afterwards so
+// that backpressure pauses still apply. Each call starts a new timeline,
+// so concurrent comparison runs each get their own.
+func (s *replaySchedule) pacer(limiter *RateLimiter) func(ctx context.Context) (time.Time, error) {
+	var start time.Time
+	next := 0
+	return func(ctx context.Context) (time.Time, error) {
+		if start.IsZero() {
+			start = time.Now()
+		}
+		if next >= len(s.Records) {
+			return time.Time{}, io.EOF
+		}
+
+		offset := s.Records[next].At.Sub(s.Records[0].At)
+		scheduled := start.Add(time.Duration(float64(offset) / s.Speed))
+		next++
+
+		if d := time.Until(scheduled); d > 0 {
+			if err := sleepContext(ctx, d); err != nil {
+				return scheduled, err
+			}
+		}
+		if _, err := limiter.Wait(ctx); err != nil {
+			return scheduled, err
+		}
+		return scheduled, nil
+	}
+}
+
+// order generates order orderID (1-based) shaped after its record and
+// created at its replayed time.
+func (s *replaySchedule) order(opts loadOptions, orderID int64, scheduled time.Time, stats *Stats) ShopifyOrder {
+	rec := s.Records[orderID-1]
+
+	profile := opts.Profile
+	if rec.LineItems > 0 {
+		n := min(rec.LineItems, maxLineItems)
+		profile.MinLineItems, profile.MaxLineItems = n, n
+	}
+	order := generateOrder(newOrderRand(opts.Seed, orderID), orderID, scheduled, profile, stats)
+
+	if len(rec.Variants) > 0 {
+		for i := range order.LineItems {
+			applyVariant(&order.LineItems[i], rec.Variants[i%len(rec.Variants)])
+		}
+	}
+	return order
+}
+
+// applyVariant switches a generated line item to the given variant,
+// including its print ready file. The variant has been validated by
+// loadReplay.
+func applyVariant(item *LineItem, variant string) {
+	idx := variantIndex(variant)
+	item.VariantTitle = &variants[idx]
+	item.Name = fmt.Sprintf("DTF Gangsheet %s", variants[idx])
+	for j := range item.Properties {
+		if item.Properties[j].Name == "_Print Ready File" {
+			item.Properties[j].Value = printReadyFiles[idx]
+		}
+	}
+}
+
+func variantIndex(variant string) int {
+	for i, v := range variants {
+		if v == variant {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadReplay reads a CSV or JSONL export of historical orders, picking the
+// format from the file extension. Records are sorted by creation time.
+//
+// CSV files may have a header naming the columns created_at (or timestamp,
+// time), line_items and variants; without one the columns are taken in
+// that order. Several variants are separated by '|'.
+//
+// JSONL lines look like
+//
+//	{"created_at": "2024-11-29T10:00:01-05:00", "line_items": 2, "variants": ["22x100", "22x200"]}
+//
+// with "variant" accepted for a single one. Timestamps are RFC 3339,
+// "2006-01-02 15:04:05", or Unix seconds or milliseconds.
+func loadReplay(path string) ([]replayRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []replayRecord
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		records, err = readReplayCSV(f)
+	} else {
+		records, err = readReplayJSONL(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no orders", path)
+	}
+
+	for i, r := range records {
+		for _, v := range r.Variants {
+			if variantIndex(v) < 0 {
+				return nil, fmt.Errorf("%s: order %d: unknown variant %q (available: %s)", path, i+1, v, strings.Join(variants, ", "))
+			}
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].At.Before(records[j].At) })
+	return records, nil
+}
+
+func readReplayCSV(r io.Reader) ([]replayRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	cols := map[string]int{"created_at": 0, "line_items": 1, "variants": 2}
+	if _, err := parseReplayTime(rows[0][0]); err != nil {
+		cols = map[string]int{}
+		for i, name := range rows[0] {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "created_at", "timestamp", "time":
+				cols["created_at"] = i
+			case "line_items", "line_item_count":
+				cols["line_items"] = i
+			case "variants", "variant":
+				cols["variants"] = i
+			}
+		}
+		if _, ok := cols["created_at"]; !ok {
+			return nil, fmt.Errorf("no created_at column in header %v", rows[0])
+		}
+		rows = rows[1:]
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	records := make([]replayRecord, 0, len(rows))
+	for n, row := range rows {
+		var rec replayRecord
+		if rec.At, err = parseReplayTime(field(row, "created_at")); err != nil {
+			return nil, fmt.Errorf("row %d: %w", n+1, err)
+		}
+		if v := field(row, "line_items"); v != "" {
+			if rec.LineItems, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("row %d: line_items: %w", n+1, err)
+			}
+		}
+		if v := field(row, "variants"); v != "" {
+			rec.Variants = strings.Split(v, "|")
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func readReplayJSONL(r io.Reader) ([]replayRecord, error) {
+	var records []replayRecord
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var raw struct {
+			CreatedAt json.RawMessage `json:"created_at"`
+			Timestamp json.RawMessage `json:"timestamp"`
+			LineItems int             `json:"line_items"`
+			Variants  []string        `json:"variants"`
+			Variant   string          `json:"variant"`
+		}
+		if err := json.Unmarshal([]byte(text), &raw); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		ts := raw.CreatedAt
+		if len(ts) == 0 {
+			ts = raw.Timestamp
+		}
+		at, err := parseReplayTime(strings.Trim(string(ts), `"`))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rec := replayRecord{At: at, LineItems: raw.LineItems, Variants: raw.Variants}
+		if raw.Variant != "" {
+			rec.Variants = append(rec.Variants, raw.Variant)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// parseReplayTime accepts the timestamp formats described in loadReplay.
+func parseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.Unix(0, int64(n*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
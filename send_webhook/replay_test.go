@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayTime(t *testing.T) {
+	want := time.Date(2024, 11, 29, 15, 0, 1, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "2024-11-29T10:00:01-05:00", want: want},
+		{in: "2024-11-29T15:00:01.000Z", want: want},
+		{in: "2024-11-29 15:00:01", want: want},
+		{in: "2024-11-29T15:00:01", want: want},
+		{in: "1732892401", want: want},
+		{in: "1732892401000", want: want},
+		{in: "1732892401.5", want: want.Add(500 * time.Millisecond)},
+		{in: "", wantErr: true},
+		{in: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseReplayTime(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseReplayTime(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("parseReplayTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadReplay(t *testing.T) {
+	at := func(sec int) time.Time { return time.Date(2024, 11, 29, 15, 0, sec, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []replayRecord
+		wantErr string
+	}{
+		{
+			name:    "csv with header",
+			file:    "orders.csv",
+			content: "variant,timestamp,line_item_count\n22x100,2024-11-29 15:00:02,2\n22x5|22x1000,2024-11-29 15:00:01,1\n",
+			want: []replayRecord{
+				{At: at(1), LineItems: 1, Variants: []string{"22x5", "22x1000"}},
+				{At: at(2), LineItems: 2, Variants: []string{"22x100"}},
+			},
+		},
+		{
+			name:    "csv without header",
+			file:    "orders.CSV",
+			content: "2024-11-29T15:00:01Z,3,22x90\n2024-11-29T15:00:02Z\n",
+			want: []replayRecord{
+				{At: at(1), LineItems: 3, Variants: []string{"22x90"}},
+				{At: at(2)},
+			},
+		},
+		{
+			name:    "jsonl",
+			file:    "orders.jsonl",
+			content: "{\"created_at\": \"2024-11-29T15:00:03Z\", \"line_items\": 2, \"variants\": [\"22x10\", \"22x200\"]}\n\n{\"timestamp\": 1732892401, \"variant\": \"22x20\"}\n",
+			want: []replayRecord{
+				{At: at(1), Variants: []string{"22x20"}},
+				{At: at(3), LineItems: 2, Variants: []string{"22x10", "22x200"}},
+			},
+		},
+		{name: "unknown variant", file: "orders.jsonl", content: `{"created_at": "2024-11-29T15:00:03Z", "variant": "22x7"}`, wantErr: `unknown variant "22x7"`},
+		{name: "bad line items", file: "orders.csv", content: "2024-11-29T15:00:01Z,many\n", wantErr: "line_items"},
+		{name: "no created_at column", file: "orders.csv", content: "variant,line_items\n22x100,1\n", wantErr: "no created_at column"},
+		{name: "bad json", file: "orders.jsonl", content: "{\n", wantErr: "line 1"},
+		{name: "empty", file: "orders.jsonl", content: "", wantErr: "no orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadReplay(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadReplay error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadReplay: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("loadReplay = %d records, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !got[i].At.Equal(tt.want[i].At) || got[i].LineItems != tt.want[i].LineItems || !reflect.DeepEqual(got[i].Variants, tt.want[i].Variants) {
+					t.Errorf("record %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayOrderVariants(t *testing.T) {
+	profile, err := lookupProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &replaySchedule{Speed: 1, Records: []replayRecord{
+		{At: time.Now(), LineItems: 3, Variants: []string{"22x5", "22x1000"}},
+	}}
+	order := s.order(loadOptions{Profile: profile, Seed: 1}, 1, time.Now(), &Stats{})
+
+	want := []string{"22x5", "22x1000", "22x5"}
+	if len(order.LineItems) != len(want) {
+		t.Fatalf("order has %d line items, want %d", len(order.LineItems), len(want))
+	}
+	for i, item := range order.LineItems {
+		if *item.VariantTitle != want[i] {
+			t.Errorf("line item %d variant = %s, want %s", i, *item.VariantTitle, want[i])
+		}
+		for _, p := range item.Properties {
+			if p.Name == "_Print Ready File" && !strings.HasSuffix(p.Value, "-"+want[i]+".png") {
+				t.Errorf("line item %d print file = %s, want the %s sample", i, p.Value, want[i])
+			}
+		}
+	}
+}
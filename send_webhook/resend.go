@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"test_webhook_service/config"
+	"test_webhook_service/httpclient"
+)
+
+// runResend implements `send_webhook resend`: it re-delivers the entries of
+// a dead-letter file, optionally filtered by error class or status, and
+// writes the ones that fail again to a new dead-letter file.
+func runResend(args []string) {
+	fs := flag.NewFlagSet("resend", flag.ExitOnError)
+	file := fs.String("file", "dead_letter.jsonl", "Dead-letter file to resend")
+	out := fs.String("out", "", "File entries that fail again are appended to (default <file>.retry.jsonl)")
+	classes := fs.String("class", "", "Only resend entries with these error classes, comma separated (e.g. http_500,timeout)")
+	statuses := fs.String("status", "", "Only resend entries with these HTTP statuses, comma separated")
+	targetURL := fs.String("url", "", "Send to this URL instead of the recorded one")
+	concurrency := fs.Int("concurrency", 10, "Number of concurrent workers")
+	ratePerSecond := fs.Float64("rps", 0, "Number of requests per second (0 = unlimited)")
+	contentEncoding := fs.String("content-encoding", "identity", "Compress webhook bodies: "+strings.Join(bodyEncodings, ", "))
+	dryRun := fs.Bool("dry-run", false, "Only list the entries that would be resent")
+	envName := fs.String("env", "", "Environment whose TLS settings are used (default $"+config.EnvName+" or the configured default)")
+	configPath := fs.String("config", "", "Environment config file (default $"+config.EnvFile+" or "+config.DefaultFile+")")
+	backpressure := fs.Bool("backpressure", true, "Pause and slow down resending when the backend answers 429 / Retry-After")
+	fs.DurationVar(&httpclient.MaxRetryAfter, "max-retry-after", httpclient.MaxRetryAfter, "Longest Retry-After pause honoured; longer ones are capped")
+	transportOpts := httpclient.Options{
+		MaxConnsPerHost:     100,
+		MaxIdleConnsPerHost: 100,
+	}
+	transportOpts.RegisterFlags(fs)
+	fs.Parse(args)
+
+	entries, err := readDeadLetters(*file)
+	if err != nil {
+		log.Fatalf("Failed to read dead letters: %v", err)
+	}
+
+	matchClass := splitSet(*classes)
+	matchStatus := splitSet(*statuses)
+
+	var selected []DeadLetter
+	for _, e := range entries {
+		if len(matchClass) > 0 && !matchClass[e.ErrorClass] {
+			continue
+		}
+		if len(matchStatus) > 0 && !matchStatus[strconv.Itoa(e.Status)] {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	log.Printf("Resend: %d of %d entries in %s match", len(selected), len(entries), *file)
+
+	if *dryRun {
+		for _, e := range selected {
+			log.Printf("order %d -> %s: %s (attempts %d)", e.OrderID, e.URL, e.ErrorClass, e.Attempts)
+		}
+		return
+	}
+	if len(selected) == 0 {
+		return
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(*file, ".jsonl") + ".retry.jsonl"
+	}
+	retry, err := openDeadLetterFile(*out)
+	if err != nil {
+		log.Fatalf("Failed to open dead-letter file: %v", err)
+	}
+
+	// The same environment and transport setup as a load run, so entries
+	// go out with the TLS settings they failed with.
+	_, env, err := loadEnvironment(*configPath, *envName, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err := newTransport(env, transportOpts)
+	if err != nil {
+		log.Fatalf("Failed to configure transport: %v", err)
+	}
+	client := &http.Client{Transport: transport, Timeout: 3 * time.Minute}
+
+	opts := loadOptions{
+		URL:             *targetURL,
+		Concurrency:     *concurrency,
+		ContentEncoding: *contentEncoding,
+		DeadLetter:      retry,
+		Backpressure:    *backpressure,
+	}
+	limiter := newRateLimiter(*ratePerSecond, 1)
+	stats := resendEntries(context.Background(), client, opts, limiter, selected)
+	if throttledFor, events := limiter.Throttled(); events > 0 {
+		log.Printf("Throttled: %d responses, paused %v", events, throttledFor.Round(time.Millisecond))
+	}
+
+	if err := retry.Close(); err != nil {
+		log.Printf("Failed to close %s: %v", *out, err)
+	}
+
+	log.Printf("\n=== Resend Results ===")
+	log.Printf("Resent: %d", atomic.LoadInt64(&stats.TotalRequests))
+	log.Printf("Delivered: %d", atomic.LoadInt64(&stats.SuccessRequests))
+	log.Printf("Failed Again: %d (written to %s)", atomic.LoadInt64(&stats.FailedRequests), *out)
+	for _, e := range stats.Errors.Sorted() {
+		log.Printf("Errors %s: %d", e.Class, e.Count)
+	}
+
+	if atomic.LoadInt64(&stats.FailedRequests) > 0 {
+		os.Exit(1)
+	}
+}
+
+// resendEntries delivers entries with opts.Concurrency workers paced by
+// limiter, which backs off like a load run when opts.Backpressure is set.
+// Each goes to its recorded URL unless opts.URL is set; failures are
+// dead-lettered to opts.DeadLetter.
+func resendEntries(ctx context.Context, client *http.Client, opts loadOptions, limiter *RateLimiter, entries []DeadLetter) *Stats {
+	stats := &Stats{}
+	jobs := make(chan DeadLetter)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for e := range jobs {
+				// Waiting here rather than when handing out entries
+				// holds back the next one as soon as a delivery is
+				// throttled.
+				if _, err := limiter.Wait(ctx); err != nil {
+					continue
+				}
+				entryOpts := opts
+				if entryOpts.URL == "" {
+					entryOpts.URL = e.URL
+				}
+
+				err := deliverWebhook(ctx, client, entryOpts, e.OrderID, e.Payload, e.Attempts+1, time.Time{}, stats)
+				atomic.AddInt64(&stats.TotalRequests, 1)
+				if err != nil {
+					log.Printf("Worker %d: Error resending order %d (attempt %d): %v", workerID, e.OrderID, e.Attempts+1, err)
+				}
+				backOff(limiter, opts, err)
+			}
+		}(i)
+	}
+
+	for _, e := range entries {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+	return stats
+}
+
+// splitSet turns a comma separated list into a set.
+func splitSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
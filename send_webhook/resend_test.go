@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test_webhook_service/httpclient"
+)
+
+// writeDeadLetters writes entries to a dead-letter file in a temporary
+// directory and returns its path.
+func writeDeadLetters(t *testing.T, entries ...DeadLetter) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	dl, err := openDeadLetterFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := dl.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResendUsesEnvironmentTLS(t *testing.T) {
+	var delivered int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&delivered, 1)
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := fmt.Sprintf(`{"environments": {"staging": {"base_url": %q, "tls": {"ca_file": %q}}}}`, srv.URL, ca)
+	cfgPath := filepath.Join(dir, "environments.json")
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file := writeDeadLetters(t, DeadLetter{URL: srv.URL, OrderID: 1, Payload: json.RawMessage(`{"id":1}`), Attempts: 1})
+
+	// Fails the test binary with exit status 1 if the delivery fails.
+	runResend([]string{"-file", file, "-config", cfgPath, "-env", "staging"})
+
+	if n := atomic.LoadInt64(&delivered); n != 1 {
+		t.Errorf("server received %d deliveries, want 1", n)
+	}
+}
+
+func TestResendBackpressure(t *testing.T) {
+	saved := httpclient.MaxRetryAfter
+	httpclient.MaxRetryAfter = 100 * time.Millisecond
+	t.Cleanup(func() { httpclient.MaxRetryAfter = saved })
+
+	tests := []struct {
+		name         string
+		backpressure bool
+		wantEvents   int64
+	}{
+		{"on", true, 1},
+		{"off", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var arrivals []time.Time
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				arrivals = append(arrivals, time.Now())
+				first := len(arrivals) == 1
+				mu.Unlock()
+				if first {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			t.Cleanup(srv.Close)
+
+			entries := []DeadLetter{
+				{URL: srv.URL, OrderID: 1, Payload: json.RawMessage(`{"id":1}`)},
+				{URL: srv.URL, OrderID: 2, Payload: json.RawMessage(`{"id":2}`)},
+			}
+			opts := loadOptions{Concurrency: 1, ContentEncoding: "identity", Backpressure: tt.backpressure}
+			limiter := newRateLimiter(0, 1)
+			stats := resendEntries(context.Background(), srv.Client(), opts, limiter, entries)
+
+			if got := atomic.LoadInt64(&stats.Throttled); got != 1 {
+				t.Errorf("stats.Throttled = %d, want 1", got)
+			}
+			throttledFor, events := limiter.Throttled()
+			if events != tt.wantEvents {
+				t.Errorf("limiter throttled %d times, want %d", events, tt.wantEvents)
+			}
+			if tt.backpressure {
+				if throttledFor != httpclient.MaxRetryAfter {
+					t.Errorf("paused for %v, want the capped Retry-After %v", throttledFor, httpclient.MaxRetryAfter)
+				}
+				if gap := arrivals[1].Sub(arrivals[0]); gap < httpclient.MaxRetryAfter {
+					t.Errorf("second entry sent %v after the 429, want at least %v", gap, httpclient.MaxRetryAfter)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"test_webhook_service/har"
+	"test_webhook_service/httpclient"
+	"test_webhook_service/soak"
+)
+
+func TestInstrumentSoakAndHAR(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+
+	transport, err := newTransport(nil, httpclient.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := soak.NewMonitor(time.Hour)
+	sampler := har.NewSampler("send_webhook", time.Minute, 10)
+	client := &http.Client{Transport: instrument(transport, monitor, sampler)}
+
+	done := make(chan error)
+	go func() {
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+
+	// Sample while the request is in flight: its connection is open but
+	// not idle.
+	<-arrived
+	monitor.Start()
+	monitor.Stop()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	s := monitor.Samples()[0]
+	if s.OpenConns != 1 || s.IdleConns != 0 {
+		t.Errorf("sample during the request has %d open and %d idle connections, want 1 and 0", s.OpenConns, s.IdleConns)
+	}
+	if kept, _ := sampler.Len(); kept != 1 {
+		t.Errorf("HAR sampler kept %d entries, want the failed request", kept)
+	}
+}
@@ -0,0 +1,86 @@
+package soak
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// windows is how many consecutive windows the samples are split into;
+	// a metric is flagged when its minimum rises in every one of them.
+	windows = 5
+	// minSamples is the number of samples needed, after warm-up, before
+	// growth is judged at all.
+	minSamples = 10
+)
+
+// metric is one sampled quantity together with the growth that is
+// considered significant for it, so that a heap creeping up by a few
+// kilobytes or one extra goroutine is not reported.
+type metric struct {
+	name      string
+	value     func(Sample) float64
+	minGrowth float64 // absolute increase from the first to the last window
+	format    func(float64) string
+}
+
+func count(v float64) string { return strconv.FormatInt(int64(v), 10) }
+
+var metrics = []metric{
+	{name: "goroutines", value: func(s Sample) float64 { return float64(s.Goroutines) }, minGrowth: 10, format: count},
+	{name: "heap", value: func(s Sample) float64 { return float64(s.HeapAlloc) }, minGrowth: 8 << 20, format: func(v float64) string { return formatBytes(uint64(v)) }},
+	{name: "heap objects", value: func(s Sample) float64 { return float64(s.HeapObjs) }, minGrowth: 50000, format: count},
+	{name: "fds", value: func(s Sample) float64 { return float64(s.FDs) }, minGrowth: 10, format: count},
+	{name: "open conns", value: func(s Sample) float64 { return float64(s.OpenConns) }, minGrowth: 10, format: count},
+	{name: "idle conns", value: func(s Sample) float64 { return float64(s.IdleConns) }, minGrowth: 10, format: count},
+}
+
+// Growth describes a metric that rose across the whole run.
+type Growth struct {
+	Metric string
+	From   string
+	To     string
+}
+
+func (g Growth) String() string {
+	return fmt.Sprintf("from %s to %s", g.From, g.To)
+}
+
+// Growing returns the metrics whose minimum rose in every window after
+// the warm-up. Using window minima rather than raw samples ignores bursts
+// that are released again, such as connections opened for a spike or
+// garbage between collections; only a floor that keeps rising counts.
+func (m *Monitor) Growing() []Growth {
+	samples := m.Samples()
+	// The first tenth of the run is warm-up: pools fill and caches grow.
+	samples = samples[len(samples)/10:]
+	if len(samples) < minSamples {
+		return nil
+	}
+
+	var growing []Growth
+	for _, metric := range metrics {
+		floors := make([]float64, windows)
+		for w := range floors {
+			lo, hi := w*len(samples)/windows, (w+1)*len(samples)/windows
+			floor := metric.value(samples[lo])
+			for _, s := range samples[lo:hi] {
+				floor = min(floor, metric.value(s))
+			}
+			floors[w] = floor
+		}
+
+		rising := floors[0] >= 0
+		for w := 1; w < windows && rising; w++ {
+			rising = floors[w] > floors[w-1]
+		}
+		if rising && floors[windows-1]-floors[0] >= metric.minGrowth {
+			growing = append(growing, Growth{
+				Metric: metric.name,
+				From:   metric.format(floors[0]),
+				To:     metric.format(floors[windows-1]),
+			})
+		}
+	}
+	return growing
+}
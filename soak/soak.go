@@ -0,0 +1,256 @@
+// Package soak samples the resource usage of a long running client and
+// flags metrics that keep growing, which points at leaks in the client
+// itself: goroutines stuck on unclosed response bodies, connections that
+// are never reused, or memory that is never released.
+package soak
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sample is one measurement of the process.
+type Sample struct {
+	Time       time.Time
+	Goroutines int
+	HeapAlloc  uint64 // bytes of heap marked live by the last collection
+	HeapObjs   uint64 // heap objects, including garbage not yet swept
+	FDs        int    // -1 where /proc/self/fd is not available
+	OpenConns  int64
+	IdleConns  int64
+}
+
+// Monitor samples the process at a fixed interval.
+type Monitor struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	samples []Sample
+
+	openConns int64
+	inFlight  int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewMonitor creates a monitor sampling every interval.
+func NewMonitor(interval time.Duration) *Monitor {
+	return &Monitor{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Track counts the connections t dials and the requests it carries so
+// that open and idle connections can be sampled. It must be called before
+// the transport is used.
+func (m *Monitor) Track(t *http.Transport) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&m.openConns, 1)
+		return &trackedConn{Conn: conn, open: &m.openConns}, nil
+	}
+}
+
+// Transport wraps next to count requests in flight. Connections that are
+// open but carry no request are reported as idle; with HTTP/2 several
+// requests share a connection, so the idle count is a lower bound there.
+func (m *Monitor) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+		return next.RoundTrip(req)
+	})
+}
+
+// Start begins sampling in the background. Each sample is also logged.
+func (m *Monitor) Start() {
+	m.sample()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				s := m.sample()
+				log.Printf("Soak: goroutines=%d heap=%s objects=%d fds=%d conns=%d idle=%d",
+					s.Goroutines, formatBytes(s.HeapAlloc), s.HeapObjs, s.FDs, s.OpenConns, s.IdleConns)
+				for _, g := range m.Growing() {
+					log.Printf("Soak: %s keeps growing: %s", g.Metric, g)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends sampling and takes a final sample.
+func (m *Monitor) Stop() {
+	close(m.stop)
+	<-m.done
+	m.sample()
+}
+
+// Samples returns a copy of all samples taken.
+func (m *Monitor) Samples() []Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Sample(nil), m.samples...)
+}
+
+// heapMetrics are read instead of runtime.MemStats: the live heap figure
+// comes from the collector's last cycle, so sampling neither forces a
+// collection nor stops the world. Until the first cycle the occupied heap,
+// garbage included, stands in for it.
+var heapMetrics = []string{"/gc/heap/live:bytes", "/gc/heap/objects:objects", "/memory/classes/heap/objects:bytes"}
+
+func (m *Monitor) sample() Sample {
+	heap := make([]rtmetrics.Sample, len(heapMetrics))
+	for i, name := range heapMetrics {
+		heap[i].Name = name
+	}
+	rtmetrics.Read(heap)
+
+	open := atomic.LoadInt64(&m.openConns)
+	s := Sample{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  cmp.Or(heapValue(heap[0]), heapValue(heap[2])),
+		HeapObjs:   heapValue(heap[1]),
+		FDs:        countFDs(),
+		OpenConns:  open,
+		IdleConns:  max(0, open-atomic.LoadInt64(&m.inFlight)),
+	}
+
+	m.mu.Lock()
+	m.samples = append(m.samples, s)
+	m.mu.Unlock()
+	return s
+}
+
+func heapValue(s rtmetrics.Sample) uint64 {
+	if s.Value.Kind() != rtmetrics.KindUint64 {
+		return 0
+	}
+	return s.Value.Uint64()
+}
+
+// WriteCSV writes every sample to path.
+func (m *Monitor) WriteCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", "goroutines", "heap_alloc", "heap_objects", "fds", "open_conns", "idle_conns"})
+	for _, s := range m.Samples() {
+		w.Write([]string{
+			s.Time.Format(time.RFC3339),
+			strconv.Itoa(s.Goroutines),
+			strconv.FormatUint(s.HeapAlloc, 10),
+			strconv.FormatUint(s.HeapObjs, 10),
+			strconv.Itoa(s.FDs),
+			strconv.FormatInt(s.OpenConns, 10),
+			strconv.FormatInt(s.IdleConns, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Report logs the first, last and peak value of every metric followed by
+// the metrics that grew throughout the run.
+func (m *Monitor) Report() {
+	samples := m.Samples()
+	if len(samples) == 0 {
+		return
+	}
+
+	log.Printf("\n=== Soak Results ===")
+	log.Printf("Samples: %d over %v", len(samples), samples[len(samples)-1].Time.Sub(samples[0].Time).Round(time.Second))
+	for _, metric := range metrics {
+		first, last := metric.value(samples[0]), metric.value(samples[len(samples)-1])
+		peak := first
+		for _, s := range samples {
+			peak = max(peak, metric.value(s))
+		}
+		log.Printf("%-12s start=%s end=%s peak=%s", metric.name, metric.format(first), metric.format(last), metric.format(peak))
+	}
+
+	growing := m.Growing()
+	if len(growing) == 0 {
+		log.Printf("No monotonic growth detected")
+		return
+	}
+	for _, g := range growing {
+		log.Printf("LEAK SUSPECTED: %s grew %s", g.Metric, g)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// trackedConn decrements the open connection count once when closed.
+type trackedConn struct {
+	net.Conn
+	open   *int64
+	closed int32
+}
+
+func (c *trackedConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(c.open, -1)
+	}
+	return c.Conn.Close()
+}
+
+func countFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
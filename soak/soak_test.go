@@ -0,0 +1,58 @@
+package soak
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestSampleDoesNotCollect(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	m := NewMonitor(time.Hour)
+	for i := 0; i < 5; i++ {
+		s := m.sample()
+		if s.HeapAlloc == 0 || s.HeapObjs == 0 || s.Goroutines == 0 {
+			t.Fatalf("sample = %+v, want heap and goroutine figures", s)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if after.NumGC != before.NumGC {
+		t.Errorf("sampling ran %d collections", after.NumGC-before.NumGC)
+	}
+}
+
+func TestGrowing(t *testing.T) {
+	tests := []struct {
+		name  string
+		value func(i int) int
+		want  bool
+	}{
+		{"flat", func(i int) int { return 50 }, false},
+		{"steady leak", func(i int) int { return 50 + i }, true},
+		{"leak below threshold", func(i int) int { return 50 + i/20 }, false},
+		{"bursts released again", func(i int) int { return 50 + 40*(i%2) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMonitor(time.Second)
+			for i := 0; i < 100; i++ {
+				m.samples = append(m.samples, Sample{Goroutines: tt.value(i), HeapAlloc: 1 << 20, HeapObjs: 1000})
+			}
+			got := false
+			for _, g := range m.Growing() {
+				if g.Metric == "goroutines" {
+					got = true
+				} else {
+					t.Errorf("unexpected growth of %s: %s", g.Metric, g)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("goroutines growing = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// batchSize spans are sent together, or whatever has queued up after
+	// flushInterval.
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+	// queueSize bounds memory when the collector is slow; spans beyond it
+	// are dropped rather than slowing down the load.
+	queueSize = 8192
+)
+
+// exporter batches spans in the background and writes them as OTLP/JSON.
+type exporter struct {
+	service  string
+	endpoint string
+	file     *os.File
+	client   *http.Client
+
+	queue   chan *Span
+	done    chan struct{}
+	once    sync.Once
+	errMu   sync.Mutex
+	err     error
+	dropped int64
+}
+
+func newExporter(service string, o Options) (*exporter, error) {
+	e := &exporter{
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan *Span, queueSize),
+		done:    make(chan struct{}),
+	}
+	if o.Endpoint != "" {
+		e.endpoint = strings.TrimSuffix(o.Endpoint, "/") + "/v1/traces"
+	}
+	if o.File != "" {
+		f, err := os.OpenFile(o.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		e.file = f
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *exporter) add(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// close flushes the queue and returns the first export error.
+func (e *exporter) close() error {
+	e.once.Do(func() {
+		close(e.queue)
+		<-e.done
+		if e.file != nil {
+			if err := e.file.Close(); err != nil {
+				e.setErr(err)
+			}
+		}
+	})
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	return e.err
+}
+
+func (e *exporter) setErr(err error) {
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *exporter) export(batch []*Span) {
+	data, err := json.Marshal(e.request(batch))
+	if err != nil {
+		e.setErr(err)
+		return
+	}
+
+	if e.file != nil {
+		if _, err := e.file.Write(append(data, '\n')); err != nil {
+			e.setErr(err)
+		}
+	}
+	if e.endpoint != "" {
+		if err := e.post(data); err != nil {
+			atomic.AddInt64(&e.dropped, int64(len(batch)))
+			e.setErr(err)
+		}
+	}
+}
+
+func (e *exporter) post(data []byte) error {
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The types below are the OTLP/JSON encoding of an
+// ExportTraceServiceRequest: ids are hex, 64-bit integers are strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+const (
+	spanKindClient    = 3
+	statusCodeOK      = 1
+	statusCodeError   = 2
+	instrumentationID = "test_webhook_service/tracing"
+)
+
+func (e *exporter) request(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              spanKindClient,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+			Status:            otlpStatus{Code: statusCodeOK},
+		}
+		if s.Failed {
+			span.Status = otlpStatus{Code: statusCodeError, Message: s.Err}
+		}
+		spans[i] = span
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: attributes(map[string]interface{}{
+			"service.name": e.service,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: instrumentationID},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func attributes(attrs map[string]interface{}) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var val otlpValue
+		switch v := v.(type) {
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			val.IntValue = &s
+		default:
+			s := fmt.Sprint(v)
+			val.StringValue = &s
+		}
+		out = append(out, otlpKeyValue{Key: k, Value: val})
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+// Package tracing injects W3C trace context into outgoing requests and
+// records a client span for each of them, so a slow or failed call can be
+// found in the backend's traces. Spans are exported as OTLP/JSON, either to
+// a collector over HTTP or to a file; only the standard library is used.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Options select where spans are exported. Both may be set.
+type Options struct {
+	// Endpoint is an OTLP/HTTP collector base URL such as
+	// http://localhost:4318; spans are posted to Endpoint/v1/traces.
+	Endpoint string
+	// File receives one OTLP/JSON export request per line.
+	File string
+}
+
+// RegisterFlags adds the options to fs. The endpoint defaults to
+// $OTEL_EXPORTER_OTLP_ENDPOINT.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	if o.Endpoint == "" {
+		o.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	fs.StringVar(&o.Endpoint, "otlp-endpoint", o.Endpoint, "OTLP/HTTP collector to export client spans to, e.g. http://localhost:4318")
+	fs.StringVar(&o.File, "trace-file", o.File, "File to write client spans to as OTLP/JSON lines")
+}
+
+// Enabled reports whether any exporter is configured.
+func (o Options) Enabled() bool {
+	return o.Endpoint != "" || o.File != ""
+}
+
+// Tracer records spans and hands them to the exporter. A nil *Tracer is
+// valid and does nothing, so callers need not check whether tracing is on.
+type Tracer struct {
+	exporter *exporter
+	spans    int64
+}
+
+// New starts a tracer for the named service, or returns nil when no
+// exporter is configured.
+func New(service string, o Options) (*Tracer, error) {
+	if !o.Enabled() {
+		return nil, nil
+	}
+	exp, err := newExporter(service, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracer{exporter: exp}, nil
+}
+
+// Transport wraps next so that every request carries a traceparent header
+// and produces a client span.
+func (t *Tracer) Transport(next http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next, tracer: t}
+}
+
+// Shutdown flushes the remaining spans and reports how many were recorded
+// and how many were dropped because the exporter fell behind or failed.
+func (t *Tracer) Shutdown() (int64, int64, error) {
+	if t == nil {
+		return 0, 0, nil
+	}
+	err := t.exporter.close()
+	return atomic.LoadInt64(&t.spans), atomic.LoadInt64(&t.exporter.dropped), err
+}
+
+// Span is one finished client call.
+type Span struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        string
+	Failed     bool
+}
+
+// TraceParent formats the span as a W3C traceparent header value.
+func (s *Span) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.TraceID[:]), hex.EncodeToString(s.SpanID[:]))
+}
+
+type transport struct {
+	next   http.RoundTripper
+	tracer *Tracer
+}
+
+type routeKey struct{}
+
+// WithRoute tells the transport the route template of the requests made
+// with ctx, such as /orders/{order_id}/designer, for the span name.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// Route turns a request path into a low-cardinality template by replacing
+// segments that look like IDs or object keys with {id}: numbers, and any
+// segment longer than three characters that contains a digit.
+func Route(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if seg == "" || !strings.ContainsAny(seg, "0123456789") {
+			continue
+		}
+		if len(seg) > 3 || strings.Trim(seg, "0123456789") == "" {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// fullURL is u without the query string, which holds presigned URL
+// signatures, and without user info.
+func fullURL(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	clean.RawQuery = ""
+	clean.ForceQuery = false
+	clean.Fragment = ""
+	clean.RawFragment = ""
+	return clean.String()
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route, ok := req.Context().Value(routeKey{}).(string)
+	if !ok {
+		route = Route(req.URL.Path)
+	}
+	span := &Span{
+		Name:  req.Method + " " + route,
+		Start: time.Now(),
+		Attributes: map[string]interface{}{
+			"http.request.method": req.Method,
+			"http.route":          route,
+			"url.full":            fullURL(req.URL),
+			"server.address":      req.URL.Hostname(),
+		},
+	}
+	rand.Read(span.TraceID[:])
+	rand.Read(span.SpanID[:])
+	if req.ContentLength > 0 {
+		span.Attributes["http.request.body.size"] = req.ContentLength
+	}
+
+	// A RoundTripper must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", span.TraceParent())
+
+	resp, err := t.next.RoundTrip(req)
+	span.End = time.Now()
+	switch {
+	case err != nil:
+		span.Failed = true
+		span.Err = err.Error()
+		span.Attributes["error.type"] = fmt.Sprintf("%T", err)
+	default:
+		span.Attributes["http.response.status_code"] = int64(resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			span.Failed = true
+			span.Attributes["error.type"] = fmt.Sprint(resp.StatusCode)
+		}
+	}
+
+	atomic.AddInt64(&t.tracer.spans, 1)
+	t.tracer.exporter.add(span)
+	return resp, err
+}
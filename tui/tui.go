@@ -0,0 +1,307 @@
+// Package tui renders a minimal full-screen dashboard using plain ANSI
+// escape sequences, so the load test tools can show live state instead of
+// scrolling log lines.
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	enterAltScreen = "\x1b[?1049h"
+	leaveAltScreen = "\x1b[?1049l"
+	hideCursor     = "\x1b[?25l"
+	showCursor     = "\x1b[?25h"
+	cursorHome     = "\x1b[H"
+	clearLine      = "\x1b[K"
+	clearBelow     = "\x1b[J"
+)
+
+// Screen owns the terminal while the dashboard is running. Log output is
+// captured into a ring buffer and shown at the bottom of every frame.
+type Screen struct {
+	out       *bufio.Writer
+	logs      *LogBuffer
+	prevLog   io.Writer
+	prevFlags int
+	started   bool
+	mu        sync.Mutex
+}
+
+// NewScreen creates a screen writing to stdout that keeps the last logLines
+// log lines.
+func NewScreen(logLines int) *Screen {
+	return &Screen{
+		out:  bufio.NewWriter(os.Stdout),
+		logs: NewLogBuffer(logLines),
+	}
+}
+
+// Start switches to the alternate screen and redirects the standard logger.
+func (s *Screen) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prevLog = log.Writer()
+	s.prevFlags = log.Flags()
+	log.SetOutput(s.logs)
+	log.SetFlags(log.Ltime)
+
+	s.out.WriteString(enterAltScreen + hideCursor)
+	s.out.Flush()
+	s.started = true
+}
+
+// Stop restores the terminal and the standard logger. It may be called
+// more than once, so callers can defer it and still stop the screen early
+// to print their final report.
+func (s *Screen) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return
+	}
+	s.started = false
+
+	s.out.WriteString(showCursor + leaveAltScreen)
+	s.out.Flush()
+
+	if s.prevLog != nil {
+		log.SetOutput(s.prevLog)
+		log.SetFlags(s.prevFlags)
+	}
+}
+
+// Draw replaces the screen content with lines followed by the most recent
+// log lines. Lines that do not fit the terminal are cut, the last row
+// counting them.
+func (s *Screen) Draw(lines []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	width, height := Size()
+	// Every row ends in a newline; one more than height-1 would scroll.
+	lines = Clip(lines, height-1, func(n int) string {
+		return fmt.Sprintf(" +%d more lines", n)
+	})
+
+	s.out.WriteString(cursorHome)
+	for _, line := range lines {
+		s.out.WriteString(truncate(line, width) + clearLine + "\n")
+	}
+
+	remaining := height - len(lines) - 2
+	if remaining > 0 {
+		s.out.WriteString(Rule("Log", width) + clearLine + "\n")
+		for _, line := range s.logs.Last(remaining) {
+			s.out.WriteString(truncate(line, width) + clearLine + "\n")
+		}
+	}
+	s.out.WriteString(clearBelow)
+	s.out.Flush()
+}
+
+// Run redraws the screen every interval with the lines returned by frame
+// until done is closed.
+func (s *Screen) Run(interval time.Duration, done <-chan struct{}, frame func() []string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.Draw(frame())
+	for {
+		select {
+		case <-done:
+			s.Draw(frame())
+			return
+		case <-ticker.C:
+			s.Draw(frame())
+		}
+	}
+}
+
+// Clip returns at most n of lines. When some do not fit, the last line
+// kept is replaced by summary of the count of lines left out, itself
+// included.
+func Clip(lines []string, n int, summary func(hidden int) string) []string {
+	if n <= 0 {
+		return nil
+	}
+	if len(lines) <= n {
+		return lines
+	}
+	return append(lines[:n-1:n-1], summary(len(lines)-n+1))
+}
+
+// Size returns the size of the terminal on stdout. When stdout is not a
+// terminal it is taken from COLUMNS and LINES, falling back to 120x40.
+func Size() (int, int) {
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 && h > 0 {
+		return w, h
+	}
+	width, height := 120, 40
+	if v, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && v > 0 {
+		width = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LINES")); err == nil && v > 0 {
+		height = v
+	}
+	return width, height
+}
+
+// Rule returns a horizontal separator with a title.
+func Rule(title string, width int) string {
+	head := "── " + title + " "
+	n := width - len([]rune(head))
+	if n < 0 {
+		n = 0
+	}
+	return head + strings.Repeat("─", n)
+}
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values as a single line of block characters scaled to
+// the largest value. Negative and NaN values are drawn as the lowest block.
+func Sparkline(values []float64) string {
+	var maxVal float64
+	for _, v := range values {
+		if v > maxVal && !math.IsInf(v, 1) {
+			maxVal = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if maxVal > 0 && v > 0 {
+			idx = int(math.Min(v/maxVal, 1) * float64(len(sparkTicks)-1))
+		}
+		b.WriteRune(sparkTicks[idx])
+	}
+	return b.String()
+}
+
+// Bar renders a horizontal bar of the given width filled proportionally.
+func Bar(value, total float64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(value / total * float64(width))
+	}
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
+
+// LogBuffer is an io.Writer keeping the last N lines written to it.
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	size  int
+	next  int
+	full  bool
+}
+
+// NewLogBuffer creates a buffer holding size lines.
+func NewLogBuffer(size int) *LogBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &LogBuffer{lines: make([]string, size), size: size}
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % b.size
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+	return len(p), nil
+}
+
+// Last returns up to n of the most recent lines, oldest first.
+func (b *LogBuffer) Last(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := b.next
+	if b.full {
+		count = b.size
+	}
+	if n > count {
+		n = count
+	}
+
+	out := make([]string, 0, n)
+	for i := n; i > 0; i-- {
+		out = append(out, b.lines[(b.next-i+b.size)%b.size])
+	}
+	return out
+}
+
+// Series is a fixed-length sliding window of samples for sparklines.
+type Series struct {
+	mu     sync.Mutex
+	values []float64
+	size   int
+}
+
+// NewSeries creates a window of size samples.
+func NewSeries(size int) *Series {
+	return &Series{size: size}
+}
+
+// Add appends a sample, dropping the oldest once the window is full.
+func (s *Series) Add(v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values = append(s.values, v)
+	if len(s.values) > s.size {
+		s.values = s.values[len(s.values)-s.size:]
+	}
+}
+
+// Values returns a copy of the current window.
+func (s *Series) Values() []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]float64(nil), s.values...)
+}
+
+// FormatDuration prints d rounded to a resolution suited for a dashboard.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	default:
+		return d.Round(100 * time.Microsecond).String()
+	}
+}
@@ -0,0 +1,147 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"all zero", []float64{0, 0, 0}, "▁▁▁"},
+		{"scaled to max", []float64{0, 3.5, 7}, "▁▄█"},
+		{"negative", []float64{-5, 1}, "▁█"},
+		{"NaN", []float64{math.NaN(), 2}, "▁█"},
+		{"infinity", []float64{math.Inf(1), 2, math.Inf(-1)}, "██▁"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sparkline(tt.values); got != tt.want {
+				t.Errorf("Sparkline(%v) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBar(t *testing.T) {
+	tests := []struct {
+		value, total float64
+		width        int
+		want         string
+	}{
+		{0, 0, 4, "░░░░"},
+		{1, 2, 4, "██░░"},
+		{5, 2, 4, "████"},
+	}
+	for _, tt := range tests {
+		if got := Bar(tt.value, tt.total, tt.width); got != tt.want {
+			t.Errorf("Bar(%v, %v, %d) = %q, want %q", tt.value, tt.total, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := NewLogBuffer(3)
+	b.Write([]byte("a\n"))
+	b.Write([]byte("b\nc\nd\n"))
+
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{1, []string{"d"}},
+		{3, []string{"b", "c", "d"}},
+		{10, []string{"b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		got := b.Last(tt.n)
+		if len(got) != len(tt.want) {
+			t.Fatalf("Last(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Last(%d) = %q, want %q", tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestScreenStopTwice(t *testing.T) {
+	prev := log.Writer()
+	s := NewScreen(10)
+	// Stop before Start and a repeated Stop must not touch the logger.
+	s.Stop()
+	s.Start()
+	if log.Writer() == prev {
+		t.Fatal("Start did not capture the standard logger")
+	}
+	s.Stop()
+	s.Stop()
+	if log.Writer() != prev {
+		t.Error("Stop did not restore the standard logger")
+	}
+}
+
+func TestClip(t *testing.T) {
+	lines := []string{"a", "b", "c", "d"}
+	more := func(n int) string { return fmt.Sprintf("+%d", n) }
+	tests := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{"fits", 4, []string{"a", "b", "c", "d"}},
+		{"room to spare", 10, []string{"a", "b", "c", "d"}},
+		{"one over", 3, []string{"a", "b", "+2"}},
+		{"only the summary", 1, []string{"+4"}},
+		{"no room", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Clip(lines, tt.n, more)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || len(got) != len(tt.want) {
+				t.Errorf("Clip(%d) = %q, want %q", tt.n, got, tt.want)
+			}
+		})
+	}
+	if lines[2] != "c" {
+		t.Errorf("Clip overwrote its input: %q", lines)
+	}
+}
+
+func TestDrawClipsToHeight(t *testing.T) {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		t.Skip("terminal size comes from stdout, not LINES")
+	}
+	t.Setenv("COLUMNS", "40")
+	t.Setenv("LINES", "10")
+
+	var out bytes.Buffer
+	s := &Screen{out: bufio.NewWriter(&out), logs: NewLogBuffer(10)}
+	lines := make([]string, 30)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("row %d", i)
+	}
+	s.Draw(lines)
+
+	rows := strings.Split(strings.TrimSuffix(out.String(), clearBelow), "\n")
+	if n := strings.Count(out.String(), "\n"); n != 9 {
+		t.Errorf("drew %d rows on a 10 line terminal, want 9", n)
+	}
+	if last := rows[len(rows)-2]; !strings.HasPrefix(last, " +22 more lines") {
+		t.Errorf("last row = %q, want the count of the 22 cut lines", last)
+	}
+}